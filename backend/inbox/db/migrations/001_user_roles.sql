@@ -0,0 +1,5 @@
+-- user-001: user roles.
+USE msg_demo;
+
+ALTER TABLE users
+  ADD COLUMN role ENUM('user','admin','service') NOT NULL DEFAULT 'user' AFTER password_hash;
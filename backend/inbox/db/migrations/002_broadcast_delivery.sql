@@ -0,0 +1,12 @@
+-- user-002: broadcast audiences and resumable delivery progress.
+USE msg_demo;
+
+ALTER TABLE system_notifications
+  ADD COLUMN audience ENUM('single','all','users','segment') NOT NULL DEFAULT 'single' AFTER created_by,
+  ADD COLUMN segment VARCHAR(32) NULL AFTER audience,
+  ADD COLUMN delivery_status ENUM('pending','running','completed','failed') NOT NULL DEFAULT 'completed' AFTER segment,
+  ADD COLUMN target_total INT NOT NULL DEFAULT 0 AFTER delivery_status,
+  ADD COLUMN delivered_count INT NOT NULL DEFAULT 0 AFTER target_total,
+  ADD COLUMN delivered_until BIGINT NOT NULL DEFAULT 0 AFTER delivered_count,
+  ADD COLUMN lease_expires_at DATETIME NULL AFTER delivered_until,
+  ADD COLUMN finished_at DATETIME NULL AFTER lease_expires_at;
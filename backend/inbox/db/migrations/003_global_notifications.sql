@@ -0,0 +1,12 @@
+-- user-003: global notifications and the per-user read watermark.
+USE msg_demo;
+
+ALTER TABLE system_notifications
+  MODIFY COLUMN audience ENUM('single','all','users','segment','global') NOT NULL DEFAULT 'single',
+  ADD INDEX idx_system_notifications_audience (audience, created_at);
+
+CREATE TABLE IF NOT EXISTS system_notification_watermarks (
+  user_id BIGINT NOT NULL PRIMARY KEY,
+  read_until DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
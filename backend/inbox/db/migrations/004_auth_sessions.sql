@@ -0,0 +1,22 @@
+-- user-004: refresh token sessions and revoked access tokens.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+  id CHAR(32) NOT NULL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  refresh_hash CHAR(64) NOT NULL,
+  previous_hash CHAR(64) NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_auth_sessions_refresh (refresh_hash),
+  INDEX idx_auth_sessions_previous (previous_hash),
+  INDEX idx_auth_sessions_user (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti CHAR(32) NOT NULL PRIMARY KEY,
+  expires_at DATETIME NOT NULL,
+  INDEX idx_revoked_tokens_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,10 @@
+-- user-006: reply threads. Messages sent before threads existed each start
+-- a thread of their own.
+USE msg_demo;
+
+ALTER TABLE direct_messages
+  ADD COLUMN thread_id BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER content,
+  ADD COLUMN reply_to_id BIGINT UNSIGNED NULL AFTER thread_id,
+  ADD INDEX idx_direct_messages_thread (thread_id, created_at);
+
+UPDATE direct_messages SET thread_id = id WHERE thread_id = 0;
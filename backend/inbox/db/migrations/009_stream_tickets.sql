@@ -0,0 +1,14 @@
+-- user-009: one-time tickets for opening event streams from browsers.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS stream_tickets (
+  ticket_hash CHAR(64) NOT NULL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  session_id CHAR(32) NOT NULL,
+  token_id CHAR(32) NOT NULL,
+  token_expires_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  INDEX idx_stream_tickets_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,7 @@
+-- user-014: per-party soft delete for the trash folder and the purge job.
+USE msg_demo;
+
+ALTER TABLE direct_messages
+  ADD COLUMN sender_deleted_at DATETIME NULL AFTER reply_to_id,
+  ADD COLUMN receiver_deleted_at DATETIME NULL AFTER sender_deleted_at,
+  ADD INDEX idx_direct_messages_purge (receiver_deleted_at, sender_deleted_at);
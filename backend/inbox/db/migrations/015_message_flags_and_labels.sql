@@ -0,0 +1,34 @@
+-- user-015: per-user archive and star flags and custom labels for inbox items.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS message_flags (
+  user_id BIGINT NOT NULL,
+  item_type ENUM('personal','system') NOT NULL,
+  item_id BIGINT UNSIGNED NOT NULL,
+  archived_at DATETIME NULL,
+  starred_at DATETIME NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, item_type, item_id),
+  INDEX idx_message_flags_starred (user_id, starred_at),
+  INDEX idx_message_flags_archived (user_id, archived_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS message_labels (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  color VARCHAR(16) NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_message_labels_name (user_id, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS message_label_items (
+  label_id BIGINT UNSIGNED NOT NULL,
+  item_type ENUM('personal','system') NOT NULL,
+  item_id BIGINT UNSIGNED NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (label_id, item_type, item_id),
+  INDEX idx_message_label_items_item (item_type, item_id),
+  CONSTRAINT fk_label_item_label FOREIGN KEY (label_id) REFERENCES message_labels(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
@@ -0,0 +1,9 @@
+-- user-016: ngram FULLTEXT indexes for mailbox search. Building them on a
+-- large table takes a while; InnoDB allows only one at a time per ALTER.
+USE msg_demo;
+
+ALTER TABLE direct_messages
+  ADD FULLTEXT INDEX ft_direct_messages_text (title, content) WITH PARSER ngram;
+
+ALTER TABLE system_notifications
+  ADD FULLTEXT INDEX ft_system_notifications_text (title, content) WITH PARSER ngram;
@@ -0,0 +1,31 @@
+-- user-018: per-recipient state for personal messages. Each existing message
+-- gets its receiver as a 'to' recipient, carrying over the read and trash
+-- state, before the old columns are dropped. receiver_id stays as the first
+-- recipient.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS direct_message_recipients (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  message_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT NOT NULL,
+  kind ENUM('to','cc','bcc') NOT NULL DEFAULT 'to',
+  is_read TINYINT(1) NOT NULL DEFAULT 0,
+  read_at DATETIME NULL,
+  deleted_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_message_recipient (message_id, user_id),
+  INDEX idx_dm_recipients_user (user_id, is_read, created_at),
+  CONSTRAINT fk_recipient_message FOREIGN KEY (message_id) REFERENCES direct_messages(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO direct_message_recipients (message_id, user_id, kind, is_read, read_at, deleted_at, created_at)
+SELECT id, receiver_id, 'to', is_read, read_at, receiver_deleted_at, created_at
+FROM direct_messages;
+
+ALTER TABLE direct_messages
+  DROP INDEX idx_direct_messages_receiver,
+  DROP INDEX idx_direct_messages_purge,
+  DROP COLUMN is_read,
+  DROP COLUMN read_at,
+  DROP COLUMN receiver_deleted_at,
+  ADD INDEX idx_direct_messages_purge (sender_deleted_at);
@@ -0,0 +1,29 @@
+-- user-019: user groups as message targets. Messages and receipts remember
+-- the group they were sent through.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS user_groups (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  created_by BIGINT NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_user_groups_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+  group_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (group_id, user_id),
+  INDEX idx_group_members_user (user_id),
+  CONSTRAINT fk_member_group FOREIGN KEY (group_id) REFERENCES user_groups(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE direct_message_recipients
+  ADD COLUMN group_id BIGINT UNSIGNED NULL AFTER kind,
+  ADD INDEX idx_dm_recipients_group (user_id, group_id);
+
+ALTER TABLE system_notification_receipts
+  ADD COLUMN group_id BIGINT UNSIGNED NULL AFTER read_at,
+  ADD INDEX idx_system_receipts_group (user_id, group_id);
@@ -0,0 +1,6 @@
+-- user-021: display names and avatars embedded in messages.
+USE msg_demo;
+
+ALTER TABLE users
+  ADD COLUMN display_name VARCHAR(64) NOT NULL DEFAULT '' AFTER username,
+  ADD COLUMN avatar_url VARCHAR(512) NOT NULL DEFAULT '' AFTER display_name;
@@ -0,0 +1,8 @@
+-- user-022: locale and time zone settings, and the index behind the
+-- display name prefix search.
+USE msg_demo;
+
+ALTER TABLE users
+  ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '' AFTER avatar_url,
+  ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '' AFTER locale,
+  ADD INDEX idx_users_display_name (display_name);
@@ -0,0 +1,22 @@
+-- user-023: verified email addresses and the one-time tokens mailed for
+-- verification and password resets.
+USE msg_demo;
+
+ALTER TABLE users
+  ADD COLUMN email VARCHAR(254) NULL AFTER timezone,
+  ADD COLUMN email_verified_at DATETIME NULL AFTER email,
+  ADD UNIQUE KEY uk_users_email (email);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  purpose ENUM('verify_email','reset_password') NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  email VARCHAR(254) NOT NULL,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_user_tokens_hash (token_hash),
+  INDEX idx_user_tokens_user (user_id, purpose, created_at),
+  CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,26 @@
+-- user-024: failed login records shared by replicas, with the attempts in
+-- flight reserved by each check, and the login audit trail.
+USE msg_demo;
+
+CREATE TABLE IF NOT EXISTS login_failures (
+  key_name VARCHAR(160) NOT NULL PRIMARY KEY,
+  failures INT UNSIGNED NOT NULL,
+  last_failure_at DATETIME(3) NOT NULL,
+  expires_at DATETIME(3) NOT NULL,
+  pending INT UNSIGNED NOT NULL DEFAULT 0,
+  pending_expires_at DATETIME(3) NULL,
+  INDEX idx_login_failures_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_audit (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NULL,
+  username VARCHAR(64) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  outcome ENUM('success','failure','throttled','locked') NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_login_audit_user (user_id, created_at),
+  INDEX idx_login_audit_username (username, created_at),
+  INDEX idx_login_audit_ip (ip, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,35 @@
+-- user-025: TOTP secrets (sealed with TwoFactor.SecretKey), recovery codes,
+-- the challenges between the password and the code, and the challenged
+-- login outcome.
+USE msg_demo;
+
+ALTER TABLE users
+  ADD COLUMN totp_secret VARCHAR(128) NULL AFTER role,
+  ADD COLUMN totp_enabled_at DATETIME NULL AFTER totp_secret,
+  ADD COLUMN totp_last_step BIGINT NULL AFTER totp_enabled_at;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_recovery_codes_user_hash (user_id, code_hash),
+  CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  attempts INT UNSIGNED NOT NULL DEFAULT 0,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_login_challenges_hash (token_hash),
+  INDEX idx_login_challenges_user (user_id),
+  CONSTRAINT fk_login_challenges_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE login_audit
+  MODIFY outcome ENUM('success','failure','throttled','locked','challenged') NOT NULL;
@@ -9,12 +9,31 @@ CREATE TABLE IF NOT EXISTS direct_messages (
   receiver_id BIGINT NOT NULL,
   title VARCHAR(255) DEFAULT NULL,
   content TEXT NOT NULL,
+  thread_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  reply_to_id BIGINT UNSIGNED NULL,
+  sender_deleted_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  INDEX idx_direct_messages_sender (sender_id, created_at),
+  INDEX idx_direct_messages_thread (thread_id, created_at),
+  INDEX idx_direct_messages_purge (sender_deleted_at),
+  FULLTEXT INDEX ft_direct_messages_text (title, content) WITH PARSER ngram
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS direct_message_recipients (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  message_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT NOT NULL,
+  kind ENUM('to','cc','bcc') NOT NULL DEFAULT 'to',
+  group_id BIGINT UNSIGNED NULL,
   is_read TINYINT(1) NOT NULL DEFAULT 0,
   read_at DATETIME NULL,
+  deleted_at DATETIME NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-  INDEX idx_direct_messages_receiver (receiver_id, is_read, created_at),
-  INDEX idx_direct_messages_sender (sender_id, created_at)
+  UNIQUE KEY uk_message_recipient (message_id, user_id),
+  INDEX idx_dm_recipients_user (user_id, is_read, created_at),
+  INDEX idx_dm_recipients_group (user_id, group_id),
+  CONSTRAINT fk_recipient_message FOREIGN KEY (message_id) REFERENCES direct_messages(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS system_notifications (
@@ -23,8 +42,18 @@ CREATE TABLE IF NOT EXISTS system_notifications (
   content TEXT NOT NULL,
   priority ENUM('info','warning','critical') NOT NULL DEFAULT 'info',
   created_by BIGINT NOT NULL DEFAULT 0,
+  audience ENUM('single','all','users','segment','global') NOT NULL DEFAULT 'single',
+  segment VARCHAR(32) NULL,
+  delivery_status ENUM('pending','running','completed','failed') NOT NULL DEFAULT 'completed',
+  target_total INT NOT NULL DEFAULT 0,
+  delivered_count INT NOT NULL DEFAULT 0,
+  delivered_until BIGINT NOT NULL DEFAULT 0,
+  lease_expires_at DATETIME NULL,
+  finished_at DATETIME NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  INDEX idx_system_notifications_created_at (created_at)
+  INDEX idx_system_notifications_created_at (created_at),
+  INDEX idx_system_notifications_audience (audience, created_at),
+  FULLTEXT INDEX ft_system_notifications_text (title, content) WITH PARSER ngram
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS system_notification_receipts (
@@ -33,15 +62,176 @@ CREATE TABLE IF NOT EXISTS system_notification_receipts (
   user_id BIGINT NOT NULL,
   is_read TINYINT(1) NOT NULL DEFAULT 0,
   read_at DATETIME NULL,
+  group_id BIGINT UNSIGNED NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   UNIQUE KEY uk_notification_user (notification_id, user_id),
   INDEX idx_system_receipts_user (user_id, is_read, created_at),
+  INDEX idx_system_receipts_group (user_id, group_id),
   CONSTRAINT fk_receipt_notification FOREIGN KEY (notification_id) REFERENCES system_notifications(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+CREATE TABLE IF NOT EXISTS message_flags (
+  user_id BIGINT NOT NULL,
+  item_type ENUM('personal','system') NOT NULL,
+  item_id BIGINT UNSIGNED NOT NULL,
+  archived_at DATETIME NULL,
+  starred_at DATETIME NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, item_type, item_id),
+  INDEX idx_message_flags_starred (user_id, starred_at),
+  INDEX idx_message_flags_archived (user_id, archived_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS message_labels (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  color VARCHAR(16) NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_message_labels_name (user_id, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS message_label_items (
+  label_id BIGINT UNSIGNED NOT NULL,
+  item_type ENUM('personal','system') NOT NULL,
+  item_id BIGINT UNSIGNED NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (label_id, item_type, item_id),
+  INDEX idx_message_label_items_item (item_type, item_id),
+  CONSTRAINT fk_label_item_label FOREIGN KEY (label_id) REFERENCES message_labels(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE IF NOT EXISTS users (
   id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
   username VARCHAR(64) NOT NULL UNIQUE,
+  display_name VARCHAR(64) NOT NULL DEFAULT '',
+  avatar_url VARCHAR(512) NOT NULL DEFAULT '',
+  locale VARCHAR(35) NOT NULL DEFAULT '',
+  timezone VARCHAR(64) NOT NULL DEFAULT '',
+  email VARCHAR(254) NULL,
+  email_verified_at DATETIME NULL,
   password_hash VARCHAR(255) NOT NULL,
-  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+  role ENUM('user','admin','service') NOT NULL DEFAULT 'user',
+  totp_secret VARCHAR(128) NULL,
+  totp_enabled_at DATETIME NULL,
+  totp_last_step BIGINT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_users_email (email),
+  INDEX idx_users_display_name (display_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_groups (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  created_by BIGINT NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_user_groups_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+  group_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (group_id, user_id),
+  INDEX idx_group_members_user (user_id),
+  CONSTRAINT fk_member_group FOREIGN KEY (group_id) REFERENCES user_groups(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS system_notification_watermarks (
+  user_id BIGINT NOT NULL PRIMARY KEY,
+  read_until DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+  id CHAR(32) NOT NULL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  refresh_hash CHAR(64) NOT NULL,
+  previous_hash CHAR(64) NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_auth_sessions_refresh (refresh_hash),
+  INDEX idx_auth_sessions_previous (previous_hash),
+  INDEX idx_auth_sessions_user (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti CHAR(32) NOT NULL PRIMARY KEY,
+  expires_at DATETIME NOT NULL,
+  INDEX idx_revoked_tokens_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS stream_tickets (
+  ticket_hash CHAR(64) NOT NULL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  session_id CHAR(32) NOT NULL,
+  token_id CHAR(32) NOT NULL,
+  token_expires_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  INDEX idx_stream_tickets_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  purpose ENUM('verify_email','reset_password') NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  email VARCHAR(254) NOT NULL,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_user_tokens_hash (token_hash),
+  INDEX idx_user_tokens_user (user_id, purpose, created_at),
+  CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_failures (
+  key_name VARCHAR(160) NOT NULL PRIMARY KEY,
+  failures INT UNSIGNED NOT NULL,
+  last_failure_at DATETIME(3) NOT NULL,
+  expires_at DATETIME(3) NOT NULL,
+  pending INT UNSIGNED NOT NULL DEFAULT 0,
+  pending_expires_at DATETIME(3) NULL,
+  INDEX idx_login_failures_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_audit (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NULL,
+  username VARCHAR(64) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  outcome ENUM('success','failure','throttled','locked','challenged') NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_login_audit_user (user_id, created_at),
+  INDEX idx_login_audit_username (username, created_at),
+  INDEX idx_login_audit_ip (ip, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_recovery_codes_user_hash (user_id, code_hash),
+  CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT UNSIGNED NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  attempts INT UNSIGNED NOT NULL DEFAULT 0,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uk_login_challenges_hash (token_hash),
+  INDEX idx_login_challenges_user (user_id),
+  CONSTRAINT fk_login_challenges_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -4,17 +4,30 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	// Profile time zones are validated against the IANA database, which
+	// slim container images do not ship.
+	_ "time/tzdata"
 
 	"github.com/pineapple/msg-demo/backend/inbox/internal/config"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/handler"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/job"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/searchindex"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
 	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/service"
 	"github.com/zeromicro/go-zero/rest"
+	"github.com/zeromicro/go-zero/rest/httpx"
 )
 
-var configFile = flag.String("f", "etc/inbox-api.yaml", "the config file")
+var (
+	configFile = flag.String("f", "etc/inbox-api.yaml", "the config file")
+	reindex    = flag.Bool("reindex", false, "rebuild the search index from MySQL and exit; stop the server first")
+)
 
 func main() {
 	flag.Parse()
@@ -22,12 +35,53 @@ func main() {
 	var c config.Config
 	conf.MustLoad(*configFile, &c)
 
+	if *reindex {
+		rebuildSearchIndex(c)
+		return
+	}
+
 	server := rest.MustNewServer(c.RestConf)
-	defer server.Stop()
 
 	ctx := svc.NewServiceContext(c)
 	handler.RegisterHandlers(server, ctx)
+	httpx.SetErrorHandlerCtx(handler.ErrorHandler)
+	if ctx.Search != nil {
+		defer ctx.Search.Close()
+	}
+
+	group := service.NewServiceGroup()
+	defer group.Stop()
+	group.Add(server)
+	group.Add(job.NewPurgeJob(ctx))
+	group.Add(job.NewBroadcastJob(ctx))
+	group.Add(ctx.Background)
 
 	fmt.Printf("Starting server at %s:%d...\n", c.Host, c.Port)
-	server.Start()
+	group.Start()
+}
+
+// rebuildSearchIndex replaces the search index with one built from the
+// current tables. The new index is built beside the current one and only
+// swapped in once it is complete.
+func rebuildSearchIndex(c config.Config) {
+	if c.Search.Engine != "bleve" {
+		fmt.Println("Search.Engine is mysql; its FULLTEXT indexes are maintained by MySQL")
+		return
+	}
+
+	// The service context must not open the index being replaced; the
+	// rebuild writes to its own.
+	live := c
+	live.Search.Engine = "mysql"
+	ctx := svc.NewServiceContext(live)
+
+	var n int
+	logx.Must(searchindex.RebuildBleve(c.Search.Path, func(index *searchindex.BleveIndex) error {
+		ctx.Search = index
+		var err error
+		n, err = logic.RebuildSearchIndex(context.Background(), ctx, 500)
+		return err
+	}))
+
+	fmt.Printf("Indexed %d documents into %s\n", n, c.Search.Path)
 }
@@ -4,6 +4,7 @@
 package config
 
 import (
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/keyset"
 	"github.com/zeromicro/go-zero/core/stores/sqlx"
 	"github.com/zeromicro/go-zero/rest"
 )
@@ -14,9 +15,138 @@ type Config struct {
 		DataSource string
 	} `json:"Mysql" yaml:"Mysql"`
 	Auth struct {
-		AccessSecret string `json:"AccessSecret" yaml:"AccessSecret"`
+		// AccessSecret is the legacy HS256 secret, registered under kid
+		// "default". It may be dropped once Keys holds a signing key.
+		AccessSecret string `json:"AccessSecret,optional" yaml:"AccessSecret"`
 		AccessExpire int64  `json:"AccessExpire" yaml:"AccessExpire"`
+		// RefreshExpire is the sliding lifetime of a refresh token in seconds.
+		RefreshExpire int64 `json:"RefreshExpire,default=2592000" yaml:"RefreshExpire"`
+		// SigningKey is the kid used to sign new tokens; defaults to the
+		// first usable entry in Keys.
+		SigningKey string             `json:"SigningKey,optional" yaml:"SigningKey"`
+		Keys       []keyset.KeyConfig `json:"Keys,optional" yaml:"Keys"`
+		// RetiredKeyGrace is how long, in seconds, a retired key keeps
+		// verifying tokens. It should exceed AccessExpire.
+		RetiredKeyGrace int64 `json:"RetiredKeyGrace,default=3600" yaml:"RetiredKeyGrace"`
 	} `json:"Auth" yaml:"Auth"`
+	Broadcast struct {
+		// BatchSize is the number of receipts written per transaction when
+		// fanning a notification out to many users.
+		BatchSize int `json:"BatchSize,default=500" yaml:"BatchSize"`
+	} `json:"Broadcast,optional" yaml:"Broadcast"`
+	Trash struct {
+		// Retention is how long, in seconds, a message both parties deleted
+		// is kept before it is purged.
+		Retention int64 `json:"Retention,default=2592000" yaml:"Retention"`
+		// PurgeInterval is the number of seconds between purge runs; 0
+		// disables purging.
+		PurgeInterval int64 `json:"PurgeInterval,default=3600" yaml:"PurgeInterval"`
+		PurgeBatch    int   `json:"PurgeBatch,default=1000" yaml:"PurgeBatch"`
+	} `json:"Trash,optional" yaml:"Trash"`
+	Events struct {
+		// BufferSize is how many events may queue for one connection before
+		// it is dropped and has to resume with Last-Event-ID.
+		BufferSize int `json:"BufferSize,default=64" yaml:"BufferSize"`
+		// HistorySize is the number of recent events kept per user for replay.
+		HistorySize int `json:"HistorySize,default=100" yaml:"HistorySize"`
+		// Retention is how long, in seconds, a disconnected user's history
+		// is kept for a reconnect.
+		Retention int64 `json:"Retention,default=600" yaml:"Retention"`
+		// Heartbeat is the interval, in seconds, of keep-alive comments on
+		// idle event streams.
+		Heartbeat int64 `json:"Heartbeat,default=25" yaml:"Heartbeat"`
+		// TicketExpire is how long, in seconds, a stream ticket may wait
+		// before it is used to open an event stream.
+		TicketExpire int64 `json:"TicketExpire,default=30" yaml:"TicketExpire"`
+		// Broker relays events between replicas: memory for a single
+		// replica, redis when several share the load.
+		Broker string `json:"Broker,default=memory,options=memory|redis" yaml:"Broker"`
+		Redis  struct {
+			Addr     string `json:"Addr,optional" yaml:"Addr"`
+			Password string `json:"Password,optional" yaml:"Password"`
+			DB       int    `json:"DB,optional" yaml:"DB"`
+			Channel  string `json:"Channel,default=inbox:events" yaml:"Channel"`
+		} `json:"Redis,optional" yaml:"Redis"`
+	} `json:"Events,optional" yaml:"Events"`
+	Search struct {
+		// Engine is mysql to search with the FULLTEXT indexes, or bleve for
+		// an embedded on-disk index with typo tolerance. The bleve index is
+		// local to the process, so it suits a single replica and is refused
+		// together with Events.Broker redis.
+		Engine string `json:"Engine,default=mysql,options=mysql|bleve" yaml:"Engine"`
+		Path   string `json:"Path,default=data/search.bleve" yaml:"Path"`
+	} `json:"Search,optional" yaml:"Search"`
+	Mail struct {
+		// Driver is log to write mail to the service log with the link
+		// tokens redacted, file to append it to Path, or smtp to send it
+		// through SMTP.
+		Driver string `json:"Driver,default=log,options=log|file|smtp" yaml:"Driver"`
+		From   string `json:"From,default=noreply@localhost" yaml:"From"`
+		Path   string `json:"Path,default=data/mail.log" yaml:"Path"`
+		SMTP   struct {
+			Host     string `json:"Host,default=127.0.0.1" yaml:"Host"`
+			Port     int    `json:"Port,default=25" yaml:"Port"`
+			Username string `json:"Username,optional" yaml:"Username"`
+			Password string `json:"Password,optional" yaml:"Password"`
+		} `json:"SMTP,optional" yaml:"SMTP"`
+		// LinkBase is the frontend origin that verification and reset links
+		// point to.
+		LinkBase string `json:"LinkBase,default=http://127.0.0.1:5173" yaml:"LinkBase"`
+		// VerifyExpire and ResetExpire are the lifetimes, in seconds, of
+		// email verification and password reset tokens.
+		VerifyExpire int64 `json:"VerifyExpire,default=86400" yaml:"VerifyExpire"`
+		ResetExpire  int64 `json:"ResetExpire,default=3600" yaml:"ResetExpire"`
+	} `json:"Mail,optional" yaml:"Mail"`
+	Login struct {
+		// Store keeps failed login attempts: memory for a single replica,
+		// mysql or redis when several share the load.
+		Store string `json:"Store,default=memory,options=memory|mysql|redis" yaml:"Store"`
+		// FreeAttempts failures of one account cost nothing; each further
+		// one doubles the wait from BaseDelay up to MaxDelay, in seconds.
+		FreeAttempts int   `json:"FreeAttempts,default=3" yaml:"FreeAttempts"`
+		BaseDelay    int64 `json:"BaseDelay,default=1" yaml:"BaseDelay"`
+		MaxDelay     int64 `json:"MaxDelay,default=300" yaml:"MaxDelay"`
+		// LockAfter failures of one account, or IPLockAfter from one
+		// address, lock it for LockFor seconds.
+		LockAfter   int   `json:"LockAfter,default=10" yaml:"LockAfter"`
+		IPLockAfter int   `json:"IPLockAfter,default=50" yaml:"IPLockAfter"`
+		LockFor     int64 `json:"LockFor,default=900" yaml:"LockFor"`
+		// Window is how long, in seconds, failures are remembered after the
+		// last one.
+		Window int64 `json:"Window,default=86400" yaml:"Window"`
+		// TrustForwardedFor takes the client address from the last
+		// X-Forwarded-For entry. Only enable it behind a proxy that sets the
+		// header, or clients can pick their own address.
+		TrustForwardedFor bool `json:"TrustForwardedFor,optional" yaml:"TrustForwardedFor"`
+
+		Redis struct {
+			Addr     string `json:"Addr,optional" yaml:"Addr"`
+			Password string `json:"Password,optional" yaml:"Password"`
+			DB       int    `json:"DB,optional" yaml:"DB"`
+			Prefix   string `json:"Prefix,default=inbox:login:" yaml:"Prefix"`
+		} `json:"Redis,optional" yaml:"Redis"`
+	} `json:"Login,optional" yaml:"Login"`
+	TwoFactor struct {
+		// Issuer names the service in authenticator apps.
+		Issuer string `json:"Issuer,default=Inbox" yaml:"Issuer"`
+		// Skew is how many 30 second steps a code may be early or late, for
+		// phones whose clocks drift.
+		Skew int `json:"Skew,default=1" yaml:"Skew"`
+		// SecretKey encrypts the authenticator secrets stored in users: 32
+		// bytes in base64, e.g. from `openssl rand -base64 32`. Changing it
+		// makes every enrolled app stop working.
+		SecretKey string `json:"SecretKey,optional" yaml:"SecretKey"`
+		// ChallengeExpire is how long, in seconds, the challenge token from
+		// the password step may be exchanged for a session.
+		ChallengeExpire int64 `json:"ChallengeExpire,default=300" yaml:"ChallengeExpire"`
+	} `json:"TwoFactor,optional" yaml:"TwoFactor"`
+	// SystemSender is the identity system notifications are shown as,
+	// whichever admin or service sent them.
+	SystemSender struct {
+		Username    string `json:"Username,default=system" yaml:"Username"`
+		DisplayName string `json:"DisplayName,default=System" yaml:"DisplayName"`
+		AvatarUrl   string `json:"AvatarUrl,optional" yaml:"AvatarUrl"`
+	} `json:"SystemSender,optional" yaml:"SystemSender"`
 }
 
 func (m *Config) NewMysqlConn() sqlx.SqlConn {
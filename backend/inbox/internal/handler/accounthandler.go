@@ -0,0 +1,124 @@
+// Code scaffolded by goctl. Safe to edit.
+// goctl 1.9.2
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+func ProfileHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ProfileRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewAccountLogic(r.Context(), svcCtx)
+		resp, err := l.Profile(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func UpdateProfileHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.UpdateProfileRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewAccountLogic(r.Context(), svcCtx)
+		resp, err := l.UpdateProfile(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func ChangePasswordHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ChangePasswordRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewAccountLogic(r.Context(), svcCtx)
+		resp, err := l.ChangePassword(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func SearchUsersHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.SearchUsersRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewAccountLogic(r.Context(), svcCtx)
+		resp, err := l.SearchUsers(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func ChangeEmailHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ChangeEmailRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewAccountLogic(r.Context(), svcCtx)
+		resp, err := l.ChangeEmail(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
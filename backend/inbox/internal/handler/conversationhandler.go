@@ -0,0 +1,80 @@
+// Code scaffolded by goctl. Safe to edit.
+// goctl 1.9.2
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+func ReplyMessageHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ReplyMessageRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewReplyMessageLogic(r.Context(), svcCtx)
+		resp, err := l.ReplyMessage(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func ListConversationsHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ListConversationsRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewListConversationsLogic(r.Context(), svcCtx)
+		resp, err := l.ListConversations(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func ThreadMessagesHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ThreadMessagesRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewThreadMessagesLogic(r.Context(), svcCtx)
+		resp, err := l.ThreadMessages(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+)
+
+type receiverNotFoundBody struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	UserIds   []int64  `json:"userIds,omitempty"`
+	Usernames []string `json:"usernames,omitempty"`
+}
+
+type messageBody struct {
+	Message string `json:"message"`
+}
+
+type loginBlockedBody struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retryAfter"`
+}
+
+// ErrorHandler answers errors that carry details with a JSON body; every
+// other error keeps go-zero's plain 400 response.
+func ErrorHandler(_ context.Context, err error) (int, interface{}) {
+	var notFound *logic.ReceiverNotFoundError
+	if errors.As(err, &notFound) {
+		return http.StatusNotFound, receiverNotFoundBody{
+			Code:      "receiver_not_found",
+			Message:   notFound.Error(),
+			UserIds:   notFound.UserIds,
+			Usernames: notFound.Usernames,
+		}
+	}
+
+	var forbidden *logic.ForbiddenError
+	if errors.As(err, &forbidden) {
+		return http.StatusForbidden, messageBody{Message: forbidden.Message}
+	}
+
+	var blocked *logic.LoginBlockedError
+	if errors.As(err, &blocked) {
+		code := "login_throttled"
+		if blocked.Locked {
+			code = "login_locked"
+		}
+		return http.StatusTooManyRequests, loginBlockedBody{
+			Code:       code,
+			Message:    blocked.Error(),
+			RetryAfter: blocked.RetrySeconds(),
+		}
+	}
+
+	return http.StatusBadRequest, err
+}
@@ -0,0 +1,101 @@
+// Code scaffolded by goctl. Safe to edit.
+// goctl 1.9.2
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logc"
+	"github.com/zeromicro/go-zero/core/threading"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+func EventsHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.EventsRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		client := make(chan *eventhub.Event, 16)
+		l := logic.NewEventsLogic(r.Context(), svcCtx)
+		threading.GoSafeCtx(r.Context(), func() {
+			defer close(client)
+			if err := l.Events(&req, client); err != nil {
+				logc.Errorw(r.Context(), "EventsHandler", logc.Field("error", err))
+			}
+		})
+
+		heartbeat := time.NewTicker(svcCtx.Heartbeat)
+		defer heartbeat.Stop()
+
+		flusher, _ := w.(http.Flusher)
+		for {
+			var err error
+			select {
+			case evt, ok := <-client:
+				if !ok {
+					return
+				}
+				err = writeEvent(w, evt)
+			case <-heartbeat.C:
+				// A comment line keeps proxies from closing an idle stream.
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, evt *eventhub.Event) error {
+	if evt.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", evt.ID); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, evt.Data)
+	return err
+}
+
+func StreamTicketHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.StreamTicketRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewStreamTicketLogic(r.Context(), svcCtx)
+		resp, err := l.StreamTicket(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
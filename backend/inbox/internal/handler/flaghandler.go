@@ -0,0 +1,102 @@
+// Code scaffolded by goctl. Safe to edit.
+// goctl 1.9.2
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+func ArchiveInboxItemHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.InboxItemFlagRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewInboxFlagLogic(r.Context(), svcCtx)
+		err := l.Archive(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.Ok(w)
+		}
+	}
+}
+
+func UnarchiveInboxItemHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.InboxItemFlagRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewInboxFlagLogic(r.Context(), svcCtx)
+		err := l.Unarchive(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.Ok(w)
+		}
+	}
+}
+
+func StarInboxItemHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.InboxItemFlagRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewInboxFlagLogic(r.Context(), svcCtx)
+		err := l.Star(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.Ok(w)
+		}
+	}
+}
+
+func UnstarInboxItemHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.InboxItemFlagRequest
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		if userID, ok := authctx.UserIDFromCtx(r.Context()); ok {
+			req.UserId = userID
+		}
+
+		l := logic.NewInboxFlagLogic(r.Context(), svcCtx)
+		err := l.Unstar(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+		} else {
+			httpx.Ok(w)
+		}
+	}
+}
@@ -4,7 +4,11 @@
 package handler
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
@@ -20,12 +24,62 @@ func LoginHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
 			return
 		}
 
+		req.Ip = clientIP(r, svcCtx.Config.Login.TrustForwardedFor)
+		req.UserAgent = r.UserAgent()
+
 		l := logic.NewLoginLogic(r.Context(), svcCtx)
 		resp, err := l.Login(&req)
 		if err != nil {
+			writeLoginError(w, r, err)
+		} else {
+			httpx.OkJsonCtx(r.Context(), w, resp)
+		}
+	}
+}
+
+func LoginTwoFactorHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.LoginTwoFactorRequest
+		if err := httpx.Parse(r, &req); err != nil {
 			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		req.Ip = clientIP(r, svcCtx.Config.Login.TrustForwardedFor)
+		req.UserAgent = r.UserAgent()
+
+		l := logic.NewLoginLogic(r.Context(), svcCtx)
+		resp, err := l.LoginTwoFactor(&req)
+		if err != nil {
+			writeLoginError(w, r, err)
 		} else {
 			httpx.OkJsonCtx(r.Context(), w, resp)
 		}
 	}
 }
+
+// writeLoginError adds Retry-After to errors of blocked logins.
+func writeLoginError(w http.ResponseWriter, r *http.Request, err error) {
+	var blocked *logic.LoginBlockedError
+	if errors.As(err, &blocked) {
+		w.Header().Set("Retry-After", strconv.FormatInt(blocked.RetrySeconds(), 10))
+	}
+	httpx.ErrorCtx(r.Context(), w, err)
+}
+
+// clientIP is the address the request came from. Behind a trusted proxy it
+// is the last X-Forwarded-For entry, the one the proxy itself appended.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	addr := r.RemoteAddr
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			addr = strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+func TestSendMessageRejectsNonAdminPolicyBypass(t *testing.T) {
+	httpx.SetErrorHandlerCtx(ErrorHandler)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "system channel",
+			body: `{"channel":"system","receiverId":2,"content":"hi"}`,
+		},
+		{
+			name: "system channel hidden behind a differently cased key",
+			body: `{"channel":"system","Channel":"personal","receiverId":2,"content":"hi"}`,
+		},
+		{
+			name: "other sender",
+			body: `{"senderId":2,"receiverId":3,"content":"hi"}`,
+		},
+		{
+			name: "other sender hidden behind a differently cased key",
+			body: `{"senderId":2,"SenderId":1,"receiverId":3,"content":"hi"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := authctx.WithUserID(context.Background(), 1)
+			ctx = authctx.WithRole(ctx, authctx.RoleUser)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/messages", strings.NewReader(tt.body)).WithContext(ctx)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			// The policy is checked before any lookup, so no database is needed.
+			SendMessageHandler(&svc.ServiceContext{})(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusForbidden, w.Body.String())
+			}
+		})
+	}
+}
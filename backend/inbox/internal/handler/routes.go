@@ -16,27 +16,300 @@ func RegisterHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
 		rest.WithMiddlewares(
 			[]rest.Middleware{
 				serverCtx.AuthMiddleware.Handle,
+				serverCtx.SendPolicy.Handle,
 			},
 			rest.Route{
 				Method:  http.MethodPost,
 				Path:    "/api/v1/messages",
 				Handler: SendMessageHandler(serverCtx),
 			},
+		),
+	)
+
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{
+				serverCtx.AuthMiddleware.Handle,
+			},
 			rest.Route{
 				Method:  http.MethodGet,
 				Path:    "/api/v1/messages",
 				Handler: ListMessagesHandler(serverCtx),
 			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/messages/:id",
+				Handler: MessageDetailHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/inbox/:uid",
+				Handler: InboxItemHandler(serverCtx),
+			},
 			rest.Route{
 				Method:  http.MethodPost,
 				Path:    "/api/v1/messages/:id/read",
 				Handler: MarkMessageReadHandler(serverCtx),
 			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/inbox/:uid/read",
+				Handler: MarkInboxItemReadHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/messages/:id/unread",
+				Handler: MarkMessageUnreadHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/inbox/:uid/unread",
+				Handler: MarkInboxItemUnreadHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/messages/:id",
+				Handler: DeleteMessageHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/messages/:id/restore",
+				Handler: RestoreMessageHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/inbox/:uid/archive",
+				Handler: ArchiveInboxItemHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/inbox/:uid/archive",
+				Handler: UnarchiveInboxItemHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/inbox/:uid/star",
+				Handler: StarInboxItemHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/inbox/:uid/star",
+				Handler: UnstarInboxItemHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/inbox/:uid/labels/:labelId",
+				Handler: AddItemLabelHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/inbox/:uid/labels/:labelId",
+				Handler: RemoveItemLabelHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/labels",
+				Handler: ListLabelsHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/labels",
+				Handler: CreateLabelHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPut,
+				Path:    "/api/v1/labels/:id",
+				Handler: UpdateLabelHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/labels/:id",
+				Handler: DeleteLabelHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/groups",
+				Handler: ListGroupsHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/groups/:id/members",
+				Handler: ListGroupMembersHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/messages/read/batch",
+				Handler: MarkReadBatchHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/messages/read/all",
+				Handler: MarkAllReadHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/messages/search",
+				Handler: SearchMessagesHandler(serverCtx),
+			},
 			rest.Route{
 				Method:  http.MethodGet,
 				Path:    "/api/v1/messages/unread/count",
 				Handler: UnreadCountHandler(serverCtx),
 			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/messages/:id/reply",
+				Handler: ReplyMessageHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/conversations",
+				Handler: ListConversationsHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/conversations/:threadId/messages",
+				Handler: ThreadMessagesHandler(serverCtx),
+			},
+		),
+	)
+
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{
+				serverCtx.AuthMiddleware.Handle,
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/logout",
+				Handler: LogoutHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/events/ticket",
+				Handler: StreamTicketHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/users/me",
+				Handler: ProfileHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPatch,
+				Path:    "/api/v1/users/me",
+				Handler: UpdateProfileHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/users/me/password",
+				Handler: ChangePasswordHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/users",
+				Handler: SearchUsersHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPut,
+				Path:    "/api/v1/users/me/email",
+				Handler: ChangeEmailHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/users/me/2fa/setup",
+				Handler: SetupTwoFactorHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/users/me/2fa/enable",
+				Handler: EnableTwoFactorHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/users/me/2fa/disable",
+				Handler: DisableTwoFactorHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/users/me/2fa/recovery-codes",
+				Handler: RegenerateRecoveryCodesHandler(serverCtx),
+			},
+		),
+	)
+
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{
+				serverCtx.AuthMiddleware.Handle,
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/events",
+				Handler: EventsHandler(serverCtx),
+			},
+		),
+		rest.WithSSE(),
+	)
+
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{
+				serverCtx.AuthMiddleware.Handle,
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/ws",
+				Handler: WebSocketHandler(serverCtx),
+			},
+		),
+	)
+
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{
+				serverCtx.AuthMiddleware.Handle,
+				serverCtx.AdminOnly.Handle,
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/notifications/:id/delivery",
+				Handler: NotificationDeliveryHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/groups",
+				Handler: CreateGroupHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPut,
+				Path:    "/api/v1/groups/:id",
+				Handler: UpdateGroupHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/groups/:id",
+				Handler: DeleteGroupHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/groups/:id/members",
+				Handler: AddGroupMembersHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodDelete,
+				Path:    "/api/v1/groups/:id/members/:userId",
+				Handler: RemoveGroupMemberHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodGet,
+				Path:    "/api/v1/admin/login-audit",
+				Handler: LoginAuditHandler(serverCtx),
+			},
+			rest.Route{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/admin/users/:id/unlock",
+				Handler: UnlockUserHandler(serverCtx),
+			},
 		),
 	)
 
@@ -52,6 +325,36 @@ func RegisterHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
 				Path:    "/api/v1/auth/login",
 				Handler: LoginHandler(serverCtx),
 			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/login/2fa",
+				Handler: LoginTwoFactorHandler(serverCtx),
+			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/refresh",
+				Handler: RefreshTokenHandler(serverCtx),
+			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/email/verify",
+				Handler: VerifyEmailHandler(serverCtx),
+			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/password/reset",
+				Handler: RequestPasswordResetHandler(serverCtx),
+			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/auth/password/reset/confirm",
+				Handler: ConfirmPasswordResetHandler(serverCtx),
+			},
+			{
+				Method:  http.MethodGet,
+				Path:    "/.well-known/jwks.json",
+				Handler: JwksHandler(serverCtx),
+			},
 		},
 	)
 }
@@ -0,0 +1,55 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/logic"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// BroadcastJob resumes broadcasts left running by a replica that stopped or
+// crashed mid fan-out. It runs on every replica; the lease on the
+// notification row makes sure only one of them picks each broadcast up.
+type BroadcastJob struct {
+	svcCtx *svc.ServiceContext
+	// ctx is cancelled by Stop, so a pass that is still claiming broadcasts
+	// gives up instead of holding shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewBroadcastJob(svcCtx *svc.ServiceContext) *BroadcastJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BroadcastJob{
+		svcCtx: svcCtx,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (j *BroadcastJob) Start() {
+	ticker := time.NewTicker(logic.BroadcastLease / 2)
+	defer ticker.Stop()
+
+	for {
+		resumed, err := logic.ResumeBroadcasts(j.ctx, j.svcCtx)
+		switch {
+		case err != nil && j.ctx.Err() == nil:
+			logx.Errorf("resume broadcasts: %v", err)
+		case resumed > 0:
+			logx.Infof("resumed %d interrupted broadcasts", resumed)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-j.ctx.Done():
+			return
+		}
+	}
+}
+
+func (j *BroadcastJob) Stop() {
+	j.cancel()
+}
@@ -0,0 +1,40 @@
+package job
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+)
+
+func TestBroadcastJobStopCancelsResume(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The lookup of stalled broadcasts hangs until its context ends.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, audience, segment, delivered_until, created_at FROM system_notifications")).
+		WillDelayFor(time.Hour).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "audience", "segment", "delivered_until", "created_at"}))
+
+	j := NewBroadcastJob(&svc.ServiceContext{DB: db})
+	stopped := make(chan struct{})
+	go func() {
+		j.Start()
+		close(stopped)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	j.Stop()
+	j.Stop()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+}
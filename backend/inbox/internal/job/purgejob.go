@@ -0,0 +1,219 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/searchindex"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// purgeable matches messages that the sender and every recipient moved to
+// the trash before the cutoff, which it takes twice.
+const purgeable = `dm.sender_deleted_at < ? AND NOT EXISTS (SELECT 1 FROM direct_message_recipients r
+	WHERE r.message_id = dm.id AND (r.deleted_at IS NULL OR r.deleted_at >= ?))`
+
+// PurgeJob hard-deletes personal messages that every party moved to the
+// trash more than the retention window ago. It runs as a service next to the
+// REST server so every replica purges; the deletes are idempotent.
+type PurgeJob struct {
+	svcCtx   *svc.ServiceContext
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewPurgeJob(svcCtx *svc.ServiceContext) *PurgeJob {
+	return &PurgeJob{
+		svcCtx: svcCtx,
+		done:   make(chan struct{}),
+	}
+}
+
+func (j *PurgeJob) Start() {
+	interval := time.Duration(j.svcCtx.Config.Trash.PurgeInterval) * time.Second
+	if interval <= 0 {
+		logx.Info("trash purge disabled")
+		<-j.done
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := j.Purge(context.Background())
+		if err != nil {
+			logx.Errorf("purge trash: %v", err)
+		} else if purged > 0 {
+			logx.Infof("purged %d deleted messages", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-j.done:
+			return
+		}
+	}
+}
+
+func (j *PurgeJob) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.done)
+	})
+}
+
+// Purge deletes in batches so a large backlog does not hold long locks, and
+// returns the number of messages removed.
+func (j *PurgeJob) Purge(ctx context.Context) (int64, error) {
+	cfg := j.svcCtx.Config.Trash
+	cutoff := time.Now().Add(-time.Duration(cfg.Retention) * time.Second)
+	batch := cfg.PurgeBatch
+	if batch <= 0 {
+		batch = 1000
+	}
+
+	var total int64
+	for {
+		affected, err := j.purgeBatch(ctx, cutoff, batch)
+		if err != nil {
+			return total, err
+		}
+
+		total += affected
+		if affected < int64(batch) {
+			return total, nil
+		}
+
+		select {
+		case <-j.done:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// purgeBatch deletes one batch of messages together with the archive, star
+// and label rows that point at them. Recipient rows go with the message
+// through their foreign key.
+func (j *PurgeJob) purgeBatch(ctx context.Context, cutoff time.Time, batch int) (int64, error) {
+	rows, err := j.svcCtx.DB.QueryContext(
+		ctx,
+		`SELECT dm.id FROM direct_messages dm WHERE `+purgeable+` LIMIT ?`,
+		cutoff,
+		cutoff,
+		batch,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("select purged messages: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scan purged message: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate purged messages: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := j.svcCtx.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// The deleted_at conditions are repeated in case a message was restored
+	// since it was selected; rows of such messages are kept below as well.
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	result, err := tx.ExecContext(
+		ctx,
+		`DELETE dm FROM direct_messages dm WHERE `+purgeable+` AND dm.id IN (`+placeholders+`)`,
+		append([]interface{}{cutoff, cutoff}, ids...)...,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete purged messages: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purged rows affected: %w", err)
+	}
+
+	for _, table := range []string{"message_flags", "message_label_items"} {
+		if _, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM `+table+` WHERE item_type = 'personal' AND item_id IN (`+placeholders+`)
+AND NOT EXISTS (SELECT 1 FROM direct_messages dm WHERE dm.id = `+table+`.item_id)`,
+			ids...,
+		); err != nil {
+			return 0, fmt.Errorf("delete %s of purged messages: %w", table, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit purge: %w", err)
+	}
+	committed = true
+
+	j.unindex(ctx, ids, placeholders)
+
+	return affected, nil
+}
+
+// unindex drops purged messages from the search index. Messages restored
+// since they were selected still exist and keep their documents.
+func (j *PurgeJob) unindex(ctx context.Context, ids []interface{}, placeholders string) {
+	if j.svcCtx.Search == nil {
+		return
+	}
+
+	rows, err := j.svcCtx.DB.QueryContext(ctx, `SELECT id FROM direct_messages WHERE id IN (`+placeholders+`)`, ids...)
+	if err != nil {
+		logx.Errorf("unindex purged messages: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	kept := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			logx.Errorf("unindex purged messages: %v", err)
+			return
+		}
+		kept[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		logx.Errorf("unindex purged messages: %v", err)
+		return
+	}
+
+	var purged []int64
+	for _, id := range ids {
+		if !kept[id.(int64)] {
+			purged = append(purged, id.(int64))
+		}
+	}
+
+	if err := j.svcCtx.Search.Delete(ctx, searchindex.KindPersonal, purged...); err != nil {
+		logx.Errorf("unindex purged messages: %v", err)
+	}
+}
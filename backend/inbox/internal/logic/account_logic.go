@@ -0,0 +1,353 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAvatarURLLength   = 512
+	maxUserSearchLimit   = 50
+)
+
+// localePattern accepts BCP 47 language tags such as zh, zh-CN or
+// zh-Hant-TW without checking them against the registry.
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// AccountLogic serves the caller's own account: their profile, their
+// password, and looking up other users to write to.
+type AccountLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewAccountLogic(ctx context.Context, svcCtx *svc.ServiceContext) *AccountLogic {
+	return &AccountLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *AccountLogic) Profile(req *types.ProfileRequest) (*types.UserProfile, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	return fetchUserProfile(l.ctx, l.svcCtx.DB, req.UserId)
+}
+
+// UpdateProfile changes the fields present in the request; an empty string
+// clears a field.
+func (l *AccountLogic) UpdateProfile(req *types.UpdateProfileRequest) (*types.UserProfile, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	var (
+		sets []string
+		args []interface{}
+	)
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return nil, fmt.Errorf("显示名称最多 %d 个字符", maxDisplayNameLength)
+		}
+		sets = append(sets, "display_name = ?")
+		args = append(args, name)
+	}
+	if req.AvatarUrl != nil {
+		avatar := strings.TrimSpace(*req.AvatarUrl)
+		if err := validateAvatarURL(avatar); err != nil {
+			return nil, err
+		}
+		sets = append(sets, "avatar_url = ?")
+		args = append(args, avatar)
+	}
+	if req.Locale != nil {
+		locale := strings.TrimSpace(*req.Locale)
+		if locale != "" && !localePattern.MatchString(locale) {
+			return nil, fmt.Errorf("无效的语言代码: %s", locale)
+		}
+		sets = append(sets, "locale = ?")
+		args = append(args, locale)
+	}
+	if req.Timezone != nil {
+		timezone := strings.TrimSpace(*req.Timezone)
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+				return nil, fmt.Errorf("无效的时区: %s", timezone)
+			}
+		}
+		sets = append(sets, "timezone = ?")
+		args = append(args, timezone)
+	}
+
+	if len(sets) > 0 {
+		if _, err := l.svcCtx.DB.ExecContext(
+			l.ctx,
+			`UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = ?`,
+			append(args, req.UserId)...,
+		); err != nil {
+			return nil, fmt.Errorf("更新用户资料失败: %w", err)
+		}
+	}
+
+	return fetchUserProfile(l.ctx, l.svcCtx.DB, req.UserId)
+}
+
+// ChangePassword replaces the password once the old one checks out. Every
+// session of the user is revoked, so a stolen token or refresh token stops
+// working; the caller gets a fresh session in return.
+func (l *AccountLogic) ChangePassword(req *types.ChangePasswordRequest) (*types.AuthResponse, error) {
+	token, ok := authctx.TokenFromCtx(l.ctx)
+	if !ok || req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		return nil, err
+	}
+
+	var (
+		user         = types.User{Id: req.UserId}
+		passwordHash string
+	)
+	err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT username, role, password_hash FROM users WHERE id = ?`,
+		req.UserId,
+	).Scan(&user.Username, &user.Role, &passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("缺少用户信息")
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if err = guardAccount(l.ctx, l.svcCtx, user.Username, func() (bool, error) {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.OldPassword)) == nil, nil
+	}, fmt.Errorf("原密码错误")); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("生成密码哈希失败: %w", err)
+	}
+
+	if _, err = l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`UPDATE users SET password_hash = ? WHERE id = ?`,
+		string(hash),
+		req.UserId,
+	); err != nil {
+		return nil, fmt.Errorf("更新密码失败: %w", err)
+	}
+
+	if err = l.svcCtx.Sessions.RevokeUser(l.ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	if err = l.svcCtx.Sessions.RevokeToken(l.ctx, token.ID, token.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return startSession(l.ctx, l.svcCtx, user)
+}
+
+// ChangeEmail sets the user's address and mails a verification link to it.
+// The address stays unverified, and unusable for password resets, until the
+// link is followed; giving the current unverified address again resends it.
+// The password is asked for because the address decides where reset links
+// go, so a stolen session alone must not be able to take the account over.
+func (l *AccountLogic) ChangeEmail(req *types.ChangeEmailRequest) (*types.UserProfile, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPassword(l.ctx, l.svcCtx, req.UserId, req.CurrentPassword); err != nil {
+		return nil, err
+	}
+
+	current, err := fetchUserProfile(l.ctx, l.svcCtx.DB, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if current.Email == email && current.EmailVerified {
+		return current, nil
+	}
+
+	if current.Email != email {
+		if _, err = l.svcCtx.DB.ExecContext(
+			l.ctx,
+			`UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ?`,
+			email,
+			req.UserId,
+		); err != nil {
+			return nil, translateEmailConflict(err)
+		}
+	}
+
+	if err = sendEmailVerification(l.ctx, l.svcCtx, req.UserId, email); err != nil {
+		return nil, err
+	}
+
+	return fetchUserProfile(l.ctx, l.svcCtx.DB, req.UserId)
+}
+
+// SearchUsers finds users whose username or display name starts with q, for
+// picking receivers while composing a message.
+func (l *AccountLogic) SearchUsers(req *types.SearchUsersRequest) (*types.SearchUsersResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > maxUserSearchLimit {
+		limit = maxUserSearchLimit
+	}
+
+	items := make([]types.UserSummary, 0)
+	q := strings.TrimSpace(req.Q)
+	if q == "" {
+		return &types.SearchUsersResponse{Items: items}, nil
+	}
+
+	prefix := likePrefix(q)
+	rows, err := l.svcCtx.DB.QueryContext(
+		l.ctx,
+		`SELECT id, username, display_name, avatar_url FROM users
+WHERE username LIKE ? OR display_name LIKE ?
+ORDER BY username
+LIMIT ?`,
+		prefix,
+		prefix,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		profile, err := scanUserSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return &types.SearchUsersResponse{Items: items}, nil
+}
+
+// checkPassword confirms a password before a change that could lock the
+// user out or hand the account to someone else. Wrong passwords count
+// against the account in the login guard.
+func checkPassword(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, password string) error {
+	var username, passwordHash string
+	err := svcCtx.DB.QueryRowContext(
+		ctx,
+		`SELECT username, password_hash FROM users WHERE id = ?`,
+		userID,
+	).Scan(&username, &passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("缺少用户信息")
+		}
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	return guardAccount(ctx, svcCtx, username, func() (bool, error) {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil, nil
+	}, fmt.Errorf("密码错误"))
+}
+
+func fetchUserProfile(ctx context.Context, db *sql.DB, userID int64) (*types.UserProfile, error) {
+	var (
+		profile   = types.UserProfile{Id: userID}
+		email     sql.NullString
+		createdAt time.Time
+	)
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT username, role, display_name, avatar_url, locale, timezone, email, email_verified_at IS NOT NULL,
+	totp_enabled_at IS NOT NULL, created_at
+FROM users WHERE id = ?`,
+		userID,
+	).Scan(
+		&profile.Username,
+		&profile.Role,
+		&profile.DisplayName,
+		&profile.AvatarUrl,
+		&profile.Locale,
+		&profile.Timezone,
+		&email,
+		&profile.EmailVerified,
+		&profile.TwoFactorEnabled,
+		&createdAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("缺少用户信息")
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+	profile.Email = email.String
+	profile.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+
+	return &profile, nil
+}
+
+func translateEmailConflict(err error) error {
+	if strings.Contains(err.Error(), "Duplicate entry") {
+		return fmt.Errorf("该邮箱已被其他账号使用")
+	}
+	return fmt.Errorf("更新邮箱失败: %w", err)
+}
+
+func validateAvatarURL(avatar string) error {
+	if avatar == "" {
+		return nil
+	}
+	if len(avatar) > maxAvatarURLLength {
+		return fmt.Errorf("头像地址最多 %d 个字符", maxAvatarURLLength)
+	}
+
+	u, err := url.Parse(avatar)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("头像地址必须是 http 或 https URL")
+	}
+	return nil
+}
+
+// likePrefix turns user input into a LIKE pattern matching it as a prefix,
+// with the wildcards in the input taken literally.
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
@@ -0,0 +1,142 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/loginguard"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// expectPassword answers checkPassword's lookup of user 7, alice, whose
+// password is "right password".
+func expectPassword(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT username, password_hash FROM users WHERE id = ?")).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "password_hash"}).AddRow("alice", rightPasswordHash(t)))
+}
+
+func rightPasswordHash(t *testing.T) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestPasswordChecksCountAgainstLoginGuard(t *testing.T) {
+	ctx := authctx.WithToken(context.Background(), authctx.Token{ID: "jti", ExpiresAt: time.Now().Add(time.Minute)})
+
+	tests := []struct {
+		name   string
+		expect func(t *testing.T, mock sqlmock.Sqlmock)
+		check  func(svcCtx *svc.ServiceContext) error
+	}{
+		{
+			name: "change password",
+			expect: func(t *testing.T, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT username, role, password_hash FROM users WHERE id = ?")).
+					WithArgs(int64(7)).
+					WillReturnRows(sqlmock.NewRows([]string{"username", "role", "password_hash"}).
+						AddRow("alice", "user", rightPasswordHash(t)))
+			},
+			check: func(svcCtx *svc.ServiceContext) error {
+				_, err := NewAccountLogic(ctx, svcCtx).ChangePassword(&types.ChangePasswordRequest{
+					OldPassword: "wrong password",
+					NewPassword: "new password 123",
+					UserId:      7,
+				})
+				return err
+			},
+		},
+		{
+			name:   "change email",
+			expect: expectPassword,
+			check: func(svcCtx *svc.ServiceContext) error {
+				_, err := NewAccountLogic(ctx, svcCtx).ChangeEmail(&types.ChangeEmailRequest{
+					Email:           "mallory@example.com",
+					CurrentPassword: "wrong password",
+					UserId:          7,
+				})
+				return err
+			},
+		},
+		{
+			name:   "enable two-step verification",
+			expect: expectPassword,
+			check: func(svcCtx *svc.ServiceContext) error {
+				_, err := NewTwoFactorLogic(ctx, svcCtx).Enable(&types.EnableTwoFactorRequest{
+					Password: "wrong password",
+					Code:     "123456",
+					UserId:   7,
+				})
+				return err
+			},
+		},
+		{
+			name:   "disable two-step verification",
+			expect: expectPassword,
+			check: func(svcCtx *svc.ServiceContext) error {
+				return NewTwoFactorLogic(ctx, svcCtx).Disable(&types.DisableTwoFactorRequest{
+					Password: "wrong password",
+					Code:     "123456",
+					UserId:   7,
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			store := loginguard.NewMemoryStore(time.Hour)
+			svcCtx := &svc.ServiceContext{
+				DB: db,
+				LoginGuard: loginguard.New(store, loginguard.Policy{
+					FreeAttempts: 2,
+					LockAfter:    2,
+					LockFor:      time.Hour,
+				}, loginguard.Policy{}),
+			}
+
+			// Wrong passwords fail like wrong logins until the account
+			// locks; then even the right one is not checked.
+			for i := 0; i < 2; i++ {
+				tt.expect(t, mock)
+				if err := tt.check(svcCtx); err == nil || (err.Error() != "密码错误" && err.Error() != "原密码错误") {
+					t.Fatalf("attempt %d: err = %v", i+1, err)
+				}
+			}
+			tt.expect(t, mock)
+			var blocked *LoginBlockedError
+			if err := tt.check(svcCtx); !errors.As(err, &blocked) || !blocked.Locked {
+				t.Fatalf("err = %v, want the account locked", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			attempts, err := store.Get(context.Background(), "user:alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != 2 || attempts.Pending != 0 {
+				t.Fatalf("attempts = %+v", attempts)
+			}
+		})
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
 
@@ -36,6 +38,15 @@ func (l *RegisterLogic) Register(req *types.RegisterRequest) (*types.AuthRespons
 		return nil, err
 	}
 
+	var email sql.NullString
+	if req.Email != "" {
+		address, err := normalizeEmail(req.Email)
+		if err != nil {
+			return nil, err
+		}
+		email = sql.NullString{String: address, Valid: true}
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("生成密码哈希失败: %w", err)
@@ -43,11 +54,15 @@ func (l *RegisterLogic) Register(req *types.RegisterRequest) (*types.AuthRespons
 
 	result, err := l.svcCtx.DB.ExecContext(
 		l.ctx,
-		`INSERT INTO users (username, password_hash) VALUES (?, ?)`,
+		`INSERT INTO users (username, password_hash, email) VALUES (?, ?, ?)`,
 		req.Username,
 		string(hash),
+		email,
 	)
 	if err != nil {
+		if strings.Contains(err.Error(), "uk_users_email") {
+			return nil, translateEmailConflict(err)
+		}
 		if strings.Contains(err.Error(), "Duplicate entry") {
 			return nil, fmt.Errorf("用户名已被占用")
 		}
@@ -59,20 +74,23 @@ func (l *RegisterLogic) Register(req *types.RegisterRequest) (*types.AuthRespons
 		return nil, fmt.Errorf("获取用户ID失败: %w", err)
 	}
 
-	token, err := l.generateToken(userID)
-	if err != nil {
-		return nil, err
+	// The account works without a verified address, so a failed mail only
+	// means the user has to ask for another link.
+	if email.Valid {
+		if err = sendEmailVerification(l.ctx, l.svcCtx, userID, email.String); err != nil {
+			l.Errorf("send verification to new user %d: %v", userID, err)
+		}
 	}
 
-	return &types.AuthResponse{
-		Token: token,
-		User: types.User{
-			Id:       userID,
-			Username: req.Username,
-		},
-	}, nil
+	return startSession(l.ctx, l.svcCtx, types.User{
+		Id:       userID,
+		Username: req.Username,
+		Role:     authctx.RoleUser,
+	})
 }
 
+var errBadCredentials = errors.New("用户名或密码错误")
+
 type LoginLogic struct {
 	logx.Logger
 	ctx    context.Context
@@ -87,63 +105,158 @@ func NewLoginLogic(ctx context.Context, svcCtx *svc.ServiceContext) *LoginLogic
 	}
 }
 
+// Login checks the password under the login guard: failures slow down and
+// then lock the account and the client address, and every attempt lands in
+// the login audit trail. Users with two-step verification get a challenge
+// token instead of a session, to be exchanged by LoginTwoFactor.
 func (l *LoginLogic) Login(req *types.LoginRequest) (*types.AuthResponse, error) {
 	if err := validateCredentials(req.Username, req.Password); err != nil {
 		return nil, err
 	}
 
+	if err := l.checkGuard(req, 0); err != nil {
+		return nil, err
+	}
+	// The attempt checkGuard reserved is settled by fail or succeed, and
+	// released on any other way out, the two-step challenge included.
+	settled := false
+	defer func() {
+		if !settled {
+			l.release(req)
+		}
+	}()
+
 	var (
 		id           int64
 		passwordHash string
+		role         string
+		twoFactor    bool
 	)
 
 	err := l.svcCtx.DB.QueryRowContext(
 		l.ctx,
-		`SELECT id, password_hash FROM users WHERE username = ?`,
+		`SELECT id, password_hash, role, totp_enabled_at IS NOT NULL FROM users WHERE username = ?`,
 		req.Username,
-	).Scan(&id, &passwordHash)
+	).Scan(&id, &passwordHash, &role, &twoFactor)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("用户名或密码错误")
+			settled = true
+			return nil, l.fail(req, 0, errBadCredentials)
 		}
 		return nil, fmt.Errorf("查询用户失败: %w", err)
 	}
 
 	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
-		return nil, fmt.Errorf("用户名或密码错误")
+		settled = true
+		return nil, l.fail(req, id, errBadCredentials)
+	}
+
+	user := types.User{
+		Id:       id,
+		Username: req.Username,
+		Role:     role,
 	}
 
-	token, err := l.generateToken(id)
+	// The failure count is left alone until the second step passes, or a
+	// known password would keep clearing the count of wrong codes.
+	if twoFactor {
+		return l.challenge(req, user)
+	}
+
+	settled = true
+	return l.succeed(req, user)
+}
+
+// checkGuard turns the attempt away while the account or address is backing
+// off or locked, and otherwise reserves it in the guard until it is settled.
+// Without failure records the guard cannot tell a guesser from a user, so
+// logins stop rather than go unguarded.
+func (l *LoginLogic) checkGuard(req *types.LoginRequest, userID int64) error {
+	verdict, err := l.svcCtx.LoginGuard.Check(l.ctx, req.Username, req.Ip)
 	if err != nil {
-		return nil, err
+		l.Errorf("check login guard for %q: %v", req.Username, err)
+		return fmt.Errorf("暂时无法登录，请稍后再试")
+	}
+	if verdict.RetryAfter <= 0 {
+		return nil
 	}
 
-	return &types.AuthResponse{
-		Token: token,
-		User: types.User{
-			Id:       id,
-			Username: req.Username,
-		},
-	}, nil
+	outcome := loginThrottled
+	if verdict.Locked {
+		outcome = loginLocked
+	}
+	recordLoginAttempt(l.ctx, l.svcCtx, req, userID, outcome)
+	return &LoginBlockedError{RetryAfter: verdict.RetryAfter, Locked: verdict.Locked}
 }
 
-func (l *RegisterLogic) generateToken(userID int64) (string, error) {
-	return generateToken(l.svcCtx, userID)
+// succeed clears the account's failures and opens the session.
+func (l *LoginLogic) succeed(req *types.LoginRequest, user types.User) (*types.AuthResponse, error) {
+	if err := l.svcCtx.LoginGuard.Succeed(l.ctx, req.Username, req.Ip); err != nil {
+		l.Errorf("reset login failures of %q: %v", req.Username, err)
+	}
+	recordLoginAttempt(l.ctx, l.svcCtx, req, user.Id, loginSuccess)
+
+	return startSession(l.ctx, l.svcCtx, user)
+}
+
+// fail counts a wrong username, password or code, and returns err. Unknown
+// usernames count too, so the guard does not reveal which accounts exist.
+func (l *LoginLogic) fail(req *types.LoginRequest, userID int64, err error) error {
+	if guardErr := l.svcCtx.LoginGuard.Fail(l.ctx, req.Username, req.Ip); guardErr != nil {
+		l.Errorf("record login failure of %q: %v", req.Username, guardErr)
+	}
+	recordLoginAttempt(l.ctx, l.svcCtx, req, userID, loginFailure)
+
+	return err
+}
+
+// release settles an attempt that neither failed nor succeeded.
+func (l *LoginLogic) release(req *types.LoginRequest) {
+	if err := l.svcCtx.LoginGuard.Release(l.ctx, req.Username, req.Ip); err != nil {
+		l.Errorf("release login attempt of %q: %v", req.Username, err)
+	}
+}
+
+// startSession opens a refresh-token session for the user and issues the
+// first access token bound to it.
+func startSession(ctx context.Context, svcCtx *svc.ServiceContext, user types.User) (*types.AuthResponse, error) {
+	sess, refreshToken, err := svcCtx.Sessions.Create(ctx, user.Id, svcCtx.RefreshExpire)
+	if err != nil {
+		return nil, fmt.Errorf("创建会话失败: %w", err)
+	}
+
+	return buildAuthResponse(svcCtx, user, sess.ID, refreshToken)
 }
 
-func (l *LoginLogic) generateToken(userID int64) (string, error) {
-	return generateToken(l.svcCtx, userID)
+func buildAuthResponse(svcCtx *svc.ServiceContext, user types.User, sessionID, refreshToken string) (*types.AuthResponse, error) {
+	token, err := generateToken(svcCtx, user.Id, user.Role, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(svcCtx.AccessExpire / time.Second),
+		User:         user,
+	}, nil
 }
 
-func generateToken(ctx *svc.ServiceContext, userID int64) (string, error) {
-	expireAt := time.Now().Add(ctx.AccessExpire).Unix()
+func generateToken(ctx *svc.ServiceContext, userID int64, role, sessionID string) (string, error) {
+	tokenID, err := session.RandomID()
+	if err != nil {
+		return "", fmt.Errorf("生成 token 失败: %w", err)
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	now := time.Now()
+	signed, err := ctx.Keys.Sign(jwt.MapClaims{
 		"userId": userID,
-		"exp":    expireAt,
+		"role":   role,
+		"sid":    sessionID,
+		"jti":    tokenID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(ctx.AccessExpire).Unix(),
 	})
-
-	signed, err := token.SignedString(ctx.AccessSecret)
 	if err != nil {
 		return "", fmt.Errorf("生成 token 失败: %w", err)
 	}
@@ -156,6 +269,10 @@ func validateCredentials(username, password string) error {
 	if len(username) < 3 {
 		return fmt.Errorf("用户名至少 3 个字符")
 	}
+	return validatePassword(password)
+}
+
+func validatePassword(password string) error {
 	if len(password) < 6 {
 		return fmt.Errorf("密码至少 6 个字符")
 	}
@@ -0,0 +1,503 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	audienceSingle  = "single"
+	audienceAll     = "all"
+	audienceUsers   = "users"
+	audienceSegment = "segment"
+)
+
+// BroadcastLease is how long a replica owns a background broadcast without
+// delivering a batch. A broadcast whose lease ran out is resumed elsewhere.
+const BroadcastLease = time.Minute
+
+// recipientPager returns up to limit recipient ids greater than afterID in
+// ascending order, so fan-out can resume by keyset without holding a cursor.
+type recipientPager func(ctx context.Context, afterID int64, limit int) ([]int64, error)
+
+type broadcastTarget struct {
+	total int64
+	next  recipientPager
+	// groupID is recorded on the receipts of a group:<id> segment.
+	groupID int64
+}
+
+func (l *SendMessageLogic) broadcastSystemNotification(req *types.SendMessageRequest) (*types.Message, error) {
+	target, err := resolveBroadcastTarget(l.ctx, l.svcCtx.DB, req)
+	if err != nil {
+		return nil, err
+	}
+
+	createdBy := req.SenderId
+	if createdBy < 0 {
+		createdBy = 0
+	}
+
+	var segment sql.NullString
+	if req.Audience == audienceSegment {
+		segment = sql.NullString{String: req.Segment, Valid: true}
+	}
+
+	res, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO system_notifications
+	(title, content, priority, created_by, audience, segment, delivery_status, target_total, lease_expires_at)
+VALUES (?, ?, ?, ?, ?, ?, 'running', ?, ?)`,
+		req.Title,
+		req.Content,
+		req.Priority,
+		createdBy,
+		req.Audience,
+		segment,
+		target.total,
+		time.Now().Add(BroadcastLease),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert broadcast notification: %w", err)
+	}
+
+	notificationID, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("fetch notification id: %w", err)
+	}
+
+	indexNotifications(l.ctx, l.svcCtx, notificationID)
+
+	// An id list came with the request and is not stored anywhere else, and
+	// a single batch is quick, so both are delivered before answering, even
+	// if the client hangs up meanwhile. Larger audiences are delivered in the
+	// background; progress is persisted on the notification row and exposed
+	// through NotificationDelivery.
+	if req.Audience == audienceUsers || target.total <= int64(l.svcCtx.BroadcastBatch) {
+		fanOutReceipts(context.WithoutCancel(l.ctx), l.svcCtx, notificationID, target, 0)
+	} else {
+		svcCtx := l.svcCtx
+		svcCtx.Background.Go(func(ctx context.Context) {
+			fanOutReceipts(ctx, svcCtx, notificationID, target, 0)
+		})
+	}
+
+	status, err := fetchDeliveryStatus(l.ctx, l.svcCtx.DB, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &types.Message{
+		Id:        notificationID,
+		SenderId:  createdBy,
+		Title:     req.Title,
+		Content:   req.Content,
+		CreatedAt: status.CreatedAt,
+		Channel:   "system",
+		Priority:  req.Priority,
+		Delivery:  status,
+	}
+	if err = attachProfile(l.ctx, l.svcCtx, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func resolveBroadcastTarget(ctx context.Context, db *sql.DB, req *types.SendMessageRequest) (*broadcastTarget, error) {
+	switch req.Audience {
+	case audienceAll:
+		return usersTarget(ctx, db, "1 = 1", nil)
+	case audienceSegment:
+		if groupID, ok := parseGroupRef(req.Segment); ok {
+			if _, err := fetchGroup(ctx, db, groupID); err != nil {
+				return nil, err
+			}
+		}
+		return segmentTarget(ctx, db, req.Segment, time.Now())
+	case audienceUsers:
+		ids := uniqueSortedIDs(req.ReceiverIds)
+		if len(ids) == 0 {
+			return nil, errors.New("receiverIds is required for audience=users")
+		}
+		if err := ensureUsersExist(ctx, db, ids); err != nil {
+			return nil, err
+		}
+		return &broadcastTarget{
+			total: int64(len(ids)),
+			next:  idListPager(ids),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported audience: %s", req.Audience)
+	}
+}
+
+func segmentTarget(ctx context.Context, db *sql.DB, segment string, now time.Time) (*broadcastTarget, error) {
+	where, args, err := segmentFilter(segment, now)
+	if err != nil {
+		return nil, err
+	}
+	target, err := usersTarget(ctx, db, where, args)
+	if err != nil {
+		return nil, err
+	}
+	target.groupID, _ = parseGroupRef(segment)
+	return target, nil
+}
+
+// segmentFilter translates a segment expression into a WHERE clause over
+// users. Supported forms are role:<role>, recent:<days> and group:<id>;
+// recent counts back from now.
+func segmentFilter(segment string, now time.Time) (string, []interface{}, error) {
+	kind, value, found := strings.Cut(segment, ":")
+	if !found {
+		return "", nil, fmt.Errorf("invalid segment: %q", segment)
+	}
+
+	switch kind {
+	case "role":
+		if !authctx.ValidRole(value) {
+			return "", nil, fmt.Errorf("unknown role in segment: %q", value)
+		}
+		return "role = ?", []interface{}{value}, nil
+	case "recent":
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return "", nil, fmt.Errorf("invalid day count in segment: %q", value)
+		}
+		return "created_at >= ?", []interface{}{now.AddDate(0, 0, -days)}, nil
+	case "group":
+		groupID, ok := parseGroupRef(segment)
+		if !ok {
+			return "", nil, fmt.Errorf("invalid group in segment: %q", value)
+		}
+		return "id IN (SELECT user_id FROM user_group_members WHERE group_id = ?)", []interface{}{groupID}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported segment: %q", segment)
+	}
+}
+
+func usersTarget(ctx context.Context, db *sql.DB, where string, args []interface{}) (*broadcastTarget, error) {
+	var total int64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM users WHERE %s", where)
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("count broadcast recipients: %w", err)
+	}
+
+	query := fmt.Sprintf("SELECT id FROM users WHERE %s AND id > ? ORDER BY id LIMIT ?", where)
+	next := func(ctx context.Context, afterID int64, limit int) ([]int64, error) {
+		pageArgs := append(append([]interface{}{}, args...), afterID, limit)
+		rows, err := db.QueryContext(ctx, query, pageArgs...)
+		if err != nil {
+			return nil, fmt.Errorf("list broadcast recipients: %w", err)
+		}
+		defer rows.Close()
+
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return nil, fmt.Errorf("scan broadcast recipient: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+
+	return &broadcastTarget{total: total, next: next}, nil
+}
+
+func idListPager(ids []int64) recipientPager {
+	return func(_ context.Context, afterID int64, limit int) ([]int64, error) {
+		start := sort.Search(len(ids), func(i int) bool { return ids[i] > afterID })
+		end := start + limit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		return ids[start:end], nil
+	}
+}
+
+func uniqueSortedIDs(ids []int64) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// fanOutReceipts writes receipts for the users after afterID, batch by
+// batch. When ctx is cancelled the notification stays running, and once its
+// lease runs out ResumeBroadcasts carries on from the persisted cursor.
+func fanOutReceipts(ctx context.Context, svcCtx *svc.ServiceContext, notificationID int64, target *broadcastTarget, afterID int64) {
+	logger := logx.WithContext(ctx)
+	db := svcCtx.DB
+	batch := svcCtx.BroadcastBatch
+
+	for {
+		ids, err := target.next(ctx, afterID, batch)
+		if err == nil && len(ids) > 0 {
+			err = insertReceiptBatch(ctx, db, notificationID, target.groupID, ids)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Infof("broadcast %d interrupted after user %d; it will be resumed", notificationID, afterID)
+				return
+			}
+			logger.Errorf("broadcast %d failed after user %d: %v", notificationID, afterID, err)
+			indexNotifications(ctx, svcCtx, notificationID)
+			finishBroadcast(ctx, db, notificationID, "failed")
+			return
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		notifyReceiptsCreated(ctx, svcCtx, notificationID, ids)
+
+		afterID = ids[len(ids)-1]
+		logger.Infof("broadcast %d delivered up to user %d", notificationID, afterID)
+	}
+
+	// The search index lists who holds a receipt; it is rewritten once
+	// delivery ends rather than after every batch.
+	indexNotifications(ctx, svcCtx, notificationID)
+	finishBroadcast(ctx, db, notificationID, "completed")
+}
+
+// ResumeBroadcasts picks up running broadcasts whose lease has run out,
+// because the replica delivering them stopped, and continues each in the
+// background from its cursor. It returns the number resumed.
+func ResumeBroadcasts(ctx context.Context, svcCtx *svc.ServiceContext) (int, error) {
+	now := time.Now()
+	rows, err := svcCtx.DB.QueryContext(
+		ctx,
+		`SELECT id, audience, segment, delivered_until, created_at FROM system_notifications
+WHERE delivery_status = 'running' AND (lease_expires_at IS NULL OR lease_expires_at < ?)`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("list stalled broadcasts: %w", err)
+	}
+
+	type stalled struct {
+		id        int64
+		audience  string
+		segment   sql.NullString
+		cursor    int64
+		createdAt time.Time
+	}
+	var pending []stalled
+	for rows.Next() {
+		var s stalled
+		if err := rows.Scan(&s.id, &s.audience, &s.segment, &s.cursor, &s.createdAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan stalled broadcast: %w", err)
+		}
+		pending = append(pending, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate stalled broadcasts: %w", err)
+	}
+
+	resumed := 0
+	for _, s := range pending {
+		// Another replica may get here first; the lease decides.
+		res, err := svcCtx.DB.ExecContext(
+			ctx,
+			`UPDATE system_notifications SET lease_expires_at = ?
+WHERE id = ? AND delivery_status = 'running' AND (lease_expires_at IS NULL OR lease_expires_at < ?)`,
+			time.Now().Add(BroadcastLease),
+			s.id,
+			now,
+		)
+		if err != nil {
+			return resumed, fmt.Errorf("claim broadcast %d: %w", s.id, err)
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+
+		var target *broadcastTarget
+		switch s.audience {
+		case audienceAll:
+			target, err = usersTarget(ctx, svcCtx.DB, "1 = 1", nil)
+		case audienceSegment:
+			// A recent:<days> segment keeps counting from when it was sent.
+			target, err = segmentTarget(ctx, svcCtx.DB, s.segment.String, s.createdAt)
+		default:
+			// An id list was only ever held by the request that sent it.
+			err = fmt.Errorf("audience %s cannot be resumed", s.audience)
+		}
+		if err != nil {
+			// Stopping is not a failure: the claim lapses with the lease
+			// and the broadcast is resumed later.
+			if ctx.Err() != nil {
+				return resumed, ctx.Err()
+			}
+			logx.WithContext(ctx).Errorf("resume broadcast %d: %v", s.id, err)
+			finishBroadcast(ctx, svcCtx.DB, s.id, "failed")
+			continue
+		}
+
+		id, cursor := s.id, s.cursor
+		svcCtx.Background.Go(func(ctx context.Context) {
+			fanOutReceipts(ctx, svcCtx, id, target, cursor)
+		})
+		resumed++
+	}
+
+	return resumed, nil
+}
+
+// insertReceiptBatch writes one batch of receipts and moves the delivered
+// counter and cursor in the same transaction, so progress never runs ahead
+// of the data. It also renews the lease of the replica delivering it.
+func insertReceiptBatch(ctx context.Context, db *sql.DB, notificationID, groupID int64, userIDs []int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin receipt batch: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	placeholders := make([]string, len(userIDs))
+	group := sql.NullInt64{Int64: groupID, Valid: groupID > 0}
+	args := make([]interface{}, 0, len(userIDs)*3)
+	for i, userID := range userIDs {
+		placeholders[i] = "(?, ?, ?)"
+		args = append(args, notificationID, userID, group)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		"INSERT IGNORE INTO system_notification_receipts (notification_id, user_id, group_id) VALUES "+strings.Join(placeholders, ", "),
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("insert receipt batch: %w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("receipt batch rows affected: %w", err)
+	}
+
+	if _, err = tx.ExecContext(
+		ctx,
+		`UPDATE system_notifications
+SET delivered_count = delivered_count + ?, delivered_until = GREATEST(delivered_until, ?), lease_expires_at = ?
+WHERE id = ?`,
+		inserted,
+		userIDs[len(userIDs)-1],
+		time.Now().Add(BroadcastLease),
+		notificationID,
+	); err != nil {
+		return fmt.Errorf("update delivered count: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit receipt batch: %w", err)
+	}
+	committed = true
+
+	return nil
+}
+
+func finishBroadcast(ctx context.Context, db *sql.DB, notificationID int64, status string) {
+	if _, err := db.ExecContext(
+		ctx,
+		`UPDATE system_notifications SET delivery_status = ?, finished_at = ? WHERE id = ?`,
+		status,
+		time.Now(),
+		notificationID,
+	); err != nil {
+		logx.WithContext(ctx).Errorf("mark broadcast %d %s: %v", notificationID, status, err)
+	}
+}
+
+func fetchDeliveryStatus(ctx context.Context, db *sql.DB, notificationID int64) (*types.DeliveryStatus, error) {
+	var (
+		status     types.DeliveryStatus
+		segment    sql.NullString
+		createdAt  time.Time
+		finishedAt sql.NullTime
+	)
+
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT id, audience, segment, delivery_status, target_total, delivered_count, created_at, finished_at
+FROM system_notifications WHERE id = ?`,
+		notificationID,
+	).Scan(
+		&status.NotificationId,
+		&status.Audience,
+		&segment,
+		&status.Status,
+		&status.Total,
+		&status.Delivered,
+		&createdAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Segment = segment.String
+	status.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	status.FinishedAt = formatNullTime(finishedAt)
+
+	return &status, nil
+}
+
+type NotificationDeliveryLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewNotificationDeliveryLogic(ctx context.Context, svcCtx *svc.ServiceContext) *NotificationDeliveryLogic {
+	return &NotificationDeliveryLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *NotificationDeliveryLogic) NotificationDelivery(req *types.DeliveryStatusRequest) (*types.DeliveryStatus, error) {
+	status, err := fetchDeliveryStatus(l.ctx, l.svcCtx.DB, req.Id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("notification not found")
+		}
+		return nil, fmt.Errorf("query delivery status: %w", err)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,326 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// maxBatchIds caps each id list of a batch request so the IN lists stay a
+// reasonable size.
+const maxBatchIds = 500
+
+// bulkReadEvent is the payload of messages.read. A batch lists the uids it
+// covered; mark-all describes its filter instead.
+type bulkReadEvent struct {
+	Uids     []string `json:"uids,omitempty"`
+	Channel  string   `json:"channel,omitempty"`
+	Before   string   `json:"before,omitempty"`
+	Priority string   `json:"priority,omitempty"`
+}
+
+// readReceiptNotice is a personal message whose sender sees its recipients'
+// read state, and so is told when a bulk read covers it.
+type readReceiptNotice struct {
+	messageID int64
+	senderID  int64
+}
+
+type BulkReadLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewBulkReadLogic(ctx context.Context, svcCtx *svc.ServiceContext) *BulkReadLogic {
+	return &BulkReadLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// MarkReadBatch marks the listed items read with one statement per table.
+// Ids the caller does not own are skipped rather than reported.
+func (l *BulkReadLogic) MarkReadBatch(req *types.MarkReadBatchRequest) (*types.UnreadCountResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	if len(req.PersonalIds) > maxBatchIds || len(req.SystemIds) > maxBatchIds || len(req.NotificationIds) > maxBatchIds {
+		return nil, fmt.Errorf("at most %d ids per channel", maxBatchIds)
+	}
+
+	var (
+		now     = time.Now()
+		notices []readReceiptNotice
+	)
+	err := l.inTx(func(tx *sql.Tx) error {
+		if len(req.PersonalIds) > 0 {
+			placeholders, args := inList(req.PersonalIds)
+			var err error
+			if notices, err = l.lockUnreadPersonal(tx, req.UserId, "r.message_id IN ("+placeholders+")", args...); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(
+				l.ctx,
+				`UPDATE direct_message_recipients SET is_read = 1, read_at = ?
+WHERE user_id = ? AND is_read = 0 AND message_id IN (`+placeholders+`)`,
+				append([]interface{}{now, req.UserId}, args...)...,
+			); err != nil {
+				return fmt.Errorf("batch read personal messages: %w", err)
+			}
+		}
+
+		if len(req.SystemIds) > 0 {
+			placeholders, args := inList(req.SystemIds)
+			if _, err := tx.ExecContext(
+				l.ctx,
+				`UPDATE system_notification_receipts SET is_read = 1, read_at = ?
+WHERE user_id = ? AND is_read = 0 AND id IN (`+placeholders+`)`,
+				append([]interface{}{now, req.UserId}, args...)...,
+			); err != nil {
+				return fmt.Errorf("batch read system notifications: %w", err)
+			}
+		}
+
+		if len(req.NotificationIds) > 0 {
+			placeholders, args := inList(req.NotificationIds)
+			if _, err := tx.ExecContext(
+				l.ctx,
+				`INSERT INTO system_notification_receipts (notification_id, user_id, is_read, read_at, created_at)
+SELECT sn.id, ?, 1, ?, sn.created_at FROM system_notifications sn
+WHERE sn.audience = 'global' AND sn.id IN (`+placeholders+`)
+ON DUPLICATE KEY UPDATE read_at = IF(is_read = 1, read_at, VALUES(read_at)), is_read = 1`,
+				append([]interface{}{req.UserId, now}, args...)...,
+			); err != nil {
+				return fmt.Errorf("batch read global notifications: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var evt bulkReadEvent
+	for _, id := range req.PersonalIds {
+		evt.Uids = append(evt.Uids, messageUID(types.Message{Id: id, Channel: "personal"}))
+	}
+	for _, id := range req.SystemIds {
+		evt.Uids = append(evt.Uids, messageUID(types.Message{Id: id, Channel: "system"}))
+	}
+	for _, id := range req.NotificationIds {
+		evt.Uids = append(evt.Uids, messageUID(types.Message{NotificationId: id, Channel: "system"}))
+	}
+
+	return l.finish(req.UserId, &evt, notices)
+}
+
+// MarkAllRead marks everything in the chosen channels read, optionally only
+// items created up to Before. Priority only exists on system notifications,
+// so setting it leaves personal messages alone.
+func (l *BulkReadLogic) MarkAllRead(req *types.MarkAllReadRequest) (*types.UnreadCountResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	now := time.Now()
+	cutoff := now
+	if req.Before != "" {
+		before, err := time.Parse(time.RFC3339, req.Before)
+		if err != nil {
+			return nil, fmt.Errorf("before must be an RFC3339 timestamp")
+		}
+		if before.Before(cutoff) {
+			cutoff = before
+		}
+	}
+
+	personal := (req.Channel == "all" || req.Channel == "personal") && req.Priority == ""
+	system := req.Channel == "all" || req.Channel == "system"
+
+	var notices []readReceiptNotice
+	err := l.inTx(func(tx *sql.Tx) error {
+		if personal {
+			var err error
+			if notices, err = l.lockUnreadPersonal(tx, req.UserId, "r.deleted_at IS NULL AND dm.created_at <= ?", cutoff); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(
+				l.ctx,
+				`UPDATE direct_message_recipients r
+JOIN direct_messages dm ON dm.id = r.message_id
+SET r.is_read = 1, r.read_at = ?
+WHERE r.user_id = ? AND r.is_read = 0 AND r.deleted_at IS NULL AND dm.created_at <= ?`,
+				now,
+				req.UserId,
+				cutoff,
+			); err != nil {
+				return fmt.Errorf("read all personal messages: %w", err)
+			}
+		}
+
+		if !system {
+			return nil
+		}
+
+		where := "snu.user_id = ? AND snu.is_read = 0 AND snu.created_at <= ?"
+		args := []interface{}{now, req.UserId, cutoff}
+		if req.Priority != "" {
+			where += " AND sn.priority = ?"
+			args = append(args, req.Priority)
+		}
+		if _, err := tx.ExecContext(
+			l.ctx,
+			`UPDATE system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+SET snu.is_read = 1, snu.read_at = ?
+WHERE `+where,
+			args...,
+		); err != nil {
+			return fmt.Errorf("read all system notifications: %w", err)
+		}
+
+		return l.readAllGlobal(tx, req.UserId, now, cutoff, req.Priority)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	evt := &bulkReadEvent{Channel: req.Channel, Priority: req.Priority}
+	if req.Before != "" {
+		evt.Before = cutoff.UTC().Format(time.RFC3339)
+	}
+
+	return l.finish(req.UserId, evt, notices)
+}
+
+// readAllGlobal catches up on global notifications. Without a priority filter
+// moving the watermark covers all of them in one row; with one, receipts are
+// materialised for just the matching notifications.
+func (l *BulkReadLogic) readAllGlobal(tx *sql.Tx, userID int64, now, cutoff time.Time, priority string) error {
+	if priority == "" {
+		// The watermark never moves back, nor below the registration time
+		// it defaults to.
+		if _, err := tx.ExecContext(
+			l.ctx,
+			`INSERT INTO system_notification_watermarks (user_id, read_until)
+SELECT u.id, GREATEST(?, u.created_at) FROM users u WHERE u.id = ?
+ON DUPLICATE KEY UPDATE read_until = GREATEST(read_until, VALUES(read_until))`,
+			cutoff,
+			userID,
+		); err != nil {
+			return fmt.Errorf("advance global watermark: %w", err)
+		}
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+INSERT INTO system_notification_receipts (notification_id, user_id, is_read, read_at, created_at)
+SELECT sn.id, u.id, 1, ?, sn.created_at %s
+WHERE sn.audience = 'global' AND snu.id IS NULL AND sn.created_at > %s AND sn.created_at <= ? AND sn.priority = ?`,
+		globalNotificationsFrom, globalWatermarkExpr)
+	if _, err := tx.ExecContext(l.ctx, query, now, userID, cutoff, priority); err != nil {
+		return fmt.Errorf("read all global notifications: %w", err)
+	}
+
+	return nil
+}
+
+// lockUnreadPersonal locks the caller's unread personal messages matching
+// where, so the update that follows reads exactly these, and returns the
+// ones whose senders are to be told.
+func (l *BulkReadLogic) lockUnreadPersonal(tx *sql.Tx, userID int64, where string, args ...interface{}) ([]readReceiptNotice, error) {
+	rows, err := tx.QueryContext(
+		l.ctx,
+		`SELECT r.message_id, dm.sender_id
+FROM direct_message_recipients r
+JOIN direct_messages dm ON dm.id = r.message_id
+WHERE r.user_id = ? AND r.is_read = 0 AND `+where+`
+FOR UPDATE`,
+		append([]interface{}{userID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lock unread personal messages: %w", err)
+	}
+	defer rows.Close()
+
+	var notices []readReceiptNotice
+	for rows.Next() {
+		var notice readReceiptNotice
+		if err := rows.Scan(&notice.messageID, &notice.senderID); err != nil {
+			return nil, fmt.Errorf("scan unread personal message: %w", err)
+		}
+		// A copy sent to oneself has no one else to tell.
+		if notice.senderID != userID {
+			notices = append(notices, notice)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate unread personal messages: %w", err)
+	}
+
+	return notices, nil
+}
+
+func (l *BulkReadLogic) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := l.svcCtx.DB.BeginTx(l.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit bulk read: %w", err)
+	}
+	committed = true
+
+	return nil
+}
+
+// finish announces the change and returns the counters, which are also
+// published so every open stream picks them up without a query of its own.
+// Senders of the personal messages read are told, as a single read would.
+func (l *BulkReadLogic) finish(userID int64, evt *bulkReadEvent, notices []readReceiptNotice) (*types.UnreadCountResponse, error) {
+	for _, notice := range notices {
+		notifyRecipientsChanged(l.ctx, l.svcCtx, notice.senderID, notice.messageID)
+	}
+
+	counts, err := NewUnreadCountLogic(l.ctx, l.svcCtx).UnreadCount(&types.UnreadCountRequest{UserId: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	publishEvent(l.ctx, l.svcCtx, userID, eventhub.EventMessagesRead, evt)
+	publishEvent(l.ctx, l.svcCtx, userID, eventhub.EventUnreadChanged, counts)
+
+	return counts, nil
+}
+
+// inList returns the placeholders and arguments for an IN (...) clause.
+func inList(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -0,0 +1,212 @@
+package logic
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// expectUnreadCounts answers the three counts that end every bulk read.
+func expectUnreadCounts(mock sqlmock.Sqlmock) {
+	for _, table := range []string{"FROM direct_message_recipients r", "FROM system_notification_receipts snu", "WHERE sn.audience = 'global'"} {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*)") + ".*" + regexp.QuoteMeta(table)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+}
+
+func TestBulkReadTellsSenders(t *testing.T) {
+	const (
+		readerID, senderID = int64(7), int64(3)
+		messageID, ownID   = int64(42), int64(43)
+	)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		lock string
+		read func(l *BulkReadLogic) error
+	}{
+		{
+			name: "batch",
+			lock: "r.message_id IN (?, ?)",
+			read: func(l *BulkReadLogic) error {
+				_, err := l.MarkReadBatch(&types.MarkReadBatchRequest{PersonalIds: []int64{messageID, ownID}, UserId: readerID})
+				return err
+			},
+		},
+		{
+			name: "mark all",
+			lock: "r.deleted_at IS NULL AND dm.created_at <= ?",
+			read: func(l *BulkReadLogic) error {
+				_, err := l.MarkAllRead(&types.MarkAllReadRequest{Channel: "personal", UserId: readerID})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			events, err := eventhub.New(eventhub.Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer events.Close()
+			senderSub, _, _ := events.Subscribe(senderID, "")
+			defer senderSub.Close()
+			readerSub, _, _ := events.Subscribe(readerID, "")
+			defer readerSub.Close()
+
+			// The message the reader sent to themselves is read too, but
+			// only the other sender is told.
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE r.user_id = ? AND r.is_read = 0 AND " + tt.lock + "\nFOR UPDATE")).
+				WillReturnRows(sqlmock.NewRows([]string{"message_id", "sender_id"}).
+					AddRow(messageID, senderID).
+					AddRow(ownID, readerID))
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE direct_message_recipients")).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE dm.id = ? AND (dm.sender_id = ? OR r.id IS NOT NULL)")).
+				WithArgs(senderID, messageID, senderID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "sender_id", "receiver_id", "title", "content", "is_read",
+					"read_at", "created_at", "thread_id", "reply_to_id", "group_id"}).
+					AddRow(messageID, senderID, readerID, "hi", "hello", false, nil, now, messageID, nil, nil))
+			mock.ExpectQuery(regexp.QuoteMeta("FROM direct_message_recipients WHERE message_id IN (?)")).
+				WithArgs(messageID).
+				WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "kind", "group_id", "is_read", "read_at"}).
+					AddRow(messageID, readerID, "to", nil, true, now))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, display_name, avatar_url FROM users WHERE id IN (?, ?)")).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name", "avatar_url"}).
+					AddRow(senderID, "bob", "", "").
+					AddRow(readerID, "alice", "", ""))
+			expectUnreadCounts(mock)
+
+			if err := tt.read(NewBulkReadLogic(context.Background(), &svc.ServiceContext{DB: db, Events: events})); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case evt := <-senderSub.Events():
+				var msg types.Message
+				if err := json.Unmarshal(evt.Data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if evt.Type != eventhub.EventRecipientsRead || msg.Id != messageID ||
+					len(msg.Recipients) != 1 || !msg.Recipients[0].IsRead {
+					t.Fatalf("event = %s %+v", evt.Type, msg)
+				}
+			default:
+				t.Fatal("sender not told")
+			}
+			select {
+			case evt := <-senderSub.Events():
+				t.Fatalf("unexpected event %s for the sender", evt.Type)
+			default:
+			}
+
+			for _, want := range []string{eventhub.EventMessagesRead, eventhub.EventUnreadChanged} {
+				select {
+				case evt := <-readerSub.Events():
+					if evt.Type != want {
+						t.Fatalf("reader got %s, want %s", evt.Type, want)
+					}
+				default:
+					t.Fatalf("reader missed %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkAllReadGlobal(t *testing.T) {
+	const userID = int64(7)
+	before := time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		priority string
+		expect   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "without priority the watermark moves up to before",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO system_notification_watermarks (user_id, read_until)
+SELECT u.id, GREATEST(?, u.created_at) FROM users u WHERE u.id = ?
+ON DUPLICATE KEY UPDATE read_until = GREATEST(read_until, VALUES(read_until))`)).
+					WithArgs(before, userID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			// Moving the watermark would read every priority, so only the
+			// matching notifications past it get read receipts.
+			name:     "with priority receipts are materialised instead",
+			priority: "critical",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`SELECT sn.id, u.id, 1, ?, sn.created_at`)+`.*`+
+					regexp.QuoteMeta(`sn.created_at > COALESCE(w.read_until, u.created_at) AND sn.created_at <= ? AND sn.priority = ?`)).
+					WithArgs(nowArg{}, userID, before, "critical").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			events, err := eventhub.New(eventhub.Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer events.Close()
+
+			receiptArgs := []driver.Value{nowArg{}, userID, before}
+			if tt.priority != "" {
+				receiptArgs = append(receiptArgs, tt.priority)
+			}
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE system_notification_receipts snu")).
+				WithArgs(receiptArgs...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tt.expect(mock)
+			mock.ExpectCommit()
+			expectUnreadCounts(mock)
+
+			_, err = NewBulkReadLogic(context.Background(), &svc.ServiceContext{DB: db, Events: events}).MarkAllRead(&types.MarkAllReadRequest{
+				Channel:  "system",
+				Priority: tt.priority,
+				Before:   before.Format(time.RFC3339),
+				UserId:   userID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package logic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// totalSkipped is reported as the total when the caller opted out of the
+// COUNT(*) query.
+const totalSkipped = -1
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// listCursor is the keyset position of the last returned item in
+// inboxSortKey order.
+type listCursor struct {
+	CreatedAt      int64 `json:"c"`
+	Source         int   `json:"s"`
+	Id             int64 `json:"i"`
+	NotificationId int64 `json:"n,omitempty"`
+}
+
+func (c *listCursor) createdAt() time.Time {
+	return time.Unix(c.CreatedAt, 0)
+}
+
+func encodeListCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeListCursor returns nil for an empty cursor.
+func decodeListCursor(value string) (*listCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &c, nil
+}
+
+func cursorAfter(msg types.Message) (string, error) {
+	createdAt, err := time.Parse(time.RFC3339, msg.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("build cursor: %w", err)
+	}
+
+	source := sourceSystem
+	if msg.Channel == "personal" {
+		source = sourcePersonal
+	}
+
+	return encodeListCursor(listCursor{
+		CreatedAt:      createdAt.Unix(),
+		Source:         source,
+		Id:             msg.Id,
+		NotificationId: msg.NotificationId,
+	}), nil
+}
+
+type messagePage struct {
+	items      []types.Message
+	total      int64
+	nextCursor string
+}
+
+// newMessagePage trims the look-ahead row fetched by queryInbox and derives
+// the next cursor from the last item kept.
+func newMessagePage(items []types.Message, total, size int64) *messagePage {
+	page := &messagePage{items: items, total: total}
+	if int64(len(items)) <= size {
+		return page
+	}
+
+	page.items = items[:size]
+	if next, err := cursorAfter(page.items[size-1]); err == nil {
+		page.nextCursor = next
+	}
+
+	return page
+}
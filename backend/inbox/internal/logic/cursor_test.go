@@ -0,0 +1,149 @@
+package logic
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+func TestListCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor listCursor
+	}{
+		{name: "personal", cursor: listCursor{CreatedAt: 1767225600, Source: sourcePersonal, Id: 42}},
+		{name: "system receipt", cursor: listCursor{CreatedAt: 1767225600, Source: sourceSystem, Id: 9, NotificationId: 3}},
+		{name: "global without receipt", cursor: listCursor{CreatedAt: 1767225600, Source: sourceSystem, NotificationId: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeListCursor(encodeListCursor(tt.cursor))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.cursor {
+				t.Fatalf("decoded %+v, want %+v", *got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeListCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantNil bool
+		wantErr error
+	}{
+		{name: "empty means first page", value: "", wantNil: true},
+		{name: "not base64", value: "%%%", wantErr: errInvalidCursor},
+		{name: "padded base64", value: "eyJjIjoxfQ==", wantErr: errInvalidCursor},
+		{name: "not json", value: "bm9wZQ", wantErr: errInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeListCursor(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantNil && got != nil {
+				t.Fatalf("cursor = %+v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestNewMessagePage(t *testing.T) {
+	items := []types.Message{
+		{Id: 5, Channel: "personal", CreatedAt: "2026-01-01T00:00:05Z"},
+		{Id: 4, Channel: "system", NotificationId: 2, CreatedAt: "2026-01-01T00:00:04Z"},
+		{Id: 3, Channel: "personal", CreatedAt: "2026-01-01T00:00:03Z"},
+	}
+
+	tests := []struct {
+		name      string
+		items     []types.Message
+		size      int64
+		wantItems int
+		wantNext  *listCursor
+	}{
+		{name: "last page", items: items, size: 3, wantItems: 3},
+		{
+			name:      "look-ahead row trimmed",
+			items:     items,
+			size:      2,
+			wantItems: 2,
+			wantNext: &listCursor{
+				CreatedAt:      time.Date(2026, 1, 1, 0, 0, 4, 0, time.UTC).Unix(),
+				Source:         sourceSystem,
+				Id:             4,
+				NotificationId: 2,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := newMessagePage(tt.items, 10, tt.size)
+			if len(page.items) != tt.wantItems {
+				t.Fatalf("kept %d items, want %d", len(page.items), tt.wantItems)
+			}
+
+			if tt.wantNext == nil {
+				if page.nextCursor != "" {
+					t.Fatalf("nextCursor = %q, want none", page.nextCursor)
+				}
+				return
+			}
+			next, err := decodeListCursor(page.nextCursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *next != *tt.wantNext {
+				t.Fatalf("next = %+v, want %+v", *next, *tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	cursor := &listCursor{CreatedAt: 1767225600, Source: sourceSystem, Id: 9, NotificationId: 3}
+	at := cursor.createdAt()
+
+	tests := []struct {
+		name     string
+		key      map[string]string
+		wantCond string
+	}{
+		{
+			name: "personal",
+			key:  personalBranch(1, "all", "").key,
+			wantCond: "(dm.created_at < ? OR (dm.created_at = ? AND (2 < ? OR (2 = ? AND " +
+				"(dm.id < ? OR (dm.id = ? AND 0 < ?))))))",
+		},
+		{
+			name: "global",
+			key:  globalBranch(1, "all").key,
+			wantCond: "(sn.created_at < ? OR (sn.created_at = ? AND (1 < ? OR (1 = ? AND " +
+				"(0 < ? OR (0 = ? AND sn.id < ?))))))",
+		},
+	}
+
+	wantArgs := []interface{}{at, at, sourceSystem, sourceSystem, int64(9), int64(9), int64(3)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args := keysetCondition(tt.key, cursor)
+			if cond != tt.wantCond {
+				t.Fatalf("cond = %s\nwant   %s", cond, tt.wantCond)
+			}
+			if !reflect.DeepEqual(args, wantArgs) {
+				t.Fatalf("args = %v, want %v", args, wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type MessageDetailLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewMessageDetailLogic(ctx context.Context, svcCtx *svc.ServiceContext) *MessageDetailLogic {
+	return &MessageDetailLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// MessageDetail returns a message to its sender or receiver. A personal
+// message the caller deleted is only found with Trash set. Anyone else gets
+// the same not-found error as for a missing id, so ids cannot be probed.
+func (l *MessageDetailLogic) MessageDetail(req *types.MessageDetailRequest) (*types.Message, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	var (
+		msg *types.Message
+		err error
+	)
+
+	switch req.Channel {
+	case "system":
+		if req.NotificationId > 0 {
+			msg, err = fetchGlobalMessage(l.ctx, l.svcCtx.DB, req.NotificationId, req.UserId)
+		} else {
+			msg, err = fetchSystemMessage(l.ctx, l.svcCtx.DB, req.Id)
+		}
+		if err == nil && msg.ReceiverId != req.UserId {
+			err = sql.ErrNoRows
+		}
+	default:
+		msg, err = fetchPartyMessage(l.ctx, l.svcCtx.DB, req.Id, req.UserId, req.Trash)
+	}
+
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+
+	// Opening a message only reads it for a receiver; a sender looking at
+	// their own message, or anyone looking into their trash, leaves its
+	// state alone.
+	if req.MarkRead && !req.Trash && receivedBy(msg, req.UserId) && !msg.IsRead {
+		markReq := &types.MarkReadRequest{
+			Id:      msg.Id,
+			Channel: msg.Channel,
+			UserId:  req.UserId,
+		}
+		if msg.Id == 0 {
+			markReq.NotificationId = msg.NotificationId
+		}
+		if msg, err = NewMarkMessageReadLogic(l.ctx, l.svcCtx).MarkMessageRead(markReq); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = attachItemState(l.ctx, l.svcCtx.DB, req.UserId, msg); err != nil {
+		return nil, err
+	}
+	if err = attachProfile(l.ctx, l.svcCtx, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// InboxItem is MessageDetail addressed by composite uid.
+func (l *MessageDetailLogic) InboxItem(req *types.InboxItemRequest) (*types.Message, error) {
+	target, err := parseMessageUID(req.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.MessageDetail(&types.MessageDetailRequest{
+		Id:             target.Id,
+		Channel:        target.Channel,
+		NotificationId: target.NotificationId,
+		MarkRead:       req.MarkRead,
+		Trash:          req.Trash,
+		UserId:         req.UserId,
+	})
+}
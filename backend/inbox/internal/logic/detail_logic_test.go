@@ -0,0 +1,94 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+func TestMessageDetailChecksParty(t *testing.T) {
+	const userID, messageID = int64(7), int64(42)
+
+	tests := []struct {
+		name  string
+		trash bool
+		where string
+	}{
+		{
+			name:  "mailbox copy only while not deleted",
+			where: "((r.id IS NOT NULL AND r.deleted_at IS NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NULL))",
+		},
+		{
+			name:  "trash copy only once deleted",
+			trash: true,
+			where: "((r.id IS NOT NULL AND r.deleted_at IS NOT NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NOT NULL))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			// Neither a stranger nor a party looking in the wrong folder
+			// matches, and both get the same error as a missing id.
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE dm.id = ? AND "+tt.where)).
+				WithArgs(userID, messageID, userID).
+				WillReturnError(sql.ErrNoRows)
+
+			l := NewMessageDetailLogic(context.Background(), &svc.ServiceContext{DB: db})
+			_, err = l.MessageDetail(&types.MessageDetailRequest{
+				Id:       messageID,
+				Channel:  "personal",
+				MarkRead: true,
+				Trash:    tt.trash,
+				UserId:   userID,
+			})
+			if err == nil || err.Error() != "message not found or unauthorized" {
+				t.Fatalf("err = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFetchPartyMessage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE dm.id = ? AND "+partyMailbox)).
+		WithArgs(int64(7), int64(42), int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender_id", "receiver_id", "title", "content", "is_read",
+			"read_at", "created_at", "thread_id", "reply_to_id", "group_id"}).
+			AddRow(42, 3, 7, "hi", "hello", false, nil, now, 42, nil, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM direct_message_recipients WHERE message_id IN (?)")).
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "kind", "group_id", "is_read", "read_at"}).
+			AddRow(42, 7, "to", nil, false, nil))
+
+	msg, err := fetchPartyMessage(context.Background(), db, 42, 7, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Id != 42 || !receivedBy(msg, 7) {
+		t.Fatalf("message = %+v", msg)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
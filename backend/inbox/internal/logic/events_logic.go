@@ -0,0 +1,238 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type EventsLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewEventsLogic(ctx context.Context, svcCtx *svc.ServiceContext) *EventsLogic {
+	return &EventsLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// Events streams the user's inbox events into client until the request ends
+// or the subscriber falls too far behind.
+func (l *EventsLogic) Events(req *types.EventsRequest, client chan<- *eventhub.Event) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	// A browser reconnects with a new EventSource and a fresh ticket, and
+	// such a request cannot carry the header.
+	lastEventID := req.LastEventId
+	if lastEventID == "" {
+		lastEventID = req.LastEventIdQuery
+	}
+
+	return streamInbox(l.ctx, l.svcCtx, req.UserId, lastEventID, func(evt *eventhub.Event) bool {
+		select {
+		case client <- evt:
+			return true
+		case <-l.ctx.Done():
+			return false
+		}
+	})
+}
+
+type StreamTicketLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewStreamTicketLogic(ctx context.Context, svcCtx *svc.ServiceContext) *StreamTicketLogic {
+	return &StreamTicketLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// StreamTicket issues a one-time ticket bound to the caller's access token,
+// for opening an event stream without putting the token in the URL.
+func (l *StreamTicketLogic) StreamTicket(req *types.StreamTicketRequest) (*types.StreamTicketResponse, error) {
+	token, ok := authctx.TokenFromCtx(l.ctx)
+	if !ok || req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	ticket, err := l.svcCtx.Sessions.IssueTicket(l.ctx, session.Ticket{
+		UserID:         req.UserId,
+		Role:           authctx.RoleFromCtx(l.ctx),
+		SessionID:      token.SessionID,
+		TokenID:        token.ID,
+		TokenExpiresAt: token.ExpiresAt,
+	}, l.svcCtx.TicketExpire)
+	if err != nil {
+		return nil, fmt.Errorf("签发连接凭证失败: %w", err)
+	}
+
+	return &types.StreamTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int64(l.svcCtx.TicketExpire / time.Second),
+	}, nil
+}
+
+// streamInbox feeds one connection. A reconnect whose lastEventID is still in
+// the hub's history first gets the events it missed; every connection then
+// gets an unread.changed snapshot, and each burst of live events is followed
+// by fresh counters. The counters are computed here rather than by the
+// publisher because only this replica knows the user is connected.
+func streamInbox(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, lastEventID string,
+	emit func(*eventhub.Event) bool) error {
+	sub, missed, _ := svcCtx.Events.Subscribe(userID, lastEventID)
+	defer sub.Close()
+
+	for i := range missed {
+		if !emit(&missed[i]) {
+			return nil
+		}
+	}
+
+	snapshot, err := unreadSnapshot(ctx, svcCtx, userID)
+	if err != nil {
+		return err
+	}
+	if !emit(snapshot) {
+		return nil
+	}
+
+	for {
+		select {
+		case evt, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			if !emit(&evt) {
+				return nil
+			}
+
+			// Coalesce a burst, such as a broadcast batch, into one update;
+			// publishers that already know the counters send them directly.
+			if evt.Type == eventhub.EventUnreadChanged || len(sub.Events()) > 0 {
+				continue
+			}
+			snapshot, err := unreadSnapshot(ctx, svcCtx, userID)
+			if err != nil {
+				logx.WithContext(ctx).Errorf("unread count for user %d event: %v", userID, err)
+				continue
+			}
+			if !emit(snapshot) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// unreadSnapshot builds an unread.changed event outside the hub's sequence;
+// it carries no id so the client keeps its Last-Event-ID.
+func unreadSnapshot(ctx context.Context, svcCtx *svc.ServiceContext, userID int64) (*eventhub.Event, error) {
+	counts, err := NewUnreadCountLogic(ctx, svcCtx).UnreadCount(&types.UnreadCountRequest{UserId: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	return eventhub.NewEvent(userID, eventhub.EventUnreadChanged, counts)
+}
+
+// notifyMessageCreated tells the receiver about a new inbox item. Event
+// delivery is best effort and never fails the write that triggered it.
+func notifyMessageCreated(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, msg *types.Message) {
+	publishEvent(ctx, svcCtx, userID, eventhub.EventMessageCreated, msg)
+}
+
+func notifyMessageRead(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, msg *types.Message) {
+	publishEvent(ctx, svcCtx, userID, eventhub.EventMessageRead, msg)
+}
+
+func notifyMessageUnread(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, msg *types.Message) {
+	publishEvent(ctx, svcCtx, userID, eventhub.EventMessageUnread, msg)
+}
+
+// notifyRecipientsChanged tells the sender of a personal message that a
+// recipient's read state changed, with the sender's view of the message.
+func notifyRecipientsChanged(ctx context.Context, svcCtx *svc.ServiceContext, senderID, messageID int64) {
+	msg, err := fetchPersonalMessage(ctx, svcCtx.DB, messageID, senderID)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("load message %d for its sender: %v", messageID, err)
+		return
+	}
+	if err = attachProfile(ctx, svcCtx, msg); err != nil {
+		logx.WithContext(ctx).Errorf("load profiles of message %d: %v", messageID, err)
+	}
+
+	publishEvent(ctx, svcCtx, senderID, eventhub.EventRecipientsRead, msg)
+}
+
+// notifyReceiptsCreated announces a batch of broadcast receipts. The users
+// may be connected to any replica, so every recipient gets an event.
+func notifyReceiptsCreated(ctx context.Context, svcCtx *svc.ServiceContext, notificationID int64, userIDs []int64) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	placeholders, args := inList(userIDs)
+	rows, err := svcCtx.DB.QueryContext(ctx, fmt.Sprintf(`
+SELECT %s
+FROM system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+WHERE snu.notification_id = ? AND snu.user_id IN (%s)`, systemColumns, placeholders),
+		append([]interface{}{notificationID}, args...)...)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("load receipts of notification %d for events: %v", notificationID, err)
+		return
+	}
+	defer rows.Close()
+
+	var items []types.Message
+	for rows.Next() {
+		msg, err := scanSystemRow(rows)
+		if err != nil {
+			logx.WithContext(ctx).Errorf("load receipts of notification %d for events: %v", notificationID, err)
+			return
+		}
+		items = append(items, msg)
+	}
+
+	if err := attachProfiles(ctx, svcCtx, items); err != nil {
+		logx.WithContext(ctx).Errorf("load profiles of notification %d for events: %v", notificationID, err)
+	}
+	for i := range items {
+		notifyMessageCreated(ctx, svcCtx, items[i].ReceiverId, &items[i])
+	}
+}
+
+// notifyGlobalCreated announces a global notification to every connected
+// user; the item is addressed by notification id until it is read.
+func notifyGlobalCreated(ctx context.Context, svcCtx *svc.ServiceContext, msg *types.Message) {
+	item := *msg
+	item.Delivery = nil
+	if err := svcCtx.Events.PublishAll(ctx, eventhub.EventMessageCreated, &item); err != nil {
+		logx.WithContext(ctx).Errorf("publish global notification %d: %v", msg.NotificationId, err)
+	}
+}
+
+func publishEvent(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, eventType string, data interface{}) {
+	if err := svcCtx.Events.Publish(ctx, userID, eventType, data); err != nil {
+		logx.WithContext(ctx).Errorf("publish %s to user %d: %v", eventType, userID, err)
+	}
+}
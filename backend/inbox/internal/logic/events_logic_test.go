@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+func TestEventsResumesFromQueryParameter(t *testing.T) {
+	const userID = int64(7)
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	events, err := eventhub.New(eventhub.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+
+	sub, _, _ := events.Subscribe(userID, "")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		if err := events.Publish(context.Background(), userID, eventhub.EventMessageCreated, map[string]int{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, (<-sub.Events()).ID)
+	}
+	sub.Close()
+
+	tests := []struct {
+		name string
+		req  types.EventsRequest
+		want []string
+	}{
+		{
+			name: "query only",
+			req:  types.EventsRequest{LastEventIdQuery: ids[0]},
+			want: ids[1:],
+		},
+		{
+			name: "header wins",
+			req:  types.EventsRequest{LastEventId: ids[1], LastEventIdQuery: ids[0]},
+			want: ids[2:],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.UserId = userID
+			client := make(chan *eventhub.Event, 8)
+
+			// The unread snapshot after the replay finds no database
+			// expectation and ends the stream, leaving only the replay.
+			err := NewEventsLogic(context.Background(), &svc.ServiceContext{DB: db, Events: events}).Events(&req, client)
+			if err == nil {
+				t.Fatal("stream did not stop at the unread snapshot")
+			}
+			close(client)
+
+			var got []string
+			for evt := range client {
+				got = append(got, evt.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	itemPersonal = "personal"
+	itemSystem   = "system"
+)
+
+// inboxItemState is the payload of message.updated: how the user has
+// organised an item.
+type inboxItemState struct {
+	Uid      string   `json:"uid"`
+	Starred  bool     `json:"starred"`
+	Archived bool     `json:"archived"`
+	Labels   []string `json:"labels"`
+}
+
+type InboxFlagLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewInboxFlagLogic(ctx context.Context, svcCtx *svc.ServiceContext) *InboxFlagLogic {
+	return &InboxFlagLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// Archive takes an item out of the inbox without deleting it; it stays
+// reachable through status=archived and its labels.
+func (l *InboxFlagLogic) Archive(req *types.InboxItemFlagRequest) error {
+	return l.setFlag(req, "archived_at", true)
+}
+
+func (l *InboxFlagLogic) Unarchive(req *types.InboxItemFlagRequest) error {
+	return l.setFlag(req, "archived_at", false)
+}
+
+func (l *InboxFlagLogic) Star(req *types.InboxItemFlagRequest) error {
+	return l.setFlag(req, "starred_at", true)
+}
+
+func (l *InboxFlagLogic) Unstar(req *types.InboxItemFlagRequest) error {
+	return l.setFlag(req, "starred_at", false)
+}
+
+// setFlag sets or clears one of the message_flags timestamps. Setting it
+// again keeps the original time so starred and archived views stay stable.
+func (l *InboxFlagLogic) setFlag(req *types.InboxItemFlagRequest, column string, on bool) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	itemType, itemID, err := resolveInboxItem(l.ctx, l.svcCtx, req.Uid, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	at := sql.NullTime{Time: time.Now(), Valid: on}
+	if _, err = l.svcCtx.DB.ExecContext(
+		l.ctx,
+		fmt.Sprintf(`INSERT INTO message_flags (user_id, item_type, item_id, %[1]s) VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE %[1]s = IF(? AND %[1]s IS NOT NULL, %[1]s, VALUES(%[1]s))`, column),
+		req.UserId,
+		itemType,
+		itemID,
+		at,
+		on,
+	); err != nil {
+		return fmt.Errorf("update message flags: %w", err)
+	}
+
+	publishItemState(l.ctx, l.svcCtx, req.UserId, itemType, itemID)
+
+	return nil
+}
+
+// resolveInboxItem maps a uid to the row flags and labels hang off, checking
+// that the user may organise it. A global notification gets its receipt
+// materialised first, with the read state the watermark gave it.
+func resolveInboxItem(ctx context.Context, svcCtx *svc.ServiceContext, uid string, userID int64) (string, int64, error) {
+	target, err := parseMessageUID(uid)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case target.Channel == "personal":
+		msg, err := fetchPersonalMessage(ctx, svcCtx.DB, target.Id, userID)
+		if err != nil {
+			return "", 0, translateNotFound(err)
+		}
+		return itemPersonal, msg.Id, nil
+	case target.Id > 0:
+		var receiptID int64
+		if err := svcCtx.DB.QueryRowContext(
+			ctx,
+			`SELECT id FROM system_notification_receipts WHERE id = ? AND user_id = ?`,
+			target.Id,
+			userID,
+		).Scan(&receiptID); err != nil {
+			return "", 0, translateNotFound(err)
+		}
+		return itemSystem, receiptID, nil
+	default:
+		receiptID, err := ensureGlobalReceipt(ctx, svcCtx.DB, target.NotificationId, userID)
+		if err != nil {
+			return "", 0, translateNotFound(err)
+		}
+		return itemSystem, receiptID, nil
+	}
+}
+
+// ensureGlobalReceipt returns the user's receipt for a global notification,
+// creating one that keeps the item's current read state if there is none. An
+// item the watermark has passed was read at the watermark, as listings show.
+func ensureGlobalReceipt(ctx context.Context, db *sql.DB, notificationID, userID int64) (int64, error) {
+	query := fmt.Sprintf(`
+INSERT IGNORE INTO system_notification_receipts (notification_id, user_id, is_read, read_at, created_at)
+SELECT sn.id, u.id, sn.created_at <= %[1]s, IF(sn.created_at <= %[1]s, %[1]s, NULL), sn.created_at %[2]s
+WHERE sn.id = ? AND sn.audience = 'global' AND snu.id IS NULL`, globalWatermarkExpr, globalNotificationsFrom)
+	if _, err := db.ExecContext(ctx, query, userID, notificationID); err != nil {
+		return 0, fmt.Errorf("materialize global receipt: %w", err)
+	}
+
+	var receiptID int64
+	if err := db.QueryRowContext(
+		ctx,
+		`SELECT snu.id FROM system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+WHERE snu.notification_id = ? AND snu.user_id = ? AND sn.audience = 'global'`,
+		notificationID,
+		userID,
+	).Scan(&receiptID); err != nil {
+		return 0, err
+	}
+
+	return receiptID, nil
+}
+
+// attachLabels fills in the user's label names for a page of items with one
+// query. Global items without a receipt cannot carry labels.
+func attachLabels(ctx context.Context, db *sql.DB, userID int64, items []types.Message) error {
+	var personalIDs, systemIDs []int64
+	for _, item := range items {
+		switch {
+		case item.Channel == "personal":
+			personalIDs = append(personalIDs, item.Id)
+		case item.Id > 0:
+			systemIDs = append(systemIDs, item.Id)
+		}
+	}
+	if len(personalIDs) == 0 && len(systemIDs) == 0 {
+		return nil
+	}
+
+	labels, err := loadLabelNames(ctx, db, userID, personalIDs, systemIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if items[i].Channel == "personal" || items[i].Id > 0 {
+			items[i].Labels = labels[messageUID(items[i])]
+		}
+	}
+
+	return nil
+}
+
+// attachItemState fills in flags and labels for a single item loaded outside
+// the listing query.
+func attachItemState(ctx context.Context, db *sql.DB, userID int64, msg *types.Message) error {
+	if msg.Channel != "personal" && msg.Id == 0 {
+		return nil
+	}
+
+	itemType := itemSystem
+	if msg.Channel == "personal" {
+		itemType = itemPersonal
+	}
+
+	state, err := loadItemState(ctx, db, userID, itemType, msg.Id)
+	if err != nil {
+		return err
+	}
+
+	msg.Starred = state.Starred
+	msg.Archived = state.Archived
+	if len(state.Labels) > 0 {
+		msg.Labels = state.Labels
+	}
+
+	return nil
+}
+
+func loadItemState(ctx context.Context, db *sql.DB, userID int64, itemType string, itemID int64) (*inboxItemState, error) {
+	state := &inboxItemState{
+		Uid:    itemType + ":" + strconv.FormatInt(itemID, 10),
+		Labels: []string{},
+	}
+
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT starred_at IS NOT NULL, archived_at IS NOT NULL FROM message_flags
+WHERE user_id = ? AND item_type = ? AND item_id = ?`,
+		userID,
+		itemType,
+		itemID,
+	).Scan(&state.Starred, &state.Archived)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("load message flags: %w", err)
+	}
+
+	var personalIDs, systemIDs []int64
+	if itemType == itemPersonal {
+		personalIDs = []int64{itemID}
+	} else {
+		systemIDs = []int64{itemID}
+	}
+	labels, err := loadLabelNames(ctx, db, userID, personalIDs, systemIDs)
+	if err != nil {
+		return nil, err
+	}
+	if names := labels[state.Uid]; names != nil {
+		state.Labels = names
+	}
+
+	return state, nil
+}
+
+// loadLabelNames returns the user's label names keyed by item uid.
+func loadLabelNames(ctx context.Context, db *sql.DB, userID int64, personalIDs, systemIDs []int64) (map[string][]string, error) {
+	var (
+		conds []string
+		args  = []interface{}{userID}
+	)
+	if len(personalIDs) > 0 {
+		placeholders, ids := inList(personalIDs)
+		conds = append(conds, "(li.item_type = 'personal' AND li.item_id IN ("+placeholders+"))")
+		args = append(args, ids...)
+	}
+	if len(systemIDs) > 0 {
+		placeholders, ids := inList(systemIDs)
+		conds = append(conds, "(li.item_type = 'system' AND li.item_id IN ("+placeholders+"))")
+		args = append(args, ids...)
+	}
+
+	where := conds[0]
+	if len(conds) > 1 {
+		where = "(" + conds[0] + " OR " + conds[1] + ")"
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT li.item_type, li.item_id, lb.name
+FROM message_label_items li
+JOIN message_labels lb ON lb.id = li.label_id
+WHERE lb.user_id = ? AND `+where+`
+ORDER BY lb.name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query message labels: %w", err)
+	}
+	defer rows.Close()
+
+	labels := make(map[string][]string)
+	for rows.Next() {
+		var (
+			itemType string
+			itemID   int64
+			name     string
+		)
+		if err := rows.Scan(&itemType, &itemID, &name); err != nil {
+			return nil, fmt.Errorf("scan message label: %w", err)
+		}
+		uid := itemType + ":" + strconv.FormatInt(itemID, 10)
+		labels[uid] = append(labels[uid], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate message labels: %w", err)
+	}
+
+	return labels, nil
+}
+
+// publishItemState tells the user's other sessions how an item is organised
+// now. Like every event it is best effort.
+func publishItemState(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, itemType string, itemID int64) {
+	state, err := loadItemState(ctx, svcCtx.DB, userID, itemType, itemID)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("load state of %s:%d for event: %v", itemType, itemID, err)
+		return
+	}
+
+	publishEvent(ctx, svcCtx, userID, eventhub.EventMessageUpdated, state)
+}
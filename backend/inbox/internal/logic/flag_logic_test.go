@@ -0,0 +1,42 @@
+package logic
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEnsureGlobalReceiptKeepsReadTime(t *testing.T) {
+	const userID, notificationID, receiptID = int64(7), int64(5), int64(12)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A notification behind the watermark was read at the watermark; the
+	// receipt starring it creates must say so rather than leave read_at
+	// empty.
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO system_notification_receipts (notification_id, user_id, is_read, read_at, created_at)")+`\s*`+
+		regexp.QuoteMeta("SELECT sn.id, u.id, sn.created_at <= COALESCE(w.read_until, u.created_at), "+
+			"IF(sn.created_at <= COALESCE(w.read_until, u.created_at), COALESCE(w.read_until, u.created_at), NULL), sn.created_at")).
+		WithArgs(userID, notificationID).
+		WillReturnResult(sqlmock.NewResult(receiptID, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT snu.id FROM system_notification_receipts snu")).
+		WithArgs(notificationID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(receiptID))
+
+	got, err := ensureGlobalReceipt(context.Background(), db, notificationID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != receiptID {
+		t.Fatalf("receipt = %d, want %d", got, receiptID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
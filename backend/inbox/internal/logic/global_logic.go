@@ -0,0 +1,164 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+const audienceGlobal = "global"
+
+// Global notifications are not fanned out. A user sees every global
+// notification; it is unread until the user's receipt marks it read or it
+// falls behind the read watermark, which defaults to the registration time so
+// new accounts do not inherit a backlog of old announcements.
+const (
+	globalWatermarkExpr = "COALESCE(w.read_until, u.created_at)"
+
+	globalNotificationsFrom = `FROM system_notifications sn
+JOIN users u ON u.id = ?
+LEFT JOIN system_notification_watermarks w ON w.user_id = u.id
+LEFT JOIN system_notification_receipts snu ON snu.notification_id = sn.id AND snu.user_id = u.id`
+)
+
+// globalRead is the read state of a global notification the user has no
+// receipt for: read once the watermark has reached it, and read at the
+// watermark.
+func globalRead(createdAt, readUntil time.Time) bool {
+	return !createdAt.After(readUntil)
+}
+
+func (l *SendMessageLogic) publishGlobalNotification(req *types.SendMessageRequest) (*types.Message, error) {
+	createdBy := req.SenderId
+	if createdBy < 0 {
+		createdBy = 0
+	}
+
+	res, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO system_notifications (title, content, priority, created_by, audience) VALUES (?, ?, ?, ?, ?)`,
+		req.Title,
+		req.Content,
+		req.Priority,
+		createdBy,
+		audienceGlobal,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert global notification: %w", err)
+	}
+
+	notificationID, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("fetch notification id: %w", err)
+	}
+
+	indexNotifications(l.ctx, l.svcCtx, notificationID)
+
+	status, err := fetchDeliveryStatus(l.ctx, l.svcCtx.DB, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &types.Message{
+		NotificationId: notificationID,
+		SenderId:       createdBy,
+		Title:          req.Title,
+		Content:        req.Content,
+		CreatedAt:      status.CreatedAt,
+		Channel:        "system",
+		Priority:       req.Priority,
+		Delivery:       status,
+	}
+	msg.Uid = messageUID(*msg)
+	if err = attachProfile(l.ctx, l.svcCtx, msg); err != nil {
+		return nil, err
+	}
+
+	notifyGlobalCreated(l.ctx, l.svcCtx, msg)
+
+	return msg, nil
+}
+
+// countUnreadGlobal counts global notifications the user has neither read
+// nor passed with their watermark.
+func countUnreadGlobal(ctx context.Context, db *sql.DB, userID int64) (int64, error) {
+	query := fmt.Sprintf(`
+SELECT COUNT(*) %s
+WHERE sn.audience = 'global' AND snu.id IS NULL AND sn.created_at > %s`, globalNotificationsFrom, globalWatermarkExpr)
+
+	var count int64
+	if err := db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("global unread count: %w", err)
+	}
+
+	return count, nil
+}
+
+// materializeGlobalReceipt creates (or updates) the user's receipt for a
+// global notification and returns the receipt id. It is called on the first
+// read, or on mark-unread, which needs a receipt to override the watermark.
+// The receipt keeps the notification's timestamp so the item does not move
+// in listings.
+func (l *MarkMessageReadLogic) materializeGlobalReceipt(notificationID, userID int64, read bool) (int64, error) {
+	at := readAt(read)
+	if _, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO system_notification_receipts (notification_id, user_id, is_read, read_at, created_at)
+SELECT sn.id, ?, ?, ?, sn.created_at FROM system_notifications sn WHERE sn.id = ? AND sn.audience = 'global'
+ON DUPLICATE KEY UPDATE is_read = VALUES(is_read), read_at = VALUES(read_at)`,
+		userID,
+		read,
+		at,
+		notificationID,
+	); err != nil {
+		return 0, fmt.Errorf("materialize global receipt: %w", err)
+	}
+
+	// Rows affected cannot tell a missing notification from one already in
+	// the requested state, so look the receipt up instead.
+	var receiptID int64
+	if err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT snu.id FROM system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+WHERE snu.notification_id = ? AND snu.user_id = ? AND sn.audience = 'global'`,
+		notificationID,
+		userID,
+	).Scan(&receiptID); err != nil {
+		return 0, err
+	}
+
+	return receiptID, nil
+}
+
+// fetchGlobalMessage loads a global notification as the user sees it: their
+// receipt once one exists, otherwise the lazily computed item.
+func fetchGlobalMessage(ctx context.Context, db *sql.DB, notificationID, userID int64) (*types.Message, error) {
+	var receiptID int64
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT id FROM system_notification_receipts WHERE notification_id = ? AND user_id = ?`,
+		notificationID,
+		userID,
+	).Scan(&receiptID)
+	switch {
+	case err == nil:
+		return fetchSystemMessage(ctx, db, receiptID)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("load global receipt: %w", err)
+	}
+
+	b := globalBranch(userID, "")
+	query := fmt.Sprintf("SELECT %s\n%s\nWHERE %s AND sn.id = ?", b.columns, b.from, b.where)
+
+	msg, err := scanInboxRow(db.QueryRowContext(ctx, query, append(b.args, notificationID)...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
@@ -0,0 +1,289 @@
+package logic
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+var inboxColumns = []string{"src", "id", "notification_id", "sender_id", "receiver_id", "title", "content", "is_read",
+	"read_at", "created_at", "priority", "thread_id", "reply_to_id", "starred", "archived", "group_id", "read_until"}
+
+var systemRowColumns = []string{"id", "notification_id", "sender_id", "receiver_id", "title", "content", "is_read",
+	"read_at", "created_at", "priority", "group_id"}
+
+func TestFetchGlobalMessageReadState(t *testing.T) {
+	const userID, notificationID = int64(7), int64(5)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// readUntil is the watermark the query reports: the user's own,
+		// or their registration time when they never moved it.
+		readUntil time.Time
+		// receipt, when set, is the user's receipt for the notification.
+		receipt  []driver.Value
+		wantID   int64
+		wantRead bool
+	}{
+		{
+			name:      "sent after the watermark",
+			readUntil: at.Add(-time.Hour),
+		},
+		{
+			name:      "sent at the watermark",
+			readUntil: at,
+			wantRead:  true,
+		},
+		{
+			name:      "passed by read all",
+			readUntil: at.Add(time.Hour),
+			wantRead:  true,
+		},
+		{
+			name:      "user registered after it was sent",
+			readUntil: at.Add(24 * time.Hour),
+			wantRead:  true,
+		},
+		{
+			name:    "receipt marked unread behind the watermark",
+			receipt: []driver.Value{int64(12), notificationID, int64(1), userID, "notice", "body", false, nil, at, "info", nil},
+			wantID:  12,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			lookup := mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM system_notification_receipts WHERE notification_id = ? AND user_id = ?")).
+				WithArgs(notificationID, userID)
+			if tt.receipt != nil {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tt.wantID))
+				mock.ExpectQuery(regexp.QuoteMeta("WHERE snu.id = ?")).
+					WithArgs(tt.wantID).
+					WillReturnRows(sqlmock.NewRows(systemRowColumns).AddRow(tt.receipt...))
+			} else {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(regexp.QuoteMeta("COALESCE(w.read_until, u.created_at) AS read_until")+`.*`+
+					regexp.QuoteMeta("WHERE sn.audience = 'global' AND snu.id IS NULL AND sn.id = ?")).
+					WithArgs(userID, notificationID).
+					WillReturnRows(sqlmock.NewRows(inboxColumns).
+						AddRow(sourceSystem, 0, notificationID, 1, userID, "notice", "body", false, nil, at, "info", 0, nil, false, false, nil, tt.readUntil))
+			}
+
+			msg, err := fetchGlobalMessage(context.Background(), db, notificationID, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.IsRead != tt.wantRead {
+				t.Fatalf("isRead = %v, want %v", msg.IsRead, tt.wantRead)
+			}
+			// The watermark is when the notification was read.
+			wantReadAt := ""
+			if tt.receipt == nil && tt.wantRead {
+				wantReadAt = tt.readUntil.Format(time.RFC3339)
+			}
+			if msg.ReadAt != wantReadAt {
+				t.Fatalf("readAt = %q, want %q", msg.ReadAt, wantReadAt)
+			}
+			if msg.Id != tt.wantID || msg.NotificationId != notificationID || msg.ReceiverId != userID || msg.Channel != "system" {
+				t.Fatalf("message = %+v", msg)
+			}
+			if msg.CreatedAt != at.Format(time.RFC3339) {
+				t.Fatalf("createdAt = %s, want the notification's", msg.CreatedAt)
+			}
+		})
+	}
+}
+
+// TestListGlobalNotificationsAroundWatermark lists the system channel of a
+// user whose watermark sits between their notifications.
+func TestListGlobalNotificationsAroundWatermark(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const userID, size = int64(7), int64(10)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	watermark := at.Add(-90 * time.Minute)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM system_notification_receipts snu")+`.*`+
+		regexp.QuoteMeta("COALESCE(w.read_until, u.created_at) AS read_until")+`.*`+
+		regexp.QuoteMeta("WHERE sn.audience = 'global' AND snu.id IS NULL")).
+		WithArgs(userID, userID, size+1, userID, size+1, size+1, int64(0)).
+		WillReturnRows(sqlmock.NewRows(inboxColumns).
+			AddRow(sourceSystem, 0, 9, 1, userID, "new", "a", false, nil, at, "info", 0, nil, false, false, nil, watermark).
+			AddRow(sourceSystem, 12, 8, 1, userID, "read", "b", true, at, at.Add(-time.Hour), "info", 0, nil, false, false, nil, nil).
+			AddRow(sourceSystem, 0, 7, 1, userID, "old", "c", false, nil, at.Add(-2*time.Hour), "info", 0, nil, false, false, nil, watermark))
+
+	l := NewListMessagesLogic(context.Background(), &svc.ServiceContext{DB: db})
+	req := &types.ListMessagesRequest{Channel: "system", Status: "all", Page: 1, Size: size, SkipTotal: true, UserId: userID}
+	page, err := l.queryInbox(req, nil, inboxBranches(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		uid    string
+		isRead bool
+		readAt string
+	}{
+		{uid: "global:9"},
+		{uid: "system:12", isRead: true, readAt: at.Format(time.RFC3339)},
+		{uid: "global:7", isRead: true, readAt: watermark.Format(time.RFC3339)},
+	}
+	if len(page.items) != len(want) {
+		t.Fatalf("items = %+v", page.items)
+	}
+	for i, w := range want {
+		got := page.items[i]
+		if got.Uid != w.uid || got.IsRead != w.isRead || got.ReadAt != w.readAt {
+			t.Fatalf("item %d = %s read %v at %q, want %s read %v at %q",
+				i, got.Uid, got.IsRead, got.ReadAt, w.uid, w.isRead, w.readAt)
+		}
+	}
+}
+
+func TestMaterializeGlobalReceipt(t *testing.T) {
+	const userID, notificationID, receiptID = int64(7), int64(5), int64(12)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		read   bool
+		readAt driver.Value
+		// rows is what the receipt now reads as.
+		rows *sqlmock.Rows
+	}{
+		{
+			name:   "read",
+			read:   true,
+			readAt: nowArg{},
+			rows: sqlmock.NewRows(systemRowColumns).
+				AddRow(receiptID, notificationID, 1, userID, "notice", "body", true, at.Add(time.Hour), at, "info", nil),
+		},
+		{
+			name: "unread",
+			rows: sqlmock.NewRows(systemRowColumns).
+				AddRow(receiptID, notificationID, 1, userID, "notice", "body", false, nil, at, "info", nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			events, err := eventhub.New(eventhub.Config{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer events.Close()
+
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO system_notification_receipts")+`.*`+
+				regexp.QuoteMeta("ON DUPLICATE KEY UPDATE is_read = VALUES(is_read), read_at = VALUES(read_at)")).
+				WithArgs(userID, tt.read, tt.readAt, notificationID).
+				WillReturnResult(sqlmock.NewResult(receiptID, 1))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT snu.id FROM system_notification_receipts snu")).
+				WithArgs(notificationID, userID).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(receiptID))
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE snu.id = ?")).
+				WithArgs(receiptID).
+				WillReturnRows(tt.rows)
+
+			l := NewMarkMessageReadLogic(context.Background(), &svc.ServiceContext{DB: db, Events: events})
+			req := &types.MarkReadRequest{Channel: "system", NotificationId: notificationID, UserId: userID}
+			mark := l.MarkMessageUnread
+			if tt.read {
+				mark = l.MarkMessageRead
+			}
+			msg, err := mark(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The receipt takes over from the lazily computed item: it has
+			// an id of its own but keeps the notification's place.
+			if msg.Id != receiptID || msg.Uid != "system:12" || msg.IsRead != tt.read || (msg.ReadAt != "") != tt.read {
+				t.Fatalf("message = %+v", msg)
+			}
+			if msg.CreatedAt != at.Format(time.RFC3339) {
+				t.Fatalf("createdAt = %s, want the notification's", msg.CreatedAt)
+			}
+		})
+	}
+
+	t.Run("not a global notification", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO system_notification_receipts")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT snu.id FROM system_notification_receipts snu")).
+			WithArgs(notificationID, userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		l := NewMarkMessageReadLogic(context.Background(), &svc.ServiceContext{DB: db})
+		_, err = l.MarkMessageRead(&types.MarkReadRequest{Channel: "system", NotificationId: notificationID, UserId: userID})
+		if err == nil || err.Error() != "message not found or unauthorized" {
+			t.Fatalf("err = %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCountUnreadGlobalHonoursWatermark(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`LEFT JOIN system_notification_watermarks w ON w.user_id = u.id`) + `.*` +
+		regexp.QuoteMeta(`WHERE sn.audience = 'global' AND snu.id IS NULL AND sn.created_at > COALESCE(w.read_until, u.created_at)`)).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := countUnreadGlobal(context.Background(), db, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("count = %d, want 3", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
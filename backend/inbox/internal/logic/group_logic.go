@@ -0,0 +1,324 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	maxGroupNameLength        = 64
+	maxGroupDescriptionLength = 255
+)
+
+var errGroupNotFound = errors.New("group not found")
+
+// GroupLogic manages named groups of users, such as ops or finance, that
+// messages can be addressed to. Anyone may list groups and their members;
+// changing them is for admins, which the routes enforce.
+type GroupLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewGroupLogic(ctx context.Context, svcCtx *svc.ServiceContext) *GroupLogic {
+	return &GroupLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *GroupLogic) ListGroups() (*types.ListGroupsResponse, error) {
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, groupQuery+` GROUP BY g.id ORDER BY g.name`)
+	if err != nil {
+		return nil, fmt.Errorf("query groups: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]types.Group, 0)
+	for rows.Next() {
+		group, err := scanGroupRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate groups: %w", err)
+	}
+
+	return &types.ListGroupsResponse{Items: items}, nil
+}
+
+func (l *GroupLogic) CreateGroup(req *types.CreateGroupRequest) (*types.Group, error) {
+	name, err := normalizeGroupName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(req.Description) > maxGroupDescriptionLength {
+		return nil, fmt.Errorf("group description must be at most %d characters", maxGroupDescriptionLength)
+	}
+
+	result, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO user_groups (name, description, created_by) VALUES (?, ?, ?)`,
+		name,
+		req.Description,
+		req.UserId,
+	)
+	if err != nil {
+		return nil, translateGroupConflict(err, "create group")
+	}
+
+	groupID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("fetch group id: %w", err)
+	}
+
+	return fetchGroup(l.ctx, l.svcCtx.DB, groupID)
+}
+
+// UpdateGroup renames a group or changes its description; empty fields are
+// left as they are.
+func (l *GroupLogic) UpdateGroup(req *types.UpdateGroupRequest) (*types.Group, error) {
+	if _, err := fetchGroup(l.ctx, l.svcCtx.DB, req.Id); err != nil {
+		return nil, err
+	}
+
+	var (
+		sets []string
+		args []interface{}
+	)
+	if req.Name != "" {
+		name, err := normalizeGroupName(req.Name)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "name = ?")
+		args = append(args, name)
+	}
+	if req.Description != "" {
+		if utf8.RuneCountInString(req.Description) > maxGroupDescriptionLength {
+			return nil, fmt.Errorf("group description must be at most %d characters", maxGroupDescriptionLength)
+		}
+		sets = append(sets, "description = ?")
+		args = append(args, req.Description)
+	}
+
+	if len(sets) > 0 {
+		if _, err := l.svcCtx.DB.ExecContext(
+			l.ctx,
+			`UPDATE user_groups SET `+strings.Join(sets, ", ")+` WHERE id = ?`,
+			append(args, req.Id)...,
+		); err != nil {
+			return nil, translateGroupConflict(err, "update group")
+		}
+	}
+
+	return fetchGroup(l.ctx, l.svcCtx.DB, req.Id)
+}
+
+// DeleteGroup removes a group and its memberships. Messages already
+// delivered through it keep its id, so recipients can still filter by it.
+func (l *GroupLogic) DeleteGroup(req *types.GroupRequest) error {
+	result, err := l.svcCtx.DB.ExecContext(l.ctx, `DELETE FROM user_groups WHERE id = ?`, req.Id)
+	if err != nil {
+		return fmt.Errorf("delete group: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleted group rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errGroupNotFound
+	}
+
+	return nil
+}
+
+func (l *GroupLogic) ListGroupMembers(req *types.GroupRequest) (*types.GroupMembersResponse, error) {
+	if _, err := fetchGroup(l.ctx, l.svcCtx.DB, req.Id); err != nil {
+		return nil, err
+	}
+
+	rows, err := l.svcCtx.DB.QueryContext(
+		l.ctx,
+		`SELECT m.user_id, u.username, m.created_at
+FROM user_group_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.group_id = ?
+ORDER BY u.username`,
+		req.Id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query group members: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]types.GroupMember, 0)
+	for rows.Next() {
+		var (
+			member   types.GroupMember
+			joinedAt time.Time
+		)
+		if err := rows.Scan(&member.UserId, &member.Username, &joinedAt); err != nil {
+			return nil, fmt.Errorf("scan group member: %w", err)
+		}
+		member.JoinedAt = joinedAt.UTC().Format(time.RFC3339)
+		items = append(items, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate group members: %w", err)
+	}
+
+	return &types.GroupMembersResponse{Items: items}, nil
+}
+
+// AddGroupMembers adds existing users to a group and returns the members.
+// Users already in the group and ids without a user are skipped.
+func (l *GroupLogic) AddGroupMembers(req *types.AddGroupMembersRequest) (*types.GroupMembersResponse, error) {
+	ids := uniqueSortedIDs(req.UserIds)
+	if len(ids) == 0 {
+		return nil, errors.New("userIds is required")
+	}
+	if len(ids) > maxBatchIds {
+		return nil, fmt.Errorf("at most %d users per request", maxBatchIds)
+	}
+
+	if _, err := fetchGroup(l.ctx, l.svcCtx.DB, req.Id); err != nil {
+		return nil, err
+	}
+
+	placeholders, args := inList(ids)
+	if _, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT IGNORE INTO user_group_members (group_id, user_id)
+SELECT ?, id FROM users WHERE id IN (`+placeholders+`)`,
+		append([]interface{}{req.Id}, args...)...,
+	); err != nil {
+		return nil, fmt.Errorf("add group members: %w", err)
+	}
+
+	return l.ListGroupMembers(&types.GroupRequest{Id: req.Id})
+}
+
+func (l *GroupLogic) RemoveGroupMember(req *types.RemoveGroupMemberRequest) error {
+	if _, err := fetchGroup(l.ctx, l.svcCtx.DB, req.Id); err != nil {
+		return err
+	}
+
+	if _, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`DELETE FROM user_group_members WHERE group_id = ? AND user_id = ?`,
+		req.Id,
+		req.MemberId,
+	); err != nil {
+		return fmt.Errorf("remove group member: %w", err)
+	}
+
+	return nil
+}
+
+// groupQuery selects groups with their member counts; callers add the
+// GROUP BY.
+const groupQuery = `SELECT g.id, g.name, g.description, g.created_at, COUNT(m.user_id)
+FROM user_groups g
+LEFT JOIN user_group_members m ON m.group_id = g.id`
+
+func fetchGroup(ctx context.Context, db *sql.DB, groupID int64) (*types.Group, error) {
+	group, err := scanGroupRow(db.QueryRowContext(ctx, groupQuery+` WHERE g.id = ? GROUP BY g.id`, groupID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+func scanGroupRow(scanner interface {
+	Scan(dest ...interface{}) error
+}) (types.Group, error) {
+	var (
+		group     types.Group
+		createdAt time.Time
+	)
+
+	if err := scanner.Scan(&group.Id, &group.Name, &group.Description, &createdAt, &group.MemberCount); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Group{}, err
+		}
+		return types.Group{}, fmt.Errorf("scan group: %w", err)
+	}
+	group.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+
+	return group, nil
+}
+
+// groupMembers lists the members of a group in id order.
+func groupMembers(ctx context.Context, db *sql.DB, groupID int64) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM user_group_members WHERE group_id = ? ORDER BY user_id`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("list group members: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan group member: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate group members: %w", err)
+	}
+
+	return ids, nil
+}
+
+// parseGroupRef reads a group:<id> address.
+func parseGroupRef(ref string) (int64, bool) {
+	kind, rawID, found := strings.Cut(ref, ":")
+	if !found || kind != "group" {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func normalizeGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("group name is required")
+	}
+	if utf8.RuneCountInString(name) > maxGroupNameLength {
+		return "", fmt.Errorf("group name must be at most %d characters", maxGroupNameLength)
+	}
+	return name, nil
+}
+
+func translateGroupConflict(err error, action string) error {
+	if strings.Contains(err.Error(), "Duplicate entry") {
+		return errors.New("a group with this name already exists")
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
@@ -0,0 +1,442 @@
+package logic
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// Listing sources. The source rank is part of the sort key so rows from
+// different tables that share a timestamp and id still have a total order.
+const (
+	sourceSystem   = 1
+	sourcePersonal = 2
+)
+
+// inboxSortKey is the listing order, newest first. Every branch exposes an
+// expression for each key column.
+var inboxSortKey = []string{"created_at", "src", "id", "notification_id"}
+
+// inboxBranch is one SELECT feeding a listing. All branches project the same
+// columns (see scanInboxRow) so they can be merged with UNION ALL. Only
+// global notifications without a receipt have a read_until: their read state,
+// and the read time once it passed them, are decided from it when the row is
+// scanned.
+type inboxBranch struct {
+	columns string
+	from    string
+	where   string
+	args    []interface{}
+	key     map[string]string
+	// group is the column holding the group an item was received through;
+	// empty for sources that are never addressed to groups.
+	group string
+}
+
+// flagsJoin attaches the user's archive/star flags for an item as f. It
+// takes the user id.
+func flagsJoin(itemType, idExpr string) string {
+	return "LEFT JOIN message_flags f ON f.user_id = ? AND f.item_type = '" + itemType + "' AND f.item_id = " + idExpr
+}
+
+// labelCondition matches items carrying the user's label. It takes the user
+// id and the label name.
+func labelCondition(itemType, idExpr string) string {
+	return `EXISTS (SELECT 1 FROM message_label_items li JOIN message_labels lb ON lb.id = li.label_id
+	WHERE lb.user_id = ? AND lb.name = ? AND li.item_type = '` + itemType + `' AND li.item_id = ` + idExpr + `)`
+}
+
+func personalBranch(userID int64, status, label string) inboxBranch {
+	b := inboxBranch{
+		columns: `2 AS src, dm.id AS id, 0 AS notification_id, dm.sender_id AS sender_id,
+	dm.receiver_id AS receiver_id, dm.title AS title, dm.content AS content, ` + personalIsRead + ` AS is_read,
+	` + personalReadAt + ` AS read_at, dm.created_at AS created_at, NULL AS priority, dm.thread_id AS thread_id,
+	dm.reply_to_id AS reply_to_id, f.starred_at IS NOT NULL AS starred, f.archived_at IS NOT NULL AS archived,
+	r.group_id AS group_id, NULL AS read_until`,
+		from: "FROM " + personalFrom + "\n" + flagsJoin("personal", "dm.id"),
+		args: []interface{}{userID, userID},
+		key: map[string]string{
+			"created_at":      "dm.created_at",
+			"src":             "2",
+			"id":              "dm.id",
+			"notification_id": "0",
+		},
+		group: "r.group_id",
+	}
+
+	// The user is a recipient exactly when r matched.
+	const (
+		received = "r.id IS NOT NULL AND r.deleted_at IS NULL"
+		// Either side of the conversation the user has not deleted.
+		party = "((r.id IS NOT NULL AND r.deleted_at IS NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NULL))"
+	)
+
+	switch status {
+	case "sent":
+		b.where = "dm.sender_id = ? AND dm.sender_deleted_at IS NULL"
+		b.args = append(b.args, userID)
+	case "trash":
+		// Each party has its own trash: whatever they deleted from either
+		// side of the conversation.
+		b.where = "((r.id IS NOT NULL AND r.deleted_at IS NOT NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NOT NULL))"
+		b.args = append(b.args, userID)
+	case "archived":
+		b.where = party + " AND f.archived_at IS NOT NULL"
+		b.args = append(b.args, userID)
+	case "starred":
+		b.where = party + " AND f.starred_at IS NOT NULL"
+		b.args = append(b.args, userID)
+	case "any":
+		// Everything outside the trash, for search.
+		b.where = party
+		b.args = append(b.args, userID)
+	case "unread":
+		b.where = received + " AND r.is_read = 0 AND f.archived_at IS NULL"
+	default:
+		// A label view spans sent, received and archived mail; the plain
+		// inbox is what was received and not archived.
+		if label != "" {
+			b.where = party
+			b.args = append(b.args, userID)
+		} else {
+			b.where = received + " AND f.archived_at IS NULL"
+		}
+	}
+
+	if label != "" {
+		b.where += " AND " + labelCondition("personal", "dm.id")
+		b.args = append(b.args, userID, label)
+	}
+
+	return b
+}
+
+func receiptBranch(userID int64, status, label string) inboxBranch {
+	b := inboxBranch{
+		columns: `1 AS src, snu.id AS id, sn.id AS notification_id, sn.created_by AS sender_id,
+	snu.user_id AS receiver_id, sn.title AS title, sn.content AS content, snu.is_read AS is_read,
+	snu.read_at AS read_at, snu.created_at AS created_at, sn.priority AS priority, 0 AS thread_id,
+	NULL AS reply_to_id, f.starred_at IS NOT NULL AS starred, f.archived_at IS NOT NULL AS archived,
+	snu.group_id AS group_id, NULL AS read_until`,
+		from: `FROM system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+` + flagsJoin("system", "snu.id"),
+		where: "snu.user_id = ?",
+		args:  []interface{}{userID, userID},
+		key: map[string]string{
+			"created_at":      "snu.created_at",
+			"src":             "1",
+			"id":              "snu.id",
+			"notification_id": "sn.id",
+		},
+		group: "snu.group_id",
+	}
+
+	switch status {
+	case "archived":
+		b.where += " AND f.archived_at IS NOT NULL"
+	case "starred":
+		b.where += " AND f.starred_at IS NOT NULL"
+	case "unread":
+		b.where += " AND snu.is_read = 0 AND f.archived_at IS NULL"
+	case "any":
+	default:
+		if label == "" {
+			b.where += " AND f.archived_at IS NULL"
+		}
+	}
+
+	if label != "" {
+		b.where += " AND " + labelCondition("system", "snu.id")
+		b.args = append(b.args, userID, label)
+	}
+
+	return b
+}
+
+// globalBranch lists global notifications the user has no receipt for yet.
+// They all carry id 0 until first read materialises a receipt, and cannot be
+// archived, starred or labelled before that either.
+func globalBranch(userID int64, status string) inboxBranch {
+	b := inboxBranch{
+		columns: `1 AS src, 0 AS id, sn.id AS notification_id, sn.created_by AS sender_id,
+	u.id AS receiver_id, sn.title AS title, sn.content AS content,
+	0 AS is_read, NULL AS read_at, sn.created_at AS created_at,
+	sn.priority AS priority, 0 AS thread_id, NULL AS reply_to_id, 0 AS starred, 0 AS archived,
+	NULL AS group_id, ` + globalWatermarkExpr + ` AS read_until`,
+		from:  globalNotificationsFrom,
+		where: "sn.audience = 'global' AND snu.id IS NULL",
+		args:  []interface{}{userID},
+		key: map[string]string{
+			"created_at":      "sn.created_at",
+			"src":             "1",
+			"id":              "0",
+			"notification_id": "sn.id",
+		},
+	}
+
+	if status == "unread" {
+		b.where += " AND sn.created_at > " + globalWatermarkExpr
+	}
+
+	return b
+}
+
+// inboxBranches picks the sources for a listing. The sent and trash folders
+// only exist for personal messages; organised views (archived, starred,
+// labels) only cover items that have a row of their own.
+func inboxBranches(req *types.ListMessagesRequest) []inboxBranch {
+	return filterByGroup(selectBranches(req), req.GroupId)
+}
+
+func selectBranches(req *types.ListMessagesRequest) []inboxBranch {
+	userID, status, label := req.UserId, req.Status, req.Label
+
+	if status == "trash" {
+		return []inboxBranch{personalBranch(userID, status, label)}
+	}
+
+	organised := status == "archived" || status == "starred" || label != ""
+	system := func() []inboxBranch {
+		branches := []inboxBranch{receiptBranch(userID, status, label)}
+		if !organised {
+			branches = append(branches, globalBranch(userID, status))
+		}
+		return branches
+	}
+
+	switch req.Channel {
+	case "system":
+		return system()
+	case "all":
+		if status == "sent" {
+			return []inboxBranch{personalBranch(userID, status, label)}
+		}
+		return append([]inboxBranch{personalBranch(userID, status, label)}, system()...)
+	default:
+		return []inboxBranch{personalBranch(userID, status, label)}
+	}
+}
+
+// filterByGroup keeps the items received through a group, dropping sources
+// that are never addressed to one.
+func filterByGroup(branches []inboxBranch, groupID int64) []inboxBranch {
+	if groupID <= 0 {
+		return branches
+	}
+
+	filtered := branches[:0]
+	for _, b := range branches {
+		if b.group == "" {
+			continue
+		}
+		b.where += " AND " + b.group + " = ?"
+		b.args = append(b.args, groupID)
+		filtered = append(filtered, b)
+	}
+	return filtered
+}
+
+// queryInbox merges the branches into one page. Each branch is sorted and
+// limited on its own, with the cursor pushed down, so the merge only has to
+// look at the head of every source.
+func (l *ListMessagesLogic) queryInbox(req *types.ListMessagesRequest, cursor *listCursor, branches []inboxBranch) (*messagePage, error) {
+	total := int64(totalSkipped)
+	if !req.SkipTotal {
+		counts := make([]string, len(branches))
+		var countArgs []interface{}
+		for i, b := range branches {
+			counts[i] = fmt.Sprintf("(SELECT COUNT(*) %s WHERE %s)", b.from, b.where)
+			countArgs = append(countArgs, b.args...)
+		}
+
+		if err := l.svcCtx.DB.QueryRowContext(
+			l.ctx,
+			"SELECT "+strings.Join(counts, " + "),
+			countArgs...,
+		).Scan(&total); err != nil {
+			return nil, fmt.Errorf("count messages: %w", err)
+		}
+	}
+
+	offset := (req.Page - 1) * req.Size
+	branchLimit := offset + req.Size + 1
+	if cursor != nil {
+		offset = 0
+		branchLimit = req.Size + 1
+	}
+
+	selects := make([]string, len(branches))
+	var args []interface{}
+	for i, b := range branches {
+		where := b.where
+		args = append(args, b.args...)
+		if cursor != nil {
+			cond, condArgs := keysetCondition(b.key, cursor)
+			where += " AND " + cond
+			args = append(args, condArgs...)
+		}
+
+		order := make([]string, len(inboxSortKey))
+		for j, col := range inboxSortKey {
+			order[j] = b.key[col] + " DESC"
+		}
+
+		selects[i] = fmt.Sprintf("(SELECT %s\n%s\nWHERE %s\nORDER BY %s\nLIMIT ?)",
+			b.columns, b.from, where, strings.Join(order, ", "))
+		args = append(args, branchLimit)
+	}
+
+	query := fmt.Sprintf(`
+SELECT * FROM (
+%s
+) inbox
+ORDER BY created_at DESC, src DESC, id DESC, notification_id DESC
+LIMIT ? OFFSET ?`, strings.Join(selects, "\nUNION ALL\n"))
+	args = append(args, req.Size+1, offset)
+
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var items []types.Message
+	for rows.Next() {
+		msg, err := scanInboxRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+
+	return newMessagePage(items, total, req.Size), nil
+}
+
+// keysetCondition builds "key < cursor" as a lexicographic comparison over
+// inboxSortKey using the branch's column expressions.
+func keysetCondition(key map[string]string, cursor *listCursor) (string, []interface{}) {
+	values := map[string]interface{}{
+		"created_at":      cursor.createdAt(),
+		"src":             cursor.Source,
+		"id":              cursor.Id,
+		"notification_id": cursor.NotificationId,
+	}
+
+	var (
+		cond string
+		args []interface{}
+	)
+	for i := len(inboxSortKey) - 1; i >= 0; i-- {
+		col := inboxSortKey[i]
+		expr := key[col]
+		if cond == "" {
+			cond = fmt.Sprintf("%s < ?", expr)
+			args = []interface{}{values[col]}
+			continue
+		}
+		cond = fmt.Sprintf("(%[1]s < ? OR (%[1]s = ? AND %[2]s))", expr, cond)
+		args = append([]interface{}{values[col], values[col]}, args...)
+	}
+
+	return cond, args
+}
+
+func scanInboxRow(scanner interface {
+	Scan(dest ...interface{}) error
+}) (types.Message, error) {
+	var (
+		msg       types.Message
+		src       int
+		title     sql.NullString
+		readAt    sql.NullTime
+		createdAt time.Time
+		priority  sql.NullString
+		replyToID sql.NullInt64
+		groupID   sql.NullInt64
+		readUntil sql.NullTime
+	)
+
+	err := scanner.Scan(
+		&src,
+		&msg.Id,
+		&msg.NotificationId,
+		&msg.SenderId,
+		&msg.ReceiverId,
+		&title,
+		&msg.Content,
+		&msg.IsRead,
+		&readAt,
+		&createdAt,
+		&priority,
+		&msg.ThreadId,
+		&replyToID,
+		&msg.Starred,
+		&msg.Archived,
+		&groupID,
+		&readUntil,
+	)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("scan inbox message: %w", err)
+	}
+
+	if readUntil.Valid && globalRead(createdAt, readUntil.Time) {
+		msg.IsRead = true
+		readAt = readUntil
+	}
+
+	msg.Title = title.String
+	msg.ReadAt = formatNullTime(readAt)
+	msg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	msg.Priority = priority.String
+	msg.ReplyToId = replyToID.Int64
+	msg.GroupId = groupID.Int64
+	msg.Channel = "system"
+	if src == sourcePersonal {
+		msg.Channel = "personal"
+	}
+	msg.Uid = messageUID(msg)
+
+	return msg, nil
+}
+
+// messageUID is the composite id that identifies a listing item across
+// tables: personal:<message id>, system:<receipt id>, or global:<notification
+// id> for a global notification that has no receipt yet.
+func messageUID(msg types.Message) string {
+	switch {
+	case msg.Channel == "personal":
+		return "personal:" + strconv.FormatInt(msg.Id, 10)
+	case msg.Id > 0:
+		return "system:" + strconv.FormatInt(msg.Id, 10)
+	default:
+		return "global:" + strconv.FormatInt(msg.NotificationId, 10)
+	}
+}
+
+// parseMessageUID reverses messageUID into a mark-read request.
+func parseMessageUID(uid string) (*types.MarkReadRequest, error) {
+	kind, rawID, found := strings.Cut(uid, ":")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if !found || err != nil || id <= 0 {
+		return nil, fmt.Errorf("invalid message uid: %q", uid)
+	}
+
+	switch kind {
+	case "personal":
+		return &types.MarkReadRequest{Id: id, Channel: "personal"}, nil
+	case "system":
+		return &types.MarkReadRequest{Id: id, Channel: "system"}, nil
+	case "global":
+		return &types.MarkReadRequest{Channel: "system", NotificationId: id}, nil
+	default:
+		return nil, fmt.Errorf("invalid message uid: %q", uid)
+	}
+}
@@ -0,0 +1,178 @@
+package logic
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+func TestSelectBranches(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     types.ListMessagesRequest
+		wantSrc []string
+	}{
+		{name: "personal", req: types.ListMessagesRequest{Channel: "personal", Status: "all"}, wantSrc: []string{"dm"}},
+		{name: "system", req: types.ListMessagesRequest{Channel: "system", Status: "all"}, wantSrc: []string{"snu", "global"}},
+		{name: "all", req: types.ListMessagesRequest{Channel: "all", Status: "all"}, wantSrc: []string{"dm", "snu", "global"}},
+		{name: "all sent", req: types.ListMessagesRequest{Channel: "all", Status: "sent"}, wantSrc: []string{"dm"}},
+		{name: "all trash", req: types.ListMessagesRequest{Channel: "all", Status: "trash"}, wantSrc: []string{"dm"}},
+		{name: "all starred skips globals", req: types.ListMessagesRequest{Channel: "all", Status: "starred"}, wantSrc: []string{"dm", "snu"}},
+		{name: "all by group skips globals", req: types.ListMessagesRequest{Channel: "all", Status: "all", GroupId: 4}, wantSrc: []string{"dm", "snu"}},
+	}
+
+	source := func(b inboxBranch) string {
+		switch b.key["id"] {
+		case "dm.id":
+			return "dm"
+		case "snu.id":
+			return "snu"
+		default:
+			return "global"
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branches := inboxBranches(&tt.req)
+			var got []string
+			for _, b := range branches {
+				got = append(got, source(b))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantSrc, ",") {
+				t.Fatalf("branches = %v, want %v", got, tt.wantSrc)
+			}
+		})
+	}
+}
+
+func TestMessageUIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  types.Message
+		uid  string
+		want types.MarkReadRequest
+	}{
+		{
+			name: "personal",
+			msg:  types.Message{Id: 12, Channel: "personal"},
+			uid:  "personal:12",
+			want: types.MarkReadRequest{Id: 12, Channel: "personal"},
+		},
+		{
+			name: "system receipt",
+			msg:  types.Message{Id: 12, NotificationId: 3, Channel: "system"},
+			uid:  "system:12",
+			want: types.MarkReadRequest{Id: 12, Channel: "system"},
+		},
+		{
+			name: "global without receipt",
+			msg:  types.Message{NotificationId: 3, Channel: "system"},
+			uid:  "global:3",
+			want: types.MarkReadRequest{Channel: "system", NotificationId: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageUID(tt.msg); got != tt.uid {
+				t.Fatalf("uid = %s, want %s", got, tt.uid)
+			}
+			req, err := parseMessageUID(tt.uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *req != tt.want {
+				t.Fatalf("parsed %+v, want %+v", *req, tt.want)
+			}
+		})
+	}
+
+	for _, uid := range []string{"", "personal", "personal:0", "personal:x", "inbox:1"} {
+		if _, err := parseMessageUID(uid); err == nil {
+			t.Fatalf("parseMessageUID(%q) succeeded", uid)
+		}
+	}
+}
+
+// TestQueryInboxMergesBranches pages through channel=all from a cursor: every
+// branch must carry the keyset condition over its own columns and the
+// look-ahead limit, and the merged page must hand out the next cursor.
+func TestQueryInboxMergesBranches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const userID, size = int64(7), int64(2)
+	cursor := &listCursor{CreatedAt: 1767225600, Source: sourcePersonal, Id: 40}
+	at := cursor.createdAt()
+	keyset := []driver.Value{at, at, sourcePersonal, sourcePersonal, int64(40), int64(40), int64(0)}
+
+	var args []driver.Value
+	args = append(args, userID, userID)
+	args = append(args, keyset...)
+	args = append(args, size+1)
+	args = append(args, userID, userID)
+	args = append(args, keyset...)
+	args = append(args, size+1)
+	args = append(args, userID)
+	args = append(args, keyset...)
+	args = append(args, size+1)
+	args = append(args, size+1, int64(0))
+
+	query := regexp.QuoteMeta("(dm.created_at < ? OR (dm.created_at = ?") + `.*` +
+		regexp.QuoteMeta("ORDER BY dm.created_at DESC, 2 DESC, dm.id DESC, 0 DESC LIMIT ?)") + `\s*UNION ALL\s*.*` +
+		regexp.QuoteMeta("(snu.created_at < ? OR (snu.created_at = ?") + `.*` +
+		regexp.QuoteMeta("ORDER BY snu.created_at DESC, 1 DESC, snu.id DESC, sn.id DESC LIMIT ?)") + `\s*UNION ALL\s*.*` +
+		regexp.QuoteMeta("(sn.created_at < ? OR (sn.created_at = ?") + `.*` +
+		regexp.QuoteMeta("ORDER BY sn.created_at DESC, 1 DESC, 0 DESC, sn.id DESC LIMIT ?)") + `.*` +
+		regexp.QuoteMeta(") inbox ORDER BY created_at DESC, src DESC, id DESC, notification_id DESC LIMIT ? OFFSET ?")
+
+	columns := []string{"src", "id", "notification_id", "sender_id", "receiver_id", "title", "content", "is_read",
+		"read_at", "created_at", "priority", "thread_id", "reply_to_id", "starred", "archived", "group_id", "read_until"}
+	t1 := at.Add(-time.Second)
+	t2 := at.Add(-2 * time.Second)
+	mock.ExpectQuery(query).WithArgs(args...).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(sourcePersonal, 39, 0, 2, userID, "hi", "a", false, nil, at, nil, 39, nil, false, false, nil, nil).
+		AddRow(sourceSystem, 0, 5, 1, userID, "notice", "b", false, nil, t1, "info", 0, nil, false, false, nil, t2).
+		AddRow(sourceSystem, 8, 4, 1, userID, "older", "c", true, t1, t2, "info", 0, nil, false, false, nil, nil))
+
+	l := NewListMessagesLogic(context.Background(), &svc.ServiceContext{DB: db})
+	req := &types.ListMessagesRequest{Channel: "all", Status: "all", Page: 1, Size: size, SkipTotal: true, UserId: userID}
+	page, err := l.queryInbox(req, cursor, inboxBranches(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if page.total != totalSkipped {
+		t.Fatalf("total = %d, want %d", page.total, totalSkipped)
+	}
+	var uids []string
+	for _, msg := range page.items {
+		uids = append(uids, msg.Uid)
+	}
+	if strings.Join(uids, ",") != "personal:39,global:5" {
+		t.Fatalf("uids = %v", uids)
+	}
+
+	next, err := decodeListCursor(page.nextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := listCursor{CreatedAt: t1.Unix(), Source: sourceSystem, NotificationId: 5}
+	if next == nil || *next != want {
+		t.Fatalf("next = %+v, want %+v", next, want)
+	}
+}
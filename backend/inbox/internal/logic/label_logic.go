@@ -0,0 +1,282 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const maxLabelNameLength = 64
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var errLabelNotFound = errors.New("label not found")
+
+type LabelLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewLabelLogic(ctx context.Context, svcCtx *svc.ServiceContext) *LabelLogic {
+	return &LabelLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// ListLabels returns the user's labels by name with the number of items
+// carrying each.
+func (l *LabelLogic) ListLabels(req *types.ListLabelsRequest) (*types.ListLabelsResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	rows, err := l.svcCtx.DB.QueryContext(
+		l.ctx,
+		`SELECT lb.id, lb.name, lb.color, lb.created_at, COUNT(li.label_id)
+FROM message_labels lb
+LEFT JOIN message_label_items li ON li.label_id = lb.id
+WHERE lb.user_id = ?
+GROUP BY lb.id
+ORDER BY lb.name`,
+		req.UserId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query labels: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]types.Label, 0)
+	for rows.Next() {
+		label, err := scanLabelRow(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, label)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate labels: %w", err)
+	}
+
+	return &types.ListLabelsResponse{Items: items}, nil
+}
+
+func (l *LabelLogic) CreateLabel(req *types.CreateLabelRequest) (*types.Label, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	name, err := normalizeLabelName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateLabelColor(req.Color); err != nil {
+		return nil, err
+	}
+
+	result, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO message_labels (user_id, name, color) VALUES (?, ?, ?)`,
+		req.UserId,
+		name,
+		sql.NullString{String: req.Color, Valid: req.Color != ""},
+	)
+	if err != nil {
+		return nil, translateLabelConflict(err, "create label")
+	}
+
+	labelID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("fetch label id: %w", err)
+	}
+
+	return l.fetchLabel(labelID, req.UserId)
+}
+
+// UpdateLabel renames or recolours a label; empty fields are left as they
+// are. Items keep the label since they reference it by id.
+func (l *LabelLogic) UpdateLabel(req *types.UpdateLabelRequest) (*types.Label, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	if _, err := l.fetchLabel(req.Id, req.UserId); err != nil {
+		return nil, err
+	}
+
+	var (
+		sets []string
+		args []interface{}
+	)
+	if req.Name != "" {
+		name, err := normalizeLabelName(req.Name)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "name = ?")
+		args = append(args, name)
+	}
+	if req.Color != "" {
+		if err := validateLabelColor(req.Color); err != nil {
+			return nil, err
+		}
+		sets = append(sets, "color = ?")
+		args = append(args, req.Color)
+	}
+
+	if len(sets) > 0 {
+		if _, err := l.svcCtx.DB.ExecContext(
+			l.ctx,
+			`UPDATE message_labels SET `+strings.Join(sets, ", ")+` WHERE id = ? AND user_id = ?`,
+			append(args, req.Id, req.UserId)...,
+		); err != nil {
+			return nil, translateLabelConflict(err, "update label")
+		}
+	}
+
+	return l.fetchLabel(req.Id, req.UserId)
+}
+
+// DeleteLabel removes a label; the items themselves are untouched.
+func (l *LabelLogic) DeleteLabel(req *types.DeleteLabelRequest) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	result, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`DELETE FROM message_labels WHERE id = ? AND user_id = ?`,
+		req.Id,
+		req.UserId,
+	)
+	if err != nil {
+		return fmt.Errorf("delete label: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleted label rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errLabelNotFound
+	}
+
+	return nil
+}
+
+// AddItemLabel applies one of the user's labels to an inbox item. Applying a
+// label twice is a no-op.
+func (l *LabelLogic) AddItemLabel(req *types.InboxItemLabelRequest) error {
+	return l.setItemLabel(req, true)
+}
+
+func (l *LabelLogic) RemoveItemLabel(req *types.InboxItemLabelRequest) error {
+	return l.setItemLabel(req, false)
+}
+
+func (l *LabelLogic) setItemLabel(req *types.InboxItemLabelRequest, on bool) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	if _, err := l.fetchLabel(req.LabelId, req.UserId); err != nil {
+		return err
+	}
+
+	itemType, itemID, err := resolveInboxItem(l.ctx, l.svcCtx, req.Uid, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT IGNORE INTO message_label_items (label_id, item_type, item_id) VALUES (?, ?, ?)`
+	if !on {
+		query = `DELETE FROM message_label_items WHERE label_id = ? AND item_type = ? AND item_id = ?`
+	}
+	if _, err = l.svcCtx.DB.ExecContext(l.ctx, query, req.LabelId, itemType, itemID); err != nil {
+		return fmt.Errorf("update message label: %w", err)
+	}
+
+	publishItemState(l.ctx, l.svcCtx, req.UserId, itemType, itemID)
+
+	return nil
+}
+
+// fetchLabel loads a label owned by the user; other users' labels are
+// reported as missing.
+func (l *LabelLogic) fetchLabel(labelID, userID int64) (*types.Label, error) {
+	label, err := scanLabelRow(l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT id, name, color, created_at FROM message_labels WHERE id = ? AND user_id = ?`,
+		labelID,
+		userID,
+	), false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errLabelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &label, nil
+}
+
+func scanLabelRow(scanner interface {
+	Scan(dest ...interface{}) error
+}, withCount bool) (types.Label, error) {
+	var (
+		label     types.Label
+		color     sql.NullString
+		createdAt sql.NullTime
+	)
+
+	dest := []interface{}{&label.Id, &label.Name, &color, &createdAt}
+	if withCount {
+		dest = append(dest, &label.Count)
+	}
+	if err := scanner.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Label{}, err
+		}
+		return types.Label{}, fmt.Errorf("scan label: %w", err)
+	}
+
+	label.Color = color.String
+	label.CreatedAt = formatNullTime(createdAt)
+
+	return label, nil
+}
+
+func normalizeLabelName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("label name is required")
+	}
+	if utf8.RuneCountInString(name) > maxLabelNameLength {
+		return "", fmt.Errorf("label name must be at most %d characters", maxLabelNameLength)
+	}
+	return name, nil
+}
+
+func validateLabelColor(color string) error {
+	if color != "" && !labelColorPattern.MatchString(color) {
+		return errors.New("label color must look like #rrggbb")
+	}
+	return nil
+}
+
+func translateLabelConflict(err error, action string) error {
+	if strings.Contains(err.Error(), "Duplicate entry") {
+		return errors.New("a label with this name already exists")
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
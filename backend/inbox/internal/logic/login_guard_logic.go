@@ -0,0 +1,236 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	loginSuccess   = "success"
+	loginFailure   = "failure"
+	loginThrottled = "throttled"
+	loginLocked    = "locked"
+	// loginChallenged is a correct password awaiting the second step.
+	loginChallenged = "challenged"
+
+	maxAuditUsernameLength  = 64
+	maxAuditIPLength        = 45
+	maxAuditUserAgentLength = 255
+	maxLoginAuditPageSize   = 100
+)
+
+// LoginBlockedError rejects a login attempt made before the account or the
+// client address may try again.
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+	// Locked is set when too many failures locked the account or address,
+	// rather than the attempt merely coming too soon after the last one.
+	Locked bool
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("登录失败次数过多，已临时锁定，请 %d 秒后再试", e.RetrySeconds())
+	}
+	return fmt.Sprintf("登录过于频繁，请 %d 秒后再试", e.RetrySeconds())
+}
+
+// RetrySeconds rounds the wait up, so a client retrying on time is let in.
+func (e *LoginBlockedError) RetrySeconds() int64 {
+	return int64((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// guardAccount runs verify, which checks a password or code given by a
+// signed-in user, under the login guard of their account. A wrong answer
+// counts like a failed login and returns wrong, so a stolen session cannot
+// guess its way past the guard; a right one settles the attempt without
+// clearing earlier failures.
+func guardAccount(ctx context.Context, svcCtx *svc.ServiceContext, username string, verify func() (bool, error), wrong error) error {
+	logger := logx.WithContext(ctx)
+
+	verdict, err := svcCtx.LoginGuard.Check(ctx, username, "")
+	if err != nil {
+		logger.Errorf("check login guard for %q: %v", username, err)
+		return fmt.Errorf("暂时无法验证，请稍后再试")
+	}
+	if verdict.RetryAfter > 0 {
+		return &LoginBlockedError{RetryAfter: verdict.RetryAfter, Locked: verdict.Locked}
+	}
+
+	ok, err := verify()
+	if err == nil && !ok {
+		if err = svcCtx.LoginGuard.Fail(ctx, username, ""); err != nil {
+			logger.Errorf("record failure of %q: %v", username, err)
+		}
+		return wrong
+	}
+
+	if releaseErr := svcCtx.LoginGuard.Release(ctx, username, ""); releaseErr != nil {
+		logger.Errorf("release attempt of %q: %v", username, releaseErr)
+	}
+	return err
+}
+
+// recordLoginAttempt appends to the login audit trail. The trail is for
+// review only, so a failed write is logged and the login goes on.
+func recordLoginAttempt(ctx context.Context, svcCtx *svc.ServiceContext, req *types.LoginRequest, userID int64, outcome string) {
+	user := sql.NullInt64{Int64: userID, Valid: userID > 0}
+	if _, err := svcCtx.DB.ExecContext(
+		ctx,
+		`INSERT INTO login_audit (user_id, username, ip, user_agent, outcome) VALUES (?, ?, ?, ?, ?)`,
+		user,
+		truncateRunes(strings.TrimSpace(req.Username), maxAuditUsernameLength),
+		truncateRunes(req.Ip, maxAuditIPLength),
+		truncateRunes(req.UserAgent, maxAuditUserAgentLength),
+		outcome,
+	); err != nil {
+		logx.WithContext(ctx).Errorf("record login %s for %q: %v", outcome, req.Username, err)
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// LoginGuardLogic lets admins review login attempts and lift lockouts.
+type LoginGuardLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewLoginGuardLogic(ctx context.Context, svcCtx *svc.ServiceContext) *LoginGuardLogic {
+	return &LoginGuardLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// LoginAudit lists login attempts, newest first.
+func (l *LoginGuardLogic) LoginAudit(req *types.LoginAuditRequest) (*types.LoginAuditResponse, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = 20
+	}
+	if req.Size > maxLoginAuditPageSize {
+		req.Size = maxLoginAuditPageSize
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if username := strings.TrimSpace(req.Username); username != "" {
+		conds = append(conds, "username = ?")
+		args = append(args, username)
+	}
+	if req.UserId > 0 {
+		conds = append(conds, "user_id = ?")
+		args = append(args, req.UserId)
+	}
+	if req.Ip != "" {
+		conds = append(conds, "ip = ?")
+		args = append(args, req.Ip)
+	}
+	if req.Outcome != "" {
+		conds = append(conds, "outcome = ?")
+		args = append(args, req.Outcome)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int64
+	if err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT COUNT(*) FROM login_audit`+where,
+		args...,
+	).Scan(&total); err != nil {
+		return nil, fmt.Errorf("count login audit: %w", err)
+	}
+
+	rows, err := l.svcCtx.DB.QueryContext(
+		l.ctx,
+		`SELECT id, user_id, username, ip, user_agent, outcome, created_at FROM login_audit`+where+`
+ORDER BY id DESC
+LIMIT ? OFFSET ?`,
+		append(args, req.Size, (req.Page-1)*req.Size)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query login audit: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]types.LoginAuditEntry, 0, req.Size)
+	for rows.Next() {
+		var (
+			entry     types.LoginAuditEntry
+			userID    sql.NullInt64
+			createdAt time.Time
+		)
+		if err := rows.Scan(
+			&entry.Id,
+			&userID,
+			&entry.Username,
+			&entry.Ip,
+			&entry.UserAgent,
+			&entry.Outcome,
+			&createdAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan login audit: %w", err)
+		}
+		entry.UserId = userID.Int64
+		entry.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		items = append(items, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate login audit: %w", err)
+	}
+
+	return &types.LoginAuditResponse{
+		Items: items,
+		Total: total,
+		Page:  req.Page,
+		Size:  req.Size,
+	}, nil
+}
+
+// UnlockUser clears the failed attempts of a user and, if given, of the
+// address they were locked out from.
+func (l *LoginGuardLogic) UnlockUser(req *types.UnlockUserRequest) error {
+	var username string
+	err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT username FROM users WHERE id = ?`,
+		req.Id,
+	).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("用户不存在")
+		}
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if err = l.svcCtx.LoginGuard.Unlock(l.ctx, username, strings.TrimSpace(req.Ip)); err != nil {
+		return fmt.Errorf("解除锁定失败: %w", err)
+	}
+
+	return nil
+}
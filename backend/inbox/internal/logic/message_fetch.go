@@ -8,81 +8,69 @@ import (
 	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
 )
 
-func fetchPersonalMessage(ctx context.Context, db *sql.DB, id int64) (*types.Message, error) {
-	var (
-		msg       types.Message
-		title     sql.NullString
-		readAt    sql.NullTime
-		createdAt time.Time
-	)
+// fetchPersonalMessage loads a personal message as the user sees it. Anyone
+// who neither sent nor received it gets sql.ErrNoRows.
+func fetchPersonalMessage(ctx context.Context, db *sql.DB, id, userID int64) (*types.Message, error) {
+	query := `SELECT ` + personalColumns + ` FROM ` + personalFrom + `
+WHERE dm.id = ? AND (dm.sender_id = ? OR r.id IS NOT NULL)`
 
-	query := `
-SELECT id, sender_id, receiver_id, title, content, is_read, read_at, created_at
-FROM direct_messages WHERE id = ?`
-
-	err := db.QueryRowContext(ctx, query, id).Scan(
-		&msg.Id,
-		&msg.SenderId,
-		&msg.ReceiverId,
-		&title,
-		&msg.Content,
-		&msg.IsRead,
-		&readAt,
-		&createdAt,
-	)
+	msg, err := scanPersonalRow(db.QueryRowContext(ctx, query, userID, id, userID))
 	if err != nil {
 		return nil, err
 	}
 
-	msg.Title = title.String
-	msg.ReadAt = formatNullTime(readAt)
-	msg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	msg.Channel = "personal"
+	items := []types.Message{msg}
+	if err = attachRecipients(ctx, db, userID, items); err != nil {
+		return nil, err
+	}
 
-	return &msg, nil
+	return &items[0], nil
 }
 
-func fetchSystemMessage(ctx context.Context, db *sql.DB, receiptID int64) (*types.Message, error) {
-	var (
-		msg       types.Message
-		readAt    sql.NullTime
-		createdAt time.Time
-	)
+// Which copy of a personal message a party may open: the one in their
+// mailbox, or the one they moved to their trash. Each takes the user id.
+const (
+	partyMailbox = "((r.id IS NOT NULL AND r.deleted_at IS NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NULL))"
+	partyTrash   = "((r.id IS NOT NULL AND r.deleted_at IS NOT NULL) OR (dm.sender_id = ? AND dm.sender_deleted_at IS NOT NULL))"
+)
+
+// fetchPartyMessage is fetchPersonalMessage limited to the mailbox or, when
+// trashed is set, the trash of the user. A message the user deleted is not
+// in their mailbox even though they remain a party to it.
+func fetchPartyMessage(ctx context.Context, db *sql.DB, id, userID int64, trashed bool) (*types.Message, error) {
+	party := partyMailbox
+	if trashed {
+		party = partyTrash
+	}
+
+	query := `SELECT ` + personalColumns + ` FROM ` + personalFrom + `
+WHERE dm.id = ? AND ` + party
+
+	msg, err := scanPersonalRow(db.QueryRowContext(ctx, query, userID, id, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	items := []types.Message{msg}
+	if err = attachRecipients(ctx, db, userID, items); err != nil {
+		return nil, err
+	}
+
+	return &items[0], nil
+}
 
+func fetchSystemMessage(ctx context.Context, db *sql.DB, receiptID int64) (*types.Message, error) {
 	query := `
-SELECT
-	snu.id,
-	sn.created_by,
-	snu.user_id,
-	sn.title,
-	sn.content,
-	snu.is_read,
-	snu.read_at,
-	snu.created_at,
-	sn.priority
+SELECT ` + systemColumns + `
 FROM system_notification_receipts snu
 JOIN system_notifications sn ON snu.notification_id = sn.id
 WHERE snu.id = ?`
 
-	err := db.QueryRowContext(ctx, query, receiptID).Scan(
-		&msg.Id,
-		&msg.SenderId,
-		&msg.ReceiverId,
-		&msg.Title,
-		&msg.Content,
-		&msg.IsRead,
-		&readAt,
-		&createdAt,
-		&msg.Priority,
-	)
+	msg, err := scanSystemRow(db.QueryRowContext(ctx, query, receiptID))
 	if err != nil {
 		return nil, err
 	}
 
-	msg.ReadAt = formatNullTime(readAt)
-	msg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	msg.Channel = "system"
-
 	return &msg, nil
 }
 
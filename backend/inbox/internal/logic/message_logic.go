@@ -8,13 +8,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
 	"github.com/zeromicro/go-zero/core/logx"
 )
 
+// ForbiddenError rejects a request the caller's role does not allow.
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
 type SendMessageLogic struct {
 	logx.Logger
 	ctx    context.Context
@@ -35,28 +46,129 @@ func (l *SendMessageLogic) SendMessage(req *types.SendMessageRequest) (*types.Me
 		channel = "personal"
 	}
 
+	if err := l.checkSendPolicy(req, channel); err != nil {
+		return nil, err
+	}
+
+	audience := req.Audience
+	if audience == "" {
+		audience = audienceSingle
+	}
+
+	if err := resolveReceiverUsernames(l.ctx, l.svcCtx.DB, req); err != nil {
+		return nil, err
+	}
+
+	if audience != audienceSingle {
+		if channel != "system" {
+			return nil, fmt.Errorf("audience %s is only supported on the system channel", audience)
+		}
+		req.Audience = audience
+		if audience == audienceGlobal {
+			return l.publishGlobalNotification(req)
+		}
+		return l.broadcastSystemNotification(req)
+	}
+
+	if channel != "personal" {
+		if req.ReceiverId <= 0 {
+			return nil, errors.New("receiverId or receiverUsername is required")
+		}
+		if len(req.CcIds) > 0 || len(req.BccIds) > 0 {
+			return nil, errors.New("ccIds and bccIds are only supported on the personal channel")
+		}
+		if len(req.Targets) > 0 {
+			return nil, errors.New("system notifications reach a group through audience=segment with segment=group:<id>")
+		}
+	}
+
 	switch channel {
 	case "personal":
 		return l.sendPersonalMessage(req)
 	case "system":
+		if err := ensureUsersExist(l.ctx, l.svcCtx.DB, []int64{req.ReceiverId}); err != nil {
+			return nil, err
+		}
 		return l.sendSystemNotification(req)
 	default:
 		return nil, fmt.Errorf("unsupported channel: %s", channel)
 	}
 }
 
+// checkSendPolicy lets only admins post on the system channel or send on
+// behalf of another user. SendPolicyMiddleware enforces this on the send
+// route; the check here also covers requests built in the logic, such as
+// replies.
+func (l *SendMessageLogic) checkSendPolicy(req *types.SendMessageRequest, channel string) error {
+	if authctx.IsAdmin(l.ctx) {
+		return nil
+	}
+
+	if channel == "system" {
+		return &ForbiddenError{Message: "仅管理员可以发送系统通知"}
+	}
+
+	userID, ok := authctx.UserIDFromCtx(l.ctx)
+	if !ok || (req.SenderId != 0 && req.SenderId != userID) {
+		return &ForbiddenError{Message: "不能以其他用户身份发送信息"}
+	}
+
+	return nil
+}
+
 func (l *SendMessageLogic) sendPersonalMessage(req *types.SendMessageRequest) (*types.Message, error) {
 	if req.SenderId <= 0 {
 		return nil, errors.New("senderId is required for personal channel")
 	}
 
-	result, err := l.svcCtx.DB.ExecContext(
+	var (
+		threadID  int64
+		replyToID sql.NullInt64
+	)
+	// A reply may leave its receivers to be derived from the parent.
+	if req.ReplyToId > 0 {
+		parent, err := l.resolveReplyParent(req)
+		if err != nil {
+			return nil, err
+		}
+		threadID = parent.ThreadId
+		replyToID = sql.NullInt64{Int64: parent.Id, Valid: true}
+	}
+
+	recipients, err := personalRecipients(l.ctx, l.svcCtx.DB, req)
+	if err != nil {
+		return nil, err
+	}
+
+	receiverIDs := make([]int64, len(recipients))
+	for i, ref := range recipients {
+		receiverIDs[i] = ref.userID
+	}
+	if err = ensureUsersExist(l.ctx, l.svcCtx.DB, receiverIDs); err != nil {
+		return nil, err
+	}
+
+	tx, err := l.svcCtx.DB.BeginTx(l.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(
 		l.ctx,
-		`INSERT INTO direct_messages (sender_id, receiver_id, title, content) VALUES (?, ?, ?, ?)`,
+		`INSERT INTO direct_messages (sender_id, receiver_id, title, content, thread_id, reply_to_id) VALUES (?, ?, ?, ?, ?, ?)`,
 		req.SenderId,
-		req.ReceiverId,
+		recipients[0].userID,
 		req.Title,
 		req.Content,
+		threadID,
+		replyToID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("insert personal message: %w", err)
@@ -67,7 +179,42 @@ func (l *SendMessageLogic) sendPersonalMessage(req *types.SendMessageRequest) (*
 		return nil, fmt.Errorf("fetch personal message id: %w", err)
 	}
 
-	return fetchPersonalMessage(l.ctx, l.svcCtx.DB, messageID)
+	// A message that does not reply to anything starts its own thread.
+	if threadID == 0 {
+		if _, err = tx.ExecContext(
+			l.ctx,
+			`UPDATE direct_messages SET thread_id = id WHERE id = ?`,
+			messageID,
+		); err != nil {
+			return nil, fmt.Errorf("start personal thread: %w", err)
+		}
+	}
+
+	if err = insertRecipients(l.ctx, tx, messageID, recipients); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit personal message: %w", err)
+	}
+	committed = true
+
+	indexPersonalMessages(l.ctx, l.svcCtx, messageID)
+
+	// One message, but every recipient gets their own view of it.
+	msg, err := fetchPersonalMessage(l.ctx, l.svcCtx.DB, messageID, req.SenderId)
+	if err != nil {
+		return nil, err
+	}
+	if err = attachProfile(l.ctx, l.svcCtx, msg); err != nil {
+		return nil, err
+	}
+
+	for _, ref := range recipients {
+		notifyMessageCreated(l.ctx, l.svcCtx, ref.userID, recipientView(msg, ref.userID))
+	}
+
+	return msg, nil
 }
 
 func (l *SendMessageLogic) sendSystemNotification(req *types.SendMessageRequest) (*types.Message, error) {
@@ -90,7 +237,7 @@ func (l *SendMessageLogic) sendSystemNotification(req *types.SendMessageRequest)
 
 	res, err := tx.ExecContext(
 		l.ctx,
-		`INSERT INTO system_notifications (title, content, priority, created_by) VALUES (?, ?, ?, ?)`,
+		`INSERT INTO system_notifications (title, content, priority, created_by, target_total, delivered_count) VALUES (?, ?, ?, ?, 1, 1)`,
 		req.Title,
 		req.Content,
 		req.Priority,
@@ -125,10 +272,13 @@ func (l *SendMessageLogic) sendSystemNotification(req *types.SendMessageRequest)
 	}
 	committed = true
 
+	indexNotifications(l.ctx, l.svcCtx, notificationID)
+
 	msg, err := fetchSystemMessage(l.ctx, l.svcCtx.DB, receiptID)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
-		return &types.Message{
+		msg, err = &types.Message{
 			Id:         receiptID,
+			Uid:        "system:" + strconv.FormatInt(receiptID, 10),
 			SenderId:   createdBy,
 			ReceiverId: req.ReceiverId,
 			Title:      req.Title,
@@ -139,8 +289,16 @@ func (l *SendMessageLogic) sendSystemNotification(req *types.SendMessageRequest)
 			Priority:   req.Priority,
 		}, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	if err = attachProfile(l.ctx, l.svcCtx, msg); err != nil {
+		return nil, err
+	}
 
-	return msg, err
+	notifyMessageCreated(l.ctx, l.svcCtx, req.ReceiverId, msg)
+
+	return msg, nil
 }
 
 type ListMessagesLogic struct {
@@ -169,134 +327,50 @@ func (l *ListMessagesLogic) ListMessages(req *types.ListMessagesRequest) (*types
 		return nil, fmt.Errorf("缺少用户信息")
 	}
 
-	var (
-		items []types.Message
-		total int64
-		err   error
-	)
-
-	switch req.Channel {
-	case "system":
-		items, total, err = l.listSystemMessages(req)
-	default:
-		items, total, err = l.listPersonalMessages(req)
-	}
-
+	cursor, err := decodeListCursor(req.Cursor)
 	if err != nil {
 		return nil, err
 	}
 
-	return &types.ListMessagesResponse{
-		Items: items,
-		Total: total,
-		Page:  req.Page,
-		Size:  req.Size,
-	}, nil
-}
-
-func (l *ListMessagesLogic) listPersonalMessages(req *types.ListMessagesRequest) ([]types.Message, int64, error) {
-	where := "receiver_id = ?"
-	args := []interface{}{req.UserId}
-
-	if req.Status == "sent" {
-		where = "sender_id = ?"
-		args[0] = req.UserId
-	}
-
-	if req.Status == "unread" {
-		where += " AND is_read = 0"
+	page, err := l.queryInbox(req, cursor, inboxBranches(req))
+	if err != nil {
+		return nil, err
 	}
 
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM direct_messages WHERE %s", where)
-	var total int64
-	if err := l.svcCtx.DB.QueryRowContext(l.ctx, countQuery, args...).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("count personal messages: %w", err)
+	if err = attachLabels(l.ctx, l.svcCtx.DB, req.UserId, page.items); err != nil {
+		return nil, err
 	}
-
-	query := fmt.Sprintf(`
-SELECT id, sender_id, receiver_id, title, content, is_read, read_at, created_at
-FROM direct_messages
-WHERE %s
-ORDER BY created_at DESC
-LIMIT ? OFFSET ?`, where)
-
-	argsWithPaging := append([]interface{}{}, args...)
-	offset := (req.Page - 1) * req.Size
-	argsWithPaging = append(argsWithPaging, req.Size, offset)
-
-	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, argsWithPaging...)
-	if err != nil {
-		return nil, 0, fmt.Errorf("list personal messages: %w", err)
+	if err = attachRecipients(l.ctx, l.svcCtx.DB, req.UserId, page.items); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
-
-	var items []types.Message
-	for rows.Next() {
-		msg, err := scanPersonalRow(rows)
-		if err != nil {
-			return nil, 0, err
-		}
-		items = append(items, msg)
+	if err = attachProfiles(l.ctx, l.svcCtx, page.items); err != nil {
+		return nil, err
 	}
 
-	return items, total, nil
+	return &types.ListMessagesResponse{
+		Items:      page.items,
+		Total:      page.total,
+		Page:       req.Page,
+		Size:       req.Size,
+		NextCursor: page.nextCursor,
+	}, nil
 }
 
-func (l *ListMessagesLogic) listSystemMessages(req *types.ListMessagesRequest) ([]types.Message, int64, error) {
-	where := "snu.user_id = ?"
-	args := []interface{}{req.UserId}
-
-	if req.Status == "unread" {
-		where += " AND snu.is_read = 0"
-	}
-
-	countQuery := fmt.Sprintf(`
-SELECT COUNT(*) FROM system_notification_receipts snu
-WHERE %s`, where)
-
-	var total int64
-	if err := l.svcCtx.DB.QueryRowContext(l.ctx, countQuery, args...).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("count system notifications: %w", err)
-	}
-
-	query := fmt.Sprintf(`
-SELECT
-	snu.id,
-	sn.created_by,
-	snu.user_id,
-	sn.title,
-	sn.content,
-	snu.is_read,
-	snu.read_at,
-	snu.created_at,
-	sn.priority
-FROM system_notification_receipts snu
-JOIN system_notifications sn ON snu.notification_id = sn.id
-WHERE %s
-ORDER BY snu.created_at DESC
-LIMIT ? OFFSET ?`, where)
-
-	argsWithPaging := append([]interface{}{}, args...)
-	offset := (req.Page - 1) * req.Size
-	argsWithPaging = append(argsWithPaging, req.Size, offset)
-
-	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, argsWithPaging...)
-	if err != nil {
-		return nil, 0, fmt.Errorf("list system notifications: %w", err)
-	}
-	defer rows.Close()
+// personalFrom reads personal messages as dm, with the viewer's recipient
+// row, if any, as r. It takes the viewer's user id.
+const personalFrom = "direct_messages dm\nLEFT JOIN direct_message_recipients r ON r.message_id = dm.id AND r.user_id = ?"
 
-	var items []types.Message
-	for rows.Next() {
-		msg, err := scanSystemRow(rows)
-		if err != nil {
-			return nil, 0, err
-		}
-		items = append(items, msg)
-	}
+// A recipient sees their own read state. The sender sees a message as read
+// once every recipient has read it, as of the last of them.
+const (
+	personalIsRead = "IF(r.id IS NULL, NOT EXISTS (SELECT 1 FROM direct_message_recipients rr WHERE rr.message_id = dm.id AND rr.is_read = 0), r.is_read)"
+	personalReadAt = "IF(r.id IS NULL, (SELECT IF(MIN(rr.is_read) = 1, MAX(rr.read_at), NULL) FROM direct_message_recipients rr WHERE rr.message_id = dm.id), r.read_at)"
+)
 
-	return items, total, nil
-}
+// personalColumns is the column list scanPersonalRow expects, over
+// personalFrom.
+const personalColumns = "dm.id, dm.sender_id, dm.receiver_id, dm.title, dm.content, " + personalIsRead + ", " +
+	personalReadAt + ", dm.created_at, dm.thread_id, dm.reply_to_id, r.group_id"
 
 func scanPersonalRow(scanner interface {
 	Scan(dest ...interface{}) error
@@ -306,6 +380,8 @@ func scanPersonalRow(scanner interface {
 		title     sql.NullString
 		readAt    sql.NullTime
 		createdAt time.Time
+		replyToID sql.NullInt64
+		groupID   sql.NullInt64
 	)
 
 	err := scanner.Scan(
@@ -317,19 +393,29 @@ func scanPersonalRow(scanner interface {
 		&msg.IsRead,
 		&readAt,
 		&createdAt,
+		&msg.ThreadId,
+		&replyToID,
+		&groupID,
 	)
 	if err != nil {
 		return types.Message{}, fmt.Errorf("scan personal message: %w", err)
 	}
 
 	msg.Title = title.String
+	msg.ReplyToId = replyToID.Int64
+	msg.GroupId = groupID.Int64
 	msg.ReadAt = formatNullTime(readAt)
 	msg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
 	msg.Channel = "personal"
+	msg.Uid = messageUID(msg)
 
 	return msg, nil
 }
 
+// systemColumns is the column list scanSystemRow expects, over
+// system_notification_receipts snu joined with system_notifications sn.
+const systemColumns = "snu.id, sn.id, sn.created_by, snu.user_id, sn.title, sn.content, snu.is_read, snu.read_at, snu.created_at, sn.priority, snu.group_id"
+
 func scanSystemRow(scanner interface {
 	Scan(dest ...interface{}) error
 }) (types.Message, error) {
@@ -337,10 +423,12 @@ func scanSystemRow(scanner interface {
 		msg       types.Message
 		readAt    sql.NullTime
 		createdAt time.Time
+		groupID   sql.NullInt64
 	)
 
 	err := scanner.Scan(
 		&msg.Id,
+		&msg.NotificationId,
 		&msg.SenderId,
 		&msg.ReceiverId,
 		&msg.Title,
@@ -349,14 +437,17 @@ func scanSystemRow(scanner interface {
 		&readAt,
 		&createdAt,
 		&msg.Priority,
+		&groupID,
 	)
 	if err != nil {
 		return types.Message{}, fmt.Errorf("scan system message: %w", err)
 	}
 
+	msg.GroupId = groupID.Int64
 	msg.ReadAt = formatNullTime(readAt)
 	msg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
 	msg.Channel = "system"
+	msg.Uid = messageUID(msg)
 
 	return msg, nil
 }
@@ -376,6 +467,38 @@ func NewMarkMessageReadLogic(ctx context.Context, svcCtx *svc.ServiceContext) *M
 }
 
 func (l *MarkMessageReadLogic) MarkMessageRead(req *types.MarkReadRequest) (*types.Message, error) {
+	return l.setReadState(req, true)
+}
+
+// MarkMessageUnread reverts a message to unread, for mail the user wants to
+// come back to.
+func (l *MarkMessageReadLogic) MarkMessageUnread(req *types.MarkReadRequest) (*types.Message, error) {
+	return l.setReadState(req, false)
+}
+
+// MarkInboxItemRead marks a listing item read by its composite uid, routing
+// to the table the uid points at.
+func (l *MarkMessageReadLogic) MarkInboxItemRead(req *types.MarkInboxItemReadRequest) (*types.Message, error) {
+	markReq, err := parseMessageUID(req.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	markReq.UserId = req.UserId
+	return l.MarkMessageRead(markReq)
+}
+
+func (l *MarkMessageReadLogic) MarkInboxItemUnread(req *types.MarkInboxItemReadRequest) (*types.Message, error) {
+	markReq, err := parseMessageUID(req.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	markReq.UserId = req.UserId
+	return l.MarkMessageUnread(markReq)
+}
+
+func (l *MarkMessageReadLogic) setReadState(req *types.MarkReadRequest, read bool) (*types.Message, error) {
 	if req.UserId <= 0 {
 		return nil, fmt.Errorf("缺少用户信息")
 	}
@@ -387,14 +510,19 @@ func (l *MarkMessageReadLogic) MarkMessageRead(req *types.MarkReadRequest) (*typ
 
 	switch req.Channel {
 	case "system":
-		err = l.markSystemReceipt(req.Id, req.UserId)
+		receiptID := req.Id
+		if req.NotificationId > 0 {
+			receiptID, err = l.materializeGlobalReceipt(req.NotificationId, req.UserId, read)
+		} else {
+			err = l.markSystemReceipt(req.Id, req.UserId, read)
+		}
 		if err == nil {
-			msg, err = fetchSystemMessage(l.ctx, l.svcCtx.DB, req.Id)
+			msg, err = fetchSystemMessage(l.ctx, l.svcCtx.DB, receiptID)
 		}
 	default:
-		err = l.markPersonalReceipt(req.Id, req.UserId)
+		err = l.markPersonalReceipt(req.Id, req.UserId, read)
 		if err == nil {
-			msg, err = fetchPersonalMessage(l.ctx, l.svcCtx.DB, req.Id)
+			msg, err = fetchPersonalMessage(l.ctx, l.svcCtx.DB, req.Id, req.UserId)
 		}
 	}
 
@@ -402,14 +530,30 @@ func (l *MarkMessageReadLogic) MarkMessageRead(req *types.MarkReadRequest) (*typ
 		return nil, translateNotFound(err)
 	}
 
+	if msg.Channel == "personal" && msg.SenderId != req.UserId {
+		notifyRecipientsChanged(l.ctx, l.svcCtx, msg.SenderId, msg.Id)
+	}
+
+	if read {
+		notifyMessageRead(l.ctx, l.svcCtx, req.UserId, msg)
+	} else {
+		notifyMessageUnread(l.ctx, l.svcCtx, req.UserId, msg)
+	}
+
 	return msg, nil
 }
 
-func (l *MarkMessageReadLogic) markPersonalReceipt(messageID, userID int64) error {
+// readAt is the read_at value matching a read state.
+func readAt(read bool) sql.NullTime {
+	return sql.NullTime{Time: time.Now(), Valid: read}
+}
+
+func (l *MarkMessageReadLogic) markPersonalReceipt(messageID, userID int64, read bool) error {
 	result, err := l.svcCtx.DB.ExecContext(
 		l.ctx,
-		`UPDATE direct_messages SET is_read = 1, read_at = ? WHERE id = ? AND receiver_id = ?`,
-		time.Now(),
+		`UPDATE direct_message_recipients SET is_read = ?, read_at = ? WHERE message_id = ? AND user_id = ?`,
+		read,
+		readAt(read),
 		messageID,
 		userID,
 	)
@@ -422,18 +566,21 @@ func (l *MarkMessageReadLogic) markPersonalReceipt(messageID, userID int64) erro
 		return fmt.Errorf("personal rows affected: %w", err)
 	}
 
+	// Marking an unread message unread changes nothing, so no rows are
+	// affected even though the message exists.
 	if affected == 0 {
-		return sql.ErrNoRows
+		return l.rowExists(`SELECT 1 FROM direct_message_recipients WHERE message_id = ? AND user_id = ?`, messageID, userID)
 	}
 
 	return nil
 }
 
-func (l *MarkMessageReadLogic) markSystemReceipt(receiptID, userID int64) error {
+func (l *MarkMessageReadLogic) markSystemReceipt(receiptID, userID int64, read bool) error {
 	result, err := l.svcCtx.DB.ExecContext(
 		l.ctx,
-		`UPDATE system_notification_receipts SET is_read = 1, read_at = ? WHERE id = ? AND user_id = ?`,
-		time.Now(),
+		`UPDATE system_notification_receipts SET is_read = ?, read_at = ? WHERE id = ? AND user_id = ?`,
+		read,
+		readAt(read),
 		receiptID,
 		userID,
 	)
@@ -447,7 +594,20 @@ func (l *MarkMessageReadLogic) markSystemReceipt(receiptID, userID int64) error
 	}
 
 	if affected == 0 {
-		return sql.ErrNoRows
+		return l.rowExists(`SELECT 1 FROM system_notification_receipts WHERE id = ? AND user_id = ?`, receiptID, userID)
+	}
+
+	return nil
+}
+
+// rowExists returns sql.ErrNoRows when the query finds nothing.
+func (l *MarkMessageReadLogic) rowExists(query string, args ...interface{}) error {
+	var one int
+	if err := l.svcCtx.DB.QueryRowContext(l.ctx, query, args...).Scan(&one); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return fmt.Errorf("check message owner: %w", err)
 	}
 
 	return nil
@@ -479,7 +639,10 @@ func (l *UnreadCountLogic) UnreadCount(req *types.UnreadCountRequest) (*types.Un
 
 	if err := l.svcCtx.DB.QueryRowContext(
 		l.ctx,
-		`SELECT COUNT(*) FROM direct_messages WHERE receiver_id = ? AND is_read = 0`,
+		`SELECT COUNT(*) FROM direct_message_recipients r
+`+flagsJoin("personal", "r.message_id")+`
+WHERE r.user_id = ? AND r.is_read = 0 AND r.deleted_at IS NULL AND f.archived_at IS NULL`,
+		req.UserId,
 		req.UserId,
 	).Scan(&personal); err != nil {
 		return nil, fmt.Errorf("personal unread count: %w", err)
@@ -487,12 +650,21 @@ func (l *UnreadCountLogic) UnreadCount(req *types.UnreadCountRequest) (*types.Un
 
 	if err := l.svcCtx.DB.QueryRowContext(
 		l.ctx,
-		`SELECT COUNT(*) FROM system_notification_receipts WHERE user_id = ? AND is_read = 0`,
+		`SELECT COUNT(*) FROM system_notification_receipts snu
+`+flagsJoin("system", "snu.id")+`
+WHERE snu.user_id = ? AND snu.is_read = 0 AND f.archived_at IS NULL`,
+		req.UserId,
 		req.UserId,
 	).Scan(&system); err != nil {
 		return nil, fmt.Errorf("system unread count: %w", err)
 	}
 
+	global, err := countUnreadGlobal(l.ctx, l.svcCtx.DB, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	system += global
+
 	return &types.UnreadCountResponse{
 		Personal: personal,
 		System:   system,
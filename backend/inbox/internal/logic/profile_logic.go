@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// attachProfiles fills in who sent and received each message, so clients can
+// show names and avatars without looking users up one by one. System
+// notifications are presented by the configured system identity rather than
+// the admin who sent them.
+func attachProfiles(ctx context.Context, svcCtx *svc.ServiceContext, items []types.Message) error {
+	var ids []int64
+	for i := range items {
+		if items[i].Channel == "personal" {
+			ids = append(ids, items[i].SenderId)
+		}
+		ids = append(ids, items[i].ReceiverId)
+		for _, rec := range items[i].Recipients {
+			ids = append(ids, rec.UserId)
+		}
+	}
+
+	profiles, err := loadUserSummaries(ctx, svcCtx.DB, uniqueSortedIDs(ids))
+	if err != nil {
+		return err
+	}
+
+	system := systemSender(svcCtx)
+	for i := range items {
+		if items[i].Channel == "personal" {
+			items[i].Sender = profiles[items[i].SenderId]
+		} else {
+			items[i].Sender = system
+		}
+		items[i].Receiver = profiles[items[i].ReceiverId]
+		for j := range items[i].Recipients {
+			items[i].Recipients[j].User = profiles[items[i].Recipients[j].UserId]
+		}
+	}
+
+	return nil
+}
+
+// attachProfile is attachProfiles for a single message.
+func attachProfile(ctx context.Context, svcCtx *svc.ServiceContext, msg *types.Message) error {
+	items := []types.Message{*msg}
+	if err := attachProfiles(ctx, svcCtx, items); err != nil {
+		return err
+	}
+
+	*msg = items[0]
+	return nil
+}
+
+func loadUserSummaries(ctx context.Context, db *sql.DB, ids []int64) (map[int64]*types.UserSummary, error) {
+	profiles := make(map[int64]*types.UserSummary, len(ids))
+
+	for start := 0; start < len(ids); start += maxBatchIds {
+		end := start + maxBatchIds
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		placeholders, args := inList(ids[start:end])
+		rows, err := db.QueryContext(
+			ctx,
+			`SELECT id, username, display_name, avatar_url FROM users WHERE id IN (`+placeholders+`)`,
+			args...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("load user profiles: %w", err)
+		}
+
+		for rows.Next() {
+			profile, err := scanUserSummary(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			profiles[profile.Id] = profile
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("iterate user profiles: %w", err)
+		}
+	}
+
+	return profiles, nil
+}
+
+// scanUserSummary reads id, username, display_name and avatar_url. Users
+// without a display name are shown by their username.
+func scanUserSummary(scanner interface {
+	Scan(dest ...interface{}) error
+}) (*types.UserSummary, error) {
+	var profile types.UserSummary
+	if err := scanner.Scan(&profile.Id, &profile.Username, &profile.DisplayName, &profile.AvatarUrl); err != nil {
+		return nil, fmt.Errorf("scan user profile: %w", err)
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = profile.Username
+	}
+
+	return &profile, nil
+}
+
+func systemSender(svcCtx *svc.ServiceContext) *types.UserSummary {
+	identity := svcCtx.Config.SystemSender
+	sender := &types.UserSummary{
+		Username:    identity.Username,
+		DisplayName: identity.DisplayName,
+		AvatarUrl:   identity.AvatarUrl,
+	}
+	if sender.Username == "" {
+		sender.Username = "system"
+	}
+	if sender.DisplayName == "" {
+		sender.DisplayName = "System"
+	}
+
+	return sender
+}
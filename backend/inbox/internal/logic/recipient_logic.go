@@ -0,0 +1,226 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// Recipient kinds of a personal message. Blind copies are hidden from the
+// other recipients.
+const (
+	recipientTo  = "to"
+	recipientCc  = "cc"
+	recipientBcc = "bcc"
+)
+
+// maxRecipients caps how many users a single personal message addresses,
+// after groups are expanded.
+const maxRecipients = 500
+
+type recipientRef struct {
+	userID int64
+	kind   string
+	// groupID is the group the recipient was reached through, or 0.
+	groupID int64
+}
+
+// personalRecipients merges receiverId, receiverIds, user targets, the
+// members of group targets, ccIds and bccIds in that order. A user listed
+// twice keeps the first entry, so users named directly are not attributed
+// to a group and nobody is both a direct recipient and a blind copy. The
+// sender is not sent a copy through a group they belong to.
+func personalRecipients(ctx context.Context, db *sql.DB, req *types.SendMessageRequest) ([]recipientRef, error) {
+	var (
+		refs []recipientRef
+		seen = make(map[int64]bool)
+	)
+	add := func(kind string, groupID int64, ids []int64) error {
+		for _, id := range ids {
+			if id <= 0 {
+				return fmt.Errorf("invalid recipient id: %d", id)
+			}
+			if seen[id] || (groupID > 0 && id == req.SenderId) {
+				continue
+			}
+			seen[id] = true
+			refs = append(refs, recipientRef{userID: id, kind: kind, groupID: groupID})
+		}
+		return nil
+	}
+
+	to := req.ReceiverIds
+	if req.ReceiverId > 0 {
+		to = append([]int64{req.ReceiverId}, to...)
+	}
+	var groups []int64
+	for _, target := range req.Targets {
+		if groupID, ok := parseGroupRef(target); ok {
+			groups = append(groups, groupID)
+			continue
+		}
+		kind, rawID, _ := strings.Cut(target, ":")
+		id, err := strconv.ParseInt(rawID, 10, 64)
+		if kind != "user" || err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid target: %q", target)
+		}
+		to = append(to, id)
+	}
+
+	if err := add(recipientTo, 0, to); err != nil {
+		return nil, err
+	}
+	for _, groupID := range groups {
+		if _, err := fetchGroup(ctx, db, groupID); err != nil {
+			return nil, err
+		}
+		members, err := groupMembers(ctx, db, groupID)
+		if err != nil {
+			return nil, err
+		}
+		if err = add(recipientTo, groupID, members); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(recipientCc, 0, req.CcIds); err != nil {
+		return nil, err
+	}
+	if err := add(recipientBcc, 0, req.BccIds); err != nil {
+		return nil, err
+	}
+
+	if len(refs) == 0 || refs[0].kind != recipientTo {
+		return nil, errors.New("receiverId, receiverIds, receiverUsername or targets is required")
+	}
+	if len(refs) > maxRecipients {
+		return nil, fmt.Errorf("at most %d recipients per message", maxRecipients)
+	}
+
+	return refs, nil
+}
+
+func insertRecipients(ctx context.Context, tx *sql.Tx, messageID int64, refs []recipientRef) error {
+	args := make([]interface{}, 0, len(refs)*4)
+	for _, ref := range refs {
+		args = append(args, messageID, ref.userID, ref.kind, sql.NullInt64{Int64: ref.groupID, Valid: ref.groupID > 0})
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO direct_message_recipients (message_id, user_id, kind, group_id) VALUES `+
+			strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(refs)), ", "),
+		args...,
+	); err != nil {
+		return fmt.Errorf("insert message recipients: %w", err)
+	}
+
+	return nil
+}
+
+// attachRecipients fills in who each personal message went to, as seen by
+// the user: the sender gets every recipient with their read state, while a
+// recipient gets the to and cc lists and only their own read state.
+func attachRecipients(ctx context.Context, db *sql.DB, userID int64, items []types.Message) error {
+	positions := make(map[int64][]int)
+	var ids []int64
+	for i := range items {
+		if items[i].Channel != "personal" {
+			continue
+		}
+		if _, ok := positions[items[i].Id]; !ok {
+			ids = append(ids, items[i].Id)
+		}
+		positions[items[i].Id] = append(positions[items[i].Id], i)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders, args := inList(ids)
+	rows, err := db.QueryContext(ctx, `SELECT message_id, user_id, kind, group_id, is_read, read_at
+FROM direct_message_recipients WHERE message_id IN (`+placeholders+`) ORDER BY id`, args...)
+	if err != nil {
+		return fmt.Errorf("load message recipients: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := make(map[int64][]types.MessageRecipient, len(ids))
+	for rows.Next() {
+		var (
+			messageID int64
+			rec       types.MessageRecipient
+			groupID   sql.NullInt64
+			readAt    sql.NullTime
+		)
+		if err := rows.Scan(&messageID, &rec.UserId, &rec.Kind, &groupID, &rec.IsRead, &readAt); err != nil {
+			return fmt.Errorf("scan message recipient: %w", err)
+		}
+		rec.GroupId = groupID.Int64
+		rec.ReadAt = formatNullTime(readAt)
+		recipients[messageID] = append(recipients[messageID], rec)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate message recipients: %w", err)
+	}
+
+	for id, indexes := range positions {
+		for _, i := range indexes {
+			items[i].Recipients = visibleRecipients(items[i].SenderId, userID, recipients[id])
+		}
+	}
+
+	return nil
+}
+
+// visibleRecipients is the part of a recipient list the viewer may see.
+func visibleRecipients(senderID, viewerID int64, all []types.MessageRecipient) []types.MessageRecipient {
+	if senderID == viewerID {
+		return all
+	}
+
+	visible := make([]types.MessageRecipient, 0, len(all))
+	for _, rec := range all {
+		if rec.UserId != viewerID {
+			if rec.Kind == recipientBcc {
+				continue
+			}
+			rec.IsRead, rec.ReadAt = false, ""
+		}
+		visible = append(visible, rec)
+	}
+	return visible
+}
+
+// recipientView turns the sender's copy of a new message into what the
+// given recipient sees.
+func recipientView(msg *types.Message, userID int64) *types.Message {
+	view := *msg
+	view.IsRead, view.ReadAt = false, ""
+	view.Recipients = visibleRecipients(msg.SenderId, userID, msg.Recipients)
+	for _, rec := range view.Recipients {
+		if rec.UserId == userID {
+			view.GroupId = rec.GroupId
+		}
+	}
+	return &view
+}
+
+// receivedBy reports whether the user is a receiver of the message rather
+// than only its sender.
+func receivedBy(msg *types.Message, userID int64) bool {
+	if msg.Channel != "personal" {
+		return msg.ReceiverId == userID
+	}
+
+	for _, rec := range msg.Recipients {
+		if rec.UserId == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/mailer"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Purposes of the one-time tokens in user_tokens.
+const (
+	tokenVerifyEmail   = "verify_email"
+	tokenResetPassword = "reset_password"
+)
+
+const (
+	maxEmailLength = 254
+	// resetMailInterval is the least time between two reset mails to the
+	// same account, so the endpoint cannot be used to flood an inbox.
+	resetMailInterval = time.Minute
+	mailSendTimeout   = 30 * time.Second
+)
+
+var errInvalidAccountToken = errors.New("链接无效或已过期")
+
+// RecoveryLogic handles the links mailed to users: confirming an address and
+// resetting a forgotten password. None of it requires a login.
+type RecoveryLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewRecoveryLogic(ctx context.Context, svcCtx *svc.ServiceContext) *RecoveryLogic {
+	return &RecoveryLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified, provided the user has not changed it since.
+func (l *RecoveryLogic) VerifyEmail(req *types.VerifyEmailRequest) error {
+	return l.withToken(tokenVerifyEmail, req.Token, func(tx *sql.Tx, userID int64, email string) error {
+		res, err := tx.ExecContext(
+			l.ctx,
+			`UPDATE users SET email_verified_at = ? WHERE id = ? AND email = ?`,
+			time.Now(),
+			userID,
+			email,
+		)
+		if err != nil {
+			return fmt.Errorf("验证邮箱失败: %w", err)
+		}
+		return requireAffected(res, errInvalidAccountToken)
+	})
+}
+
+// RequestPasswordReset mails a reset link to the account with this verified
+// address. It reports success whether or not such an account exists, so the
+// endpoint does not reveal who is registered.
+func (l *RecoveryLogic) RequestPasswordReset(req *types.PasswordResetRequest) error {
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return err
+	}
+
+	var (
+		userID int64
+		recent bool
+	)
+	err = l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT u.id, EXISTS(
+	SELECT 1 FROM user_tokens t
+	WHERE t.user_id = u.id AND t.purpose = ? AND t.created_at > ?
+)
+FROM users u
+WHERE u.email = ? AND u.email_verified_at IS NOT NULL`,
+		tokenResetPassword,
+		time.Now().Add(-resetMailInterval),
+		email,
+	).Scan(&userID, &recent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+	if recent {
+		return nil
+	}
+
+	ttl := time.Duration(l.svcCtx.Config.Mail.ResetExpire) * time.Second
+	token, err := issueAccountToken(l.ctx, l.svcCtx.DB, userID, tokenResetPassword, email, ttl)
+	if err != nil {
+		return err
+	}
+
+	sendAccountMail(l.svcCtx, mailer.Message{
+		To:      email,
+		Subject: "重置密码",
+		Body: fmt.Sprintf(
+			"我们收到了重置你的站内信账号密码的请求。请在 %d 分钟内打开下面的链接设置新密码：\n\n%s\n\n如果这不是你本人的操作，请忽略本邮件，你的密码不会改变。\n",
+			int(ttl/time.Minute),
+			accountLink(l.svcCtx, "/reset-password", token),
+		),
+	})
+
+	return nil
+}
+
+// ConfirmPasswordReset sets a new password with a reset token. The token and
+// every other outstanding reset token of the user are used up, and all of
+// the user's sessions are revoked.
+func (l *RecoveryLogic) ConfirmPasswordReset(req *types.ConfirmPasswordResetRequest) error {
+	if err := validatePassword(req.NewPassword); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("生成密码哈希失败: %w", err)
+	}
+
+	var resetUser int64
+	err = l.withToken(tokenResetPassword, req.Token, func(tx *sql.Tx, userID int64, email string) error {
+		// The link is only good while the address it went to is still the
+		// account's verified address.
+		res, err := tx.ExecContext(
+			l.ctx,
+			`UPDATE users SET password_hash = ? WHERE id = ? AND email = ? AND email_verified_at IS NOT NULL`,
+			string(hash),
+			userID,
+			email,
+		)
+		if err != nil {
+			return fmt.Errorf("更新密码失败: %w", err)
+		}
+		if err = requireAffected(res, errInvalidAccountToken); err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(
+			l.ctx,
+			`UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`,
+			time.Now(),
+			userID,
+			tokenResetPassword,
+		); err != nil {
+			return fmt.Errorf("作废重置链接失败: %w", err)
+		}
+
+		resetUser = userID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.svcCtx.Sessions.RevokeUser(l.ctx, resetUser)
+}
+
+// withToken uses up an unexpired token of the given purpose and runs fn in
+// the same transaction, so a failed fn leaves the token usable.
+func (l *RecoveryLogic) withToken(purpose, token string, fn func(tx *sql.Tx, userID int64, email string) error) error {
+	if token == "" {
+		return errInvalidAccountToken
+	}
+	hash := session.HashToken(token)
+
+	tx, err := l.svcCtx.DB.BeginTx(l.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(
+		l.ctx,
+		`UPDATE user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		time.Now(),
+		hash,
+		purpose,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("use token: %w", err)
+	}
+	if err = requireAffected(res, errInvalidAccountToken); err != nil {
+		return err
+	}
+
+	var (
+		userID int64
+		email  string
+	)
+	if err = tx.QueryRowContext(
+		l.ctx,
+		`SELECT user_id, email FROM user_tokens WHERE token_hash = ?`,
+		hash,
+	).Scan(&userID, &email); err != nil {
+		return fmt.Errorf("load token: %w", err)
+	}
+
+	if err = fn(tx, userID, email); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit token: %w", err)
+	}
+	committed = true
+
+	return nil
+}
+
+// sendEmailVerification mails the user a link confirming they own email.
+func sendEmailVerification(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, email string) error {
+	ttl := time.Duration(svcCtx.Config.Mail.VerifyExpire) * time.Second
+	token, err := issueAccountToken(ctx, svcCtx.DB, userID, tokenVerifyEmail, email, ttl)
+	if err != nil {
+		return err
+	}
+
+	sendAccountMail(svcCtx, mailer.Message{
+		To:      email,
+		Subject: "验证你的邮箱",
+		Body: fmt.Sprintf(
+			"请在 %d 小时内打开下面的链接，确认这是你的站内信账号使用的邮箱：\n\n%s\n\n验证后即可通过该邮箱找回密码。如果你没有使用过站内信，请忽略本邮件。\n",
+			int(ttl/time.Hour),
+			accountLink(svcCtx, "/verify-email", token),
+		),
+	})
+
+	return nil
+}
+
+// issueAccountToken stores the hash of a new one-time token and returns the
+// token itself, which only ever appears in the mail.
+func issueAccountToken(ctx context.Context, db *sql.DB, userID int64, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := session.RandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = db.ExecContext(
+		ctx,
+		`INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		userID,
+		purpose,
+		session.HashToken(token),
+		email,
+		time.Now().Add(ttl),
+	); err != nil {
+		return "", fmt.Errorf("issue %s token: %w", purpose, err)
+	}
+
+	return token, nil
+}
+
+// sendAccountMail sends in the background: SMTP may be slow, and how long a
+// request takes must not tell whether a mail was sent.
+func sendAccountMail(svcCtx *svc.ServiceContext, msg mailer.Message) {
+	threading.GoSafe(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+
+		if err := svcCtx.Mailer.Send(ctx, msg); err != nil {
+			logx.WithContext(ctx).Errorf("send mail %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	})
+}
+
+func accountLink(svcCtx *svc.ServiceContext, path, token string) string {
+	return strings.TrimSuffix(svcCtx.Config.Mail.LinkBase, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > maxEmailLength {
+		return "", fmt.Errorf("无效的邮箱地址")
+	}
+	return email, nil
+}
+
+func requireAffected(res sql.Result, errNone error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errNone
+	}
+	return nil
+}
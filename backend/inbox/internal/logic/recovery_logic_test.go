@@ -0,0 +1,121 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/loginguard"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// nowArg matches a time within a second of the test's clock.
+type nowArg struct{}
+
+func (nowArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Since(t).Abs() < time.Second
+}
+
+func TestWithToken(t *testing.T) {
+	const token = "tok"
+	useToken := regexp.QuoteMeta("UPDATE user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?")
+	loadToken := regexp.QuoteMeta("SELECT user_id, email FROM user_tokens WHERE token_hash = ?")
+	errFn := errors.New("fn failed")
+
+	tests := []struct {
+		name     string
+		token    string
+		affected int64
+		fnErr    error
+		wantErr  error
+		wantCall bool
+	}{
+		{name: "empty token", token: "", wantErr: errInvalidAccountToken},
+		{name: "first use", token: token, affected: 1, wantCall: true},
+		// An expired, already used or unknown token matches no row; each is
+		// rejected the same way and fn never runs.
+		{name: "second use or expired", token: token, affected: 0, wantErr: errInvalidAccountToken},
+		{name: "failing fn keeps the token", token: token, affected: 1, fnErr: errFn, wantErr: errFn, wantCall: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if tt.token != "" {
+				mock.ExpectBegin()
+				mock.ExpectExec(useToken).
+					WithArgs(nowArg{}, session.HashToken(tt.token), tokenVerifyEmail, nowArg{}).
+					WillReturnResult(sqlmock.NewResult(0, tt.affected))
+				if tt.affected > 0 {
+					mock.ExpectQuery(loadToken).
+						WithArgs(session.HashToken(tt.token)).
+						WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}).AddRow(7, "a@example.com"))
+				}
+				if tt.wantErr == nil {
+					mock.ExpectCommit()
+				} else {
+					mock.ExpectRollback()
+				}
+			}
+
+			called := false
+			l := NewRecoveryLogic(context.Background(), &svc.ServiceContext{DB: db})
+			err = l.withToken(tokenVerifyEmail, tt.token, func(_ *sql.Tx, userID int64, email string) error {
+				called = true
+				if userID != 7 || email != "a@example.com" {
+					t.Errorf("token of user %d for %s", userID, email)
+				}
+				return tt.fnErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if called != tt.wantCall {
+				t.Fatalf("fn called = %v, want %v", called, tt.wantCall)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestChangeEmailRequiresPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectPassword(t, mock)
+
+	l := NewAccountLogic(context.Background(), &svc.ServiceContext{
+		DB:         db,
+		LoginGuard: loginguard.New(loginguard.NewMemoryStore(time.Hour), loginguard.Policy{}, loginguard.Policy{}),
+	})
+	_, err = l.ChangeEmail(&types.ChangeEmailRequest{
+		Email:           "mallory@example.com",
+		CurrentPassword: "wrong password",
+		UserId:          7,
+	})
+	if err == nil || err.Error() != "密码错误" {
+		t.Fatalf("err = %v", err)
+	}
+	// Nothing else runs: the address is not changed and no mail is sent.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
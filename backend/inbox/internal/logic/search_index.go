@@ -0,0 +1,265 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/searchindex"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// indexPersonalMessages brings the search index in line with the rows of
+// the given personal messages. Like event delivery it is best effort: a
+// failure is logged and repaired by the next rebuild.
+func indexPersonalMessages(ctx context.Context, svcCtx *svc.ServiceContext, ids ...int64) {
+	if svcCtx.Search == nil || len(ids) == 0 {
+		return
+	}
+
+	if _, err := syncPersonalDocuments(ctx, svcCtx.DB, svcCtx.Search, ids); err != nil {
+		logx.WithContext(ctx).Errorf("index personal messages %v: %v", ids, err)
+	}
+}
+
+func indexNotifications(ctx context.Context, svcCtx *svc.ServiceContext, ids ...int64) {
+	if svcCtx.Search == nil || len(ids) == 0 {
+		return
+	}
+
+	if _, err := syncNotificationDocuments(ctx, svcCtx.DB, svcCtx.Search, ids); err != nil {
+		logx.WithContext(ctx).Errorf("index notifications %v: %v", ids, err)
+	}
+}
+
+// syncPersonalDocuments indexes each message for the parties that have not
+// deleted it, and drops messages that no party can see any more or that no
+// longer exist. It returns the number of documents written.
+func syncPersonalDocuments(ctx context.Context, db *sql.DB, index searchindex.SearchIndex, ids []int64) (int, error) {
+	recipients, err := visibleRecipientIDs(ctx, db, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	placeholders, args := inList(ids)
+	rows, err := db.QueryContext(ctx, `SELECT id, sender_id, title, content, sender_deleted_at IS NULL, created_at
+FROM direct_messages WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("load personal messages: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		docs    []searchindex.Document
+		visible = make(map[int64]bool, len(ids))
+	)
+	for rows.Next() {
+		var (
+			doc           searchindex.Document
+			title         sql.NullString
+			senderVisible bool
+		)
+		if err := rows.Scan(&doc.ID, &doc.SenderID, &title, &doc.Content, &senderVisible, &doc.CreatedAt); err != nil {
+			return 0, fmt.Errorf("scan personal message: %w", err)
+		}
+
+		if senderVisible {
+			doc.Parties = append(doc.Parties, doc.SenderID)
+		}
+		for _, userID := range recipients[doc.ID] {
+			if userID != doc.SenderID || !senderVisible {
+				doc.Parties = append(doc.Parties, userID)
+			}
+		}
+		if len(doc.Parties) == 0 {
+			continue
+		}
+
+		doc.Kind = searchindex.KindPersonal
+		doc.Title = title.String
+		docs = append(docs, doc)
+		visible[doc.ID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate personal messages: %w", err)
+	}
+
+	var stale []int64
+	for _, id := range ids {
+		if !visible[id] {
+			stale = append(stale, id)
+		}
+	}
+
+	if err := index.Index(ctx, docs...); err != nil {
+		return 0, err
+	}
+	if err := index.Delete(ctx, searchindex.KindPersonal, stale...); err != nil {
+		return 0, err
+	}
+
+	return len(docs), nil
+}
+
+// visibleRecipientIDs lists, per message, the recipients who have not
+// deleted it.
+func visibleRecipientIDs(ctx context.Context, db *sql.DB, ids []int64) (map[int64][]int64, error) {
+	placeholders, args := inList(ids)
+	rows, err := db.QueryContext(ctx, `SELECT message_id, user_id FROM direct_message_recipients
+WHERE deleted_at IS NULL AND message_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load message recipients: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := make(map[int64][]int64, len(ids))
+	for rows.Next() {
+		var messageID, userID int64
+		if err := rows.Scan(&messageID, &userID); err != nil {
+			return nil, fmt.Errorf("scan message recipient: %w", err)
+		}
+		recipients[messageID] = append(recipients[messageID], userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate message recipients: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// syncNotificationDocuments indexes notifications once each, with the users
+// holding a receipt so the index can tell who may see them. Global
+// notifications are seen by everyone and need no list.
+func syncNotificationDocuments(ctx context.Context, db *sql.DB, index searchindex.SearchIndex, ids []int64) (int, error) {
+	holders, err := receiptHolderIDs(ctx, db, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	placeholders, args := inList(ids)
+	rows, err := db.QueryContext(ctx, `SELECT id, title, content, created_by, audience, priority, created_at
+FROM system_notifications WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("load notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []searchindex.Document
+	for rows.Next() {
+		doc := searchindex.Document{Kind: searchindex.KindNotification}
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &doc.SenderID, &doc.Audience, &doc.Priority,
+			&doc.CreatedAt); err != nil {
+			return 0, fmt.Errorf("scan notification: %w", err)
+		}
+		doc.Parties = holders[doc.ID]
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate notifications: %w", err)
+	}
+
+	if err := index.Index(ctx, docs...); err != nil {
+		return 0, err
+	}
+
+	return len(docs), nil
+}
+
+// receiptHolderIDs lists, per notification that is not global, the users
+// holding a receipt for it.
+func receiptHolderIDs(ctx context.Context, db *sql.DB, ids []int64) (map[int64][]int64, error) {
+	placeholders, args := inList(ids)
+	rows, err := db.QueryContext(ctx, `SELECT snu.notification_id, snu.user_id
+FROM system_notification_receipts snu
+JOIN system_notifications sn ON snu.notification_id = sn.id
+WHERE sn.audience <> 'global' AND snu.notification_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load notification receipts: %w", err)
+	}
+	defer rows.Close()
+
+	holders := make(map[int64][]int64, len(ids))
+	for rows.Next() {
+		var notificationID, userID int64
+		if err := rows.Scan(&notificationID, &userID); err != nil {
+			return nil, fmt.Errorf("scan notification receipt: %w", err)
+		}
+		holders[notificationID] = append(holders[notificationID], userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate notification receipts: %w", err)
+	}
+
+	return holders, nil
+}
+
+// RebuildSearchIndex reindexes every personal message and notification in
+// id order, batchSize rows at a time, and returns the number of documents
+// written. It is meant for an empty index, so the server should be stopped
+// while it runs.
+func RebuildSearchIndex(ctx context.Context, svcCtx *svc.ServiceContext, batchSize int) (int, error) {
+	if svcCtx.Search == nil {
+		return 0, errors.New("no search index is configured")
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	sources := []struct {
+		table string
+		sync  func(context.Context, *sql.DB, searchindex.SearchIndex, []int64) (int, error)
+	}{
+		{"direct_messages", syncPersonalDocuments},
+		{"system_notifications", syncNotificationDocuments},
+	}
+
+	var total int
+	for _, source := range sources {
+		start := time.Now()
+		var lastID int64
+		for {
+			ids, err := nextIDBatch(ctx, svcCtx.DB, source.table, lastID, batchSize)
+			if err != nil {
+				return total, err
+			}
+			if len(ids) == 0 {
+				break
+			}
+
+			n, err := source.sync(ctx, svcCtx.DB, svcCtx.Search, ids)
+			if err != nil {
+				return total, err
+			}
+			total += n
+			lastID = ids[len(ids)-1]
+		}
+		logx.Infof("reindexed %s in %s", source.table, time.Since(start))
+	}
+
+	return total, nil
+}
+
+func nextIDBatch(ctx context.Context, db *sql.DB, table string, afterID int64, limit int) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM `+table+` WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list %s ids: %w", table, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan %s id: %w", table, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s ids: %w", table, err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,562 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/searchindex"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	maxSearchQueryLength = 100
+	maxSearchTerms       = 8
+	maxSearchPageSize    = 100
+	// searchSnippetRunes is how much content is returned around the first hit.
+	searchSnippetRunes = 80
+)
+
+// searchSource is an inbox branch together with the FULLTEXT index that
+// covers it and the column filters apply to.
+type searchSource struct {
+	branch inboxBranch
+	match  string
+	sender string
+}
+
+type SearchMessagesLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewSearchMessagesLogic(ctx context.Context, svcCtx *svc.ServiceContext) *SearchMessagesLogic {
+	return &SearchMessagesLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// SearchMessages finds the user's messages, sent or received, and system
+// notifications whose title or content contain every term of q. The tables
+// carry ngram FULLTEXT indexes, so CJK text needs no word boundaries; with a
+// search index configured it is used instead. Hits are ordered by relevance,
+// then by time; trashed messages are left out.
+func (l *SearchMessagesLogic) SearchMessages(req *types.SearchMessagesRequest) (*types.SearchMessagesResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = 20
+	}
+	if req.Size > maxSearchPageSize {
+		req.Size = maxSearchPageSize
+	}
+
+	terms, err := parseSearchTerms(req.Q)
+	if err != nil {
+		return nil, err
+	}
+
+	since, err := parseSearchTime("since", req.Since)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseSearchTime("until", req.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.svcCtx.Search != nil {
+		return l.searchIndex(req, terms, since, until)
+	}
+
+	against := booleanSearchQuery(terms)
+	sources := searchSources(req)
+	for i := range sources {
+		b := &sources[i].branch
+		b.where += " AND " + sources[i].match + " AGAINST (? IN BOOLEAN MODE)"
+		b.args = append(b.args, against)
+
+		if req.SenderId > 0 {
+			b.where += " AND " + sources[i].sender + " = ?"
+			b.args = append(b.args, req.SenderId)
+		}
+		if since.Valid {
+			b.where += " AND " + b.key["created_at"] + " >= ?"
+			b.args = append(b.args, since.Time)
+		}
+		if until.Valid {
+			b.where += " AND " + b.key["created_at"] + " < ?"
+			b.args = append(b.args, until.Time)
+		}
+		if req.Priority != "" {
+			b.where += " AND sn.priority = ?"
+			b.args = append(b.args, req.Priority)
+		}
+	}
+
+	resp := &types.SearchMessagesResponse{
+		Items: make([]types.SearchHit, 0),
+		Page:  req.Page,
+		Size:  req.Size,
+	}
+	if len(sources) == 0 {
+		return resp, nil
+	}
+
+	if resp.Total, err = l.count(sources); err != nil {
+		return nil, err
+	}
+	if resp.Total == 0 {
+		return resp, nil
+	}
+
+	hits, err := l.query(sources, against, req)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]types.Message, len(hits))
+	for i := range hits {
+		messages[i] = hits[i].Message
+	}
+	if err = attachLabels(l.ctx, l.svcCtx.DB, req.UserId, messages); err != nil {
+		return nil, err
+	}
+	if err = attachRecipients(l.ctx, l.svcCtx.DB, req.UserId, messages); err != nil {
+		return nil, err
+	}
+	if err = attachProfiles(l.ctx, l.svcCtx, messages); err != nil {
+		return nil, err
+	}
+
+	for i := range hits {
+		hits[i].Message = messages[i]
+		hits[i].Title = highlightTerms(hits[i].Message.Title, terms, 0)
+		hits[i].Snippet = highlightTerms(hits[i].Message.Content, terms, searchSnippetRunes)
+	}
+	resp.Items = hits
+
+	return resp, nil
+}
+
+// searchIndex asks the search index for a page of the user's hits and loads
+// them from the mailbox, in the index's order. The index filters by who may
+// see each item before ranking and paging; a hit the tables no longer show
+// the user, because the index lags behind, is dropped from the page.
+func (l *SearchMessagesLogic) searchIndex(req *types.SearchMessagesRequest, terms []string,
+	since, until sql.NullTime) (*types.SearchMessagesResponse, error) {
+	resp := &types.SearchMessagesResponse{
+		Items: make([]types.SearchHit, 0),
+		Page:  req.Page,
+		Size:  req.Size,
+	}
+
+	var kinds []string
+	if (req.Channel == "all" || req.Channel == "personal") && req.Priority == "" {
+		kinds = append(kinds, searchindex.KindPersonal)
+	}
+	if req.Channel == "all" || req.Channel == "system" {
+		kinds = append(kinds, searchindex.KindNotification)
+	}
+	if len(kinds) == 0 {
+		return resp, nil
+	}
+
+	res, err := l.svcCtx.Search.Search(l.ctx, searchindex.Query{
+		Text:     strings.Join(terms, " "),
+		UserID:   req.UserId,
+		Kinds:    kinds,
+		SenderID: req.SenderId,
+		Since:    since.Time,
+		Until:    until.Time,
+		Priority: req.Priority,
+		From:     int((req.Page - 1) * req.Size),
+		Size:     int(req.Size),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var personalIDs, notificationIDs []int64
+	for _, hit := range res.Hits {
+		if hit.Kind == searchindex.KindPersonal {
+			personalIDs = append(personalIDs, hit.ID)
+		} else {
+			notificationIDs = append(notificationIDs, hit.ID)
+		}
+	}
+
+	found := make(map[string]types.Message, len(res.Hits))
+	lookups := []struct {
+		branch inboxBranch
+		column string
+		kind   string
+		ids    []int64
+	}{
+		{personalBranch(req.UserId, "any", ""), "dm.id", searchindex.KindPersonal, personalIDs},
+		{receiptBranch(req.UserId, "any", ""), "sn.id", searchindex.KindNotification, notificationIDs},
+		{globalBranch(req.UserId, ""), "sn.id", searchindex.KindNotification, notificationIDs},
+	}
+	for _, lookup := range lookups {
+		if len(lookup.ids) == 0 {
+			continue
+		}
+		items, err := l.loadItems(lookup.branch, lookup.column, lookup.ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range items {
+			id := msg.Id
+			if lookup.kind == searchindex.KindNotification {
+				id = msg.NotificationId
+			}
+			found[searchindex.DocumentID(lookup.kind, id)] = msg
+		}
+	}
+
+	var hits []types.SearchHit
+	for _, hit := range res.Hits {
+		msg, ok := found[searchindex.DocumentID(hit.Kind, hit.ID)]
+		if !ok {
+			continue
+		}
+		hits = append(hits, types.SearchHit{
+			Message: msg,
+			Score:   hit.Score,
+			Title:   highlightSpans(msg.Title, hit.Matches["title"], 0),
+			Snippet: highlightSpans(msg.Content, hit.Matches["content"], searchSnippetRunes),
+		})
+	}
+
+	resp.Total = int64(res.Total)
+	if len(hits) == 0 {
+		return resp, nil
+	}
+
+	messages := make([]types.Message, len(hits))
+	for i := range hits {
+		messages[i] = hits[i].Message
+	}
+	if err = attachLabels(l.ctx, l.svcCtx.DB, req.UserId, messages); err != nil {
+		return nil, err
+	}
+	if err = attachRecipients(l.ctx, l.svcCtx.DB, req.UserId, messages); err != nil {
+		return nil, err
+	}
+	if err = attachProfiles(l.ctx, l.svcCtx, messages); err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Message = messages[i]
+	}
+	resp.Items = hits
+
+	return resp, nil
+}
+
+// loadItems reads the rows of a branch whose column is one of ids.
+func (l *SearchMessagesLogic) loadItems(b inboxBranch, column string, ids []int64) ([]types.Message, error) {
+	placeholders, args := inList(ids)
+	query := fmt.Sprintf("SELECT %s\n%s\nWHERE %s AND %s IN (%s)", b.columns, b.from, b.where, column, placeholders)
+
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, append(b.args, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("load search hits: %w", err)
+	}
+	defer rows.Close()
+
+	var items []types.Message
+	for rows.Next() {
+		msg, err := scanInboxRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load search hits: %w", err)
+	}
+
+	return items, nil
+}
+
+// searchSources picks the branches to search. Priority only exists on system
+// notifications, so filtering by it leaves personal messages out.
+func searchSources(req *types.SearchMessagesRequest) []searchSource {
+	const systemMatch = "MATCH(sn.title, sn.content)"
+
+	var sources []searchSource
+	if (req.Channel == "all" || req.Channel == "personal") && req.Priority == "" {
+		sources = append(sources, searchSource{
+			branch: personalBranch(req.UserId, "any", ""),
+			match:  "MATCH(dm.title, dm.content)",
+			sender: "dm.sender_id",
+		})
+	}
+	if req.Channel == "all" || req.Channel == "system" {
+		sources = append(sources,
+			searchSource{
+				branch: receiptBranch(req.UserId, "any", ""),
+				match:  systemMatch,
+				sender: "sn.created_by",
+			},
+			searchSource{
+				branch: globalBranch(req.UserId, ""),
+				match:  systemMatch,
+				sender: "sn.created_by",
+			},
+		)
+	}
+
+	return sources
+}
+
+func (l *SearchMessagesLogic) count(sources []searchSource) (int64, error) {
+	counts := make([]string, len(sources))
+	var args []interface{}
+	for i, s := range sources {
+		counts[i] = fmt.Sprintf("(SELECT COUNT(*) %s WHERE %s)", s.branch.from, s.branch.where)
+		args = append(args, s.branch.args...)
+	}
+
+	var total int64
+	if err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		"SELECT "+strings.Join(counts, " + "),
+		args...,
+	).Scan(&total); err != nil {
+		return 0, fmt.Errorf("count search results: %w", err)
+	}
+
+	return total, nil
+}
+
+// query merges the branches like queryInbox does, with the relevance score
+// as the leading sort key. Scores of different indexes are not strictly
+// comparable, but both come from the same ranking over the same terms.
+func (l *SearchMessagesLogic) query(sources []searchSource, against string, req *types.SearchMessagesRequest) ([]types.SearchHit, error) {
+	offset := (req.Page - 1) * req.Size
+	branchLimit := offset + req.Size
+
+	selects := make([]string, len(sources))
+	var args []interface{}
+	for i, s := range sources {
+		b := s.branch
+		selects[i] = fmt.Sprintf("(SELECT %s, %s AGAINST (? IN BOOLEAN MODE) AS score\n%s\nWHERE %s\nORDER BY score DESC, %s DESC\nLIMIT ?)",
+			b.columns, s.match, b.from, b.where, b.key["created_at"])
+		args = append(args, against)
+		args = append(args, b.args...)
+		args = append(args, branchLimit)
+	}
+
+	query := fmt.Sprintf(`
+SELECT * FROM (
+%s
+) hits
+ORDER BY score DESC, created_at DESC, src DESC, id DESC, notification_id DESC
+LIMIT ? OFFSET ?`, strings.Join(selects, "\nUNION ALL\n"))
+	args = append(args, req.Size, offset)
+
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []types.SearchHit
+	for rows.Next() {
+		var hit types.SearchHit
+		if hit.Message, err = scanInboxRow(scoredRow{rows: rows, score: &hit.Score}); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+
+	return hits, nil
+}
+
+// scoredRow lets scanInboxRow read a search row, whose score follows the
+// inbox columns.
+type scoredRow struct {
+	rows  *sql.Rows
+	score *float64
+}
+
+func (r scoredRow) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append(dest, r.score)...)
+}
+
+// parseSearchTerms splits q on whitespace. Double quotes would end the
+// phrases booleanSearchQuery builds, so they are removed.
+func parseSearchTerms(q string) ([]string, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, errors.New("q is required")
+	}
+	if utf8.RuneCountInString(q) > maxSearchQueryLength {
+		return nil, fmt.Errorf("q must be at most %d characters", maxSearchQueryLength)
+	}
+
+	var terms []string
+	for _, field := range strings.Fields(strings.ReplaceAll(q, `"`, "")) {
+		terms = append(terms, field)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	if len(terms) == 0 {
+		return nil, errors.New("q has no searchable terms")
+	}
+
+	return terms, nil
+}
+
+// booleanSearchQuery requires every term as a phrase. With the ngram parser
+// a phrase matches its n-grams in order, which is what a CJK user typing a
+// word expects; a bare term would match any of its n-grams.
+func booleanSearchQuery(terms []string) string {
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		phrases[i] = `+"` + term + `"`
+	}
+	return strings.Join(phrases, " ")
+}
+
+func parseSearchTime(name, value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+	}
+
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// highlightTerms HTML-escapes text and wraps every case-insensitive
+// occurrence of a term in <em>. With a positive window only that many runes
+// are kept, starting a little before the first hit.
+func highlightTerms(text string, terms []string, window int) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	marked := make([]bool, len(runes))
+	for _, term := range terms {
+		needle := []rune(strings.Map(unicode.ToLower, term))
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if !runesEqual(lower[i:i+len(needle)], needle) {
+				continue
+			}
+			for j := i; j < i+len(needle); j++ {
+				marked[j] = true
+			}
+		}
+	}
+
+	return renderHighlight(runes, marked, window)
+}
+
+// highlightSpans is highlightTerms for byte ranges reported by a search
+// index, which may cover fuzzy matches the terms themselves would miss.
+func highlightSpans(text string, spans [][2]int, window int) string {
+	runes := []rune(text)
+	marked := make([]bool, len(runes))
+
+	r := 0
+	for offset := range text {
+		for _, span := range spans {
+			if offset >= span[0] && offset < span[1] {
+				marked[r] = true
+				break
+			}
+		}
+		r++
+	}
+
+	return renderHighlight(runes, marked, window)
+}
+
+func renderHighlight(runes []rune, marked []bool, window int) string {
+	first := -1
+	for i, m := range marked {
+		if m {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, len(runes)
+	if window > 0 && len(runes) > window {
+		if first > 0 {
+			start = first - window/4
+			if start < 0 {
+				start = 0
+			}
+		}
+		end = start + window
+		if end > len(runes) {
+			end = len(runes)
+			start = end - window
+		}
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			sb.WriteString("<em>")
+			sb.WriteString(html.EscapeString(string(runes[i:j])))
+			sb.WriteString("</em>")
+		} else {
+			sb.WriteString(html.EscapeString(string(runes[i:j])))
+		}
+		i = j
+	}
+	if end < len(runes) {
+		sb.WriteString("…")
+	}
+
+	return sb.String()
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type RefreshTokenLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewRefreshTokenLogic(ctx context.Context, svcCtx *svc.ServiceContext) *RefreshTokenLogic {
+	return &RefreshTokenLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *RefreshTokenLogic) RefreshToken(req *types.RefreshTokenRequest) (*types.AuthResponse, error) {
+	sess, refreshToken, err := l.svcCtx.Sessions.Rotate(l.ctx, req.RefreshToken, l.svcCtx.RefreshExpire)
+	if err != nil {
+		if errors.Is(err, session.ErrInvalidRefreshToken) {
+			return nil, fmt.Errorf("登录已过期，请重新登录")
+		}
+		return nil, fmt.Errorf("刷新 token 失败: %w", err)
+	}
+
+	// Re-read the user so role changes take effect on the next refresh.
+	user := types.User{Id: sess.UserID}
+	err = l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT username, role FROM users WHERE id = ?`,
+		sess.UserID,
+	).Scan(&user.Username, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = l.svcCtx.Sessions.Revoke(l.ctx, sess.ID)
+			return nil, fmt.Errorf("登录已过期，请重新登录")
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	return buildAuthResponse(l.svcCtx, user, sess.ID, refreshToken)
+}
+
+type LogoutLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewLogoutLogic(ctx context.Context, svcCtx *svc.ServiceContext) *LogoutLogic {
+	return &LogoutLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *LogoutLogic) Logout(req *types.LogoutRequest) error {
+	token, ok := authctx.TokenFromCtx(l.ctx)
+	if !ok || req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	if req.All {
+		if err := l.svcCtx.Sessions.RevokeUser(l.ctx, req.UserId); err != nil {
+			return err
+		}
+	} else if err := l.svcCtx.Sessions.Revoke(l.ctx, token.SessionID); err != nil {
+		return err
+	}
+
+	// Revoking the session already rejects the access token; denylisting the
+	// jti as well keeps it rejected even if the session row is purged.
+	return l.svcCtx.Sessions.RevokeToken(l.ctx, token.ID, token.ExpiresAt)
+}
+
+type JwksLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewJwksLogic(ctx context.Context, svcCtx *svc.ServiceContext) *JwksLogic {
+	return &JwksLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *JwksLogic) Jwks() (*types.JWKSResponse, error) {
+	keys := l.svcCtx.Keys.PublicKeys()
+
+	resp := &types.JWKSResponse{
+		Keys: make([]types.JSONWebKey, 0, len(keys)),
+	}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, types.JSONWebKey{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
+	}
+
+	return resp, nil
+}
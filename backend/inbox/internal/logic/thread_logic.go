@@ -0,0 +1,326 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// visibleToParty matches the messages a user has sent or received and not
+// moved to their trash. It takes the user id twice.
+const visibleToParty = `((dm.sender_id = ? AND dm.sender_deleted_at IS NULL) OR EXISTS (
+	SELECT 1 FROM direct_message_recipients vr WHERE vr.message_id = dm.id AND vr.user_id = ? AND vr.deleted_at IS NULL))`
+
+// resolveReplyParent loads the message being replied to, checks the sender
+// took part in it and fills in the receivers and title from it. Receivers
+// left out default to the parent's sender, or to its recipients when users
+// reply to their own message; reply-all adds the other to and cc recipients.
+func (l *SendMessageLogic) resolveReplyParent(req *types.SendMessageRequest) (*types.Message, error) {
+	parent, err := fetchPersonalMessage(l.ctx, l.svcCtx.DB, req.ReplyToId, req.SenderId)
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+
+	// Everyone on the parent the replier can see, besides themselves. Blind
+	// copies may be written to but are never added by default.
+	var (
+		to, cc       []int64
+		participants = make(map[int64]bool)
+		own          = parent.SenderId == req.SenderId
+	)
+	if !own {
+		to = append(to, parent.SenderId)
+		participants[parent.SenderId] = true
+	}
+	for _, rec := range parent.Recipients {
+		if rec.UserId == req.SenderId {
+			continue
+		}
+		participants[rec.UserId] = true
+		if !own && !req.ReplyAll {
+			continue
+		}
+		switch rec.Kind {
+		case recipientTo:
+			to = append(to, rec.UserId)
+		case recipientCc:
+			cc = append(cc, rec.UserId)
+		}
+	}
+	// A note to oneself is answered to oneself.
+	if len(participants) == 0 {
+		participants[req.SenderId] = true
+		to = []int64{req.SenderId}
+	}
+	if len(to) == 0 {
+		to, cc = cc, nil
+	}
+
+	explicit := append(append(append([]int64{}, req.ReceiverIds...), req.CcIds...), req.BccIds...)
+	if req.ReceiverId != 0 {
+		explicit = append(explicit, req.ReceiverId)
+	}
+
+	switch {
+	case len(explicit) == 0:
+		req.ReceiverIds, req.CcIds = to, cc
+	case req.ReplyAll:
+		return nil, errors.New("replyAll cannot be combined with explicit receivers")
+	default:
+		for _, id := range explicit {
+			if !participants[id] {
+				return nil, errors.New("a reply must be addressed to participants of the parent message")
+			}
+		}
+	}
+
+	if req.Title == "" && parent.Title != "" {
+		req.Title = parent.Title
+		if !strings.HasPrefix(req.Title, "Re: ") {
+			req.Title = "Re: " + req.Title
+		}
+	}
+
+	return parent, nil
+}
+
+type ReplyMessageLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewReplyMessageLogic(ctx context.Context, svcCtx *svc.ServiceContext) *ReplyMessageLogic {
+	return &ReplyMessageLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *ReplyMessageLogic) ReplyMessage(req *types.ReplyMessageRequest) (*types.Message, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	return NewSendMessageLogic(l.ctx, l.svcCtx).SendMessage(&types.SendMessageRequest{
+		Channel:   "personal",
+		SenderId:  req.UserId,
+		Title:     req.Title,
+		Content:   req.Content,
+		ReplyToId: req.Id,
+		ReplyAll:  req.ReplyAll,
+	})
+}
+
+type ListConversationsLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewListConversationsLogic(ctx context.Context, svcCtx *svc.ServiceContext) *ListConversationsLogic {
+	return &ListConversationsLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *ListConversationsLogic) ListConversations(req *types.ListConversationsRequest) (*types.ListConversationsResponse, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = 20
+	}
+
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	var total int64
+	if err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT COUNT(DISTINCT dm.thread_id) FROM direct_messages dm WHERE `+visibleToParty,
+		req.UserId,
+		req.UserId,
+	).Scan(&total); err != nil {
+		return nil, fmt.Errorf("count conversations: %w", err)
+	}
+
+	// Ids grow with time, so the largest id in a thread is its latest message.
+	query := `
+SELECT t.thread_id, t.last_id, t.unread, t.total, ` + personalColumns + `
+FROM (
+	SELECT
+		dm.thread_id,
+		MAX(dm.id) AS last_id,
+		SUM(EXISTS (SELECT 1 FROM direct_message_recipients ur
+			WHERE ur.message_id = dm.id AND ur.user_id = ? AND ur.is_read = 0 AND ur.deleted_at IS NULL)) AS unread,
+		COUNT(*) AS total
+	FROM direct_messages dm
+	WHERE ` + visibleToParty + `
+	GROUP BY dm.thread_id
+	ORDER BY last_id DESC
+	LIMIT ? OFFSET ?
+) t
+JOIN direct_messages dm ON dm.id = t.last_id
+LEFT JOIN direct_message_recipients r ON r.message_id = dm.id AND r.user_id = ?
+ORDER BY t.last_id DESC`
+
+	offset := (req.Page - 1) * req.Size
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, req.UserId, req.UserId, req.UserId, req.Size, offset, req.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]types.Conversation, 0, req.Size)
+	for rows.Next() {
+		var (
+			conv   types.Conversation
+			lastID int64
+		)
+		msg, err := scanPersonalRow(prefixedScanner{
+			scanner: rows,
+			prefix:  []interface{}{&conv.ThreadId, &lastID, &conv.UnreadCount, &conv.MessageCount},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The peer is whoever wrote the last message, or its first
+		// receiver when the user wrote it.
+		conv.LastMessage = msg
+		conv.PeerId = msg.SenderId
+		if msg.SenderId == req.UserId {
+			conv.PeerId = msg.ReceiverId
+		}
+		items = append(items, conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list conversations: %w", err)
+	}
+
+	last := make([]types.Message, len(items))
+	for i := range items {
+		last[i] = items[i].LastMessage
+	}
+	if err := attachRecipients(l.ctx, l.svcCtx.DB, req.UserId, last); err != nil {
+		return nil, err
+	}
+	if err := attachProfiles(l.ctx, l.svcCtx, last); err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].LastMessage = last[i]
+	}
+
+	return &types.ListConversationsResponse{
+		Items: items,
+		Total: total,
+		Page:  req.Page,
+		Size:  req.Size,
+	}, nil
+}
+
+type ThreadMessagesLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewThreadMessagesLogic(ctx context.Context, svcCtx *svc.ServiceContext) *ThreadMessagesLogic {
+	return &ThreadMessagesLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+func (l *ThreadMessagesLogic) ThreadMessages(req *types.ThreadMessagesRequest) (*types.ListMessagesResponse, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = 20
+	}
+
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	where := "dm.thread_id = ? AND " + visibleToParty
+	args := []interface{}{req.ThreadId, req.UserId, req.UserId}
+
+	var total int64
+	if err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		"SELECT COUNT(*) FROM direct_messages dm WHERE "+where,
+		args...,
+	).Scan(&total); err != nil {
+		return nil, fmt.Errorf("count thread messages: %w", err)
+	}
+
+	if total == 0 {
+		return nil, translateNotFound(sql.ErrNoRows)
+	}
+
+	query := fmt.Sprintf(`
+SELECT %s
+FROM %s
+WHERE %s
+ORDER BY dm.created_at DESC, dm.id DESC
+LIMIT ? OFFSET ?`, personalColumns, personalFrom, where)
+
+	offset := (req.Page - 1) * req.Size
+	args = append([]interface{}{req.UserId}, args...)
+	rows, err := l.svcCtx.DB.QueryContext(l.ctx, query, append(args, req.Size, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("list thread messages: %w", err)
+	}
+	defer rows.Close()
+
+	var items []types.Message
+	for rows.Next() {
+		msg, err := scanPersonalRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, msg)
+	}
+
+	if err = attachRecipients(l.ctx, l.svcCtx.DB, req.UserId, items); err != nil {
+		return nil, err
+	}
+	if err = attachProfiles(l.ctx, l.svcCtx, items); err != nil {
+		return nil, err
+	}
+
+	return &types.ListMessagesResponse{
+		Items: items,
+		Total: total,
+		Page:  req.Page,
+		Size:  req.Size,
+	}, nil
+}
+
+// prefixedScanner lets a row scanner that expects a fixed column list read
+// rows carrying extra leading columns.
+type prefixedScanner struct {
+	scanner interface {
+		Scan(dest ...interface{}) error
+	}
+	prefix []interface{}
+}
+
+func (s prefixedScanner) Scan(dest ...interface{}) error {
+	return s.scanner.Scan(append(append([]interface{}{}, s.prefix...), dest...)...)
+}
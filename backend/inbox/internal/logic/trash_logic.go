@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type TrashLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewTrashLogic(ctx context.Context, svcCtx *svc.ServiceContext) *TrashLogic {
+	return &TrashLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// DeleteMessage moves a personal message to the caller's trash. The other
+// parties keep their copies; the row is purged once the sender and every
+// recipient have deleted it and the retention window has passed.
+func (l *TrashLogic) DeleteMessage(req *types.TrashMessageRequest) error {
+	_, err := l.setDeleted(req, true)
+	return err
+}
+
+// RestoreMessage takes a personal message back out of the caller's trash.
+func (l *TrashLogic) RestoreMessage(req *types.TrashMessageRequest) (*types.Message, error) {
+	return l.setDeleted(req, false)
+}
+
+func (l *TrashLogic) setDeleted(req *types.TrashMessageRequest, deleted bool) (*types.Message, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	if _, err := fetchPersonalMessage(l.ctx, l.svcCtx.DB, req.Id, req.UserId); err != nil {
+		return nil, translateNotFound(err)
+	}
+
+	// Only the caller's copy changes: the sender's side and/or their own
+	// recipient row, both for a message sent to oneself. Deleting again keeps
+	// the original time so retention is not extended.
+	deletedAt := sql.NullTime{Time: time.Now(), Valid: deleted}
+	if _, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`UPDATE direct_messages dm
+LEFT JOIN direct_message_recipients r ON r.message_id = dm.id AND r.user_id = ?
+SET
+	dm.sender_deleted_at = IF(dm.sender_id = ?, IF(? AND dm.sender_deleted_at IS NOT NULL, dm.sender_deleted_at, ?), dm.sender_deleted_at),
+	r.deleted_at = IF(? AND r.deleted_at IS NOT NULL, r.deleted_at, ?)
+WHERE dm.id = ?`,
+		req.UserId,
+		req.UserId,
+		deleted,
+		deletedAt,
+		deleted,
+		deletedAt,
+		req.Id,
+	); err != nil {
+		return nil, fmt.Errorf("update message trash state: %w", err)
+	}
+
+	// Read the message back so the response and the event carry the state
+	// just written.
+	msg, err := fetchPersonalMessage(l.ctx, l.svcCtx.DB, req.Id, req.UserId)
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+
+	// The message leaves or re-enters the caller's search results.
+	indexPersonalMessages(l.ctx, l.svcCtx, req.Id)
+
+	eventType := eventhub.EventMessageRestored
+	if deleted {
+		eventType = eventhub.EventMessageDeleted
+	}
+	publishEvent(l.ctx, l.svcCtx, req.UserId, eventType, msg)
+
+	return msg, nil
+}
@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+func TestRestoreMessageReadsBackAfterUpdate(t *testing.T) {
+	const userID, messageID = int64(7), int64(42)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	events, err := eventhub.New(eventhub.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _, _ := events.Subscribe(userID, "")
+	defer sub.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expectFetch := func(content string) {
+		mock.ExpectQuery(regexp.QuoteMeta("WHERE dm.id = ? AND (dm.sender_id = ? OR r.id IS NOT NULL)")).
+			WithArgs(userID, messageID, userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "sender_id", "receiver_id", "title", "content", "is_read",
+				"read_at", "created_at", "thread_id", "reply_to_id", "group_id"}).
+				AddRow(messageID, 3, userID, "hi", content, false, nil, now, messageID, nil, nil))
+		mock.ExpectQuery(regexp.QuoteMeta("FROM direct_message_recipients WHERE message_id IN (?)")).
+			WithArgs(messageID).
+			WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "kind", "group_id", "is_read", "read_at"}).
+				AddRow(messageID, userID, "to", nil, false, nil))
+	}
+
+	// The first read only checks the caller is a party; what they get back
+	// is whatever the second read sees once the update is in.
+	expectFetch("before")
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE direct_messages dm")).
+		WithArgs(userID, userID, false, nil, false, nil, messageID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectFetch("after")
+
+	l := NewTrashLogic(context.Background(), &svc.ServiceContext{DB: db, Events: events})
+	msg, err := l.RestoreMessage(&types.TrashMessageRequest{Id: messageID, UserId: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != "after" {
+		t.Fatalf("content = %q, want the state read after the update", msg.Content)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		var published types.Message
+		if err := json.Unmarshal(evt.Data, &published); err != nil {
+			t.Fatal(err)
+		}
+		if evt.Type != eventhub.EventMessageRestored || published.Content != "after" {
+			t.Fatalf("event = %s %+v", evt.Type, published)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+}
@@ -0,0 +1,544 @@
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/totp"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	// maxChallengeAttempts is how many codes one challenge token may try;
+	// after that the password has to be entered again.
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	recoveryCodeLength   = 10
+	// recoveryAlphabet leaves out 0, 1, l and o, which are easily misread.
+	// Its 32 letters divide 256, so every letter is equally likely.
+	recoveryAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+)
+
+var (
+	errInvalidChallenge     = errors.New("登录验证已过期，请重新登录")
+	errInvalidTwoFactorCode = errors.New("验证码错误")
+)
+
+// challenge answers a correct password of a two-step user with a short-lived
+// token that LoginTwoFactor exchanges, with a code, for the session.
+func (l *LoginLogic) challenge(req *types.LoginRequest, user types.User) (*types.AuthResponse, error) {
+	token, err := session.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(l.svcCtx.Config.TwoFactor.ChallengeExpire) * time.Second
+	if _, err = l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`INSERT INTO login_challenges (user_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		user.Id,
+		session.HashToken(token),
+		time.Now().Add(ttl),
+	); err != nil {
+		return nil, fmt.Errorf("创建登录验证失败: %w", err)
+	}
+	recordLoginAttempt(l.ctx, l.svcCtx, req, user.Id, loginChallenged)
+
+	return &types.AuthResponse{
+		ExpiresIn:         int64(ttl / time.Second),
+		User:              user,
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+	}, nil
+}
+
+// LoginTwoFactor finishes a two-step login with a code from the
+// authenticator app or an unused recovery code. Wrong codes count against
+// the account and address like wrong passwords do.
+func (l *LoginLogic) LoginTwoFactor(req *types.LoginTwoFactorRequest) (*types.AuthResponse, error) {
+	if req.ChallengeToken == "" {
+		return nil, errInvalidChallenge
+	}
+
+	var (
+		challengeID int64
+		user        types.User
+	)
+	err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT c.id, u.id, u.username, u.role
+FROM login_challenges c
+JOIN users u ON u.id = c.user_id
+WHERE c.token_hash = ? AND c.used_at IS NULL AND c.expires_at > ? AND u.totp_enabled_at IS NOT NULL`,
+		session.HashToken(req.ChallengeToken),
+		time.Now(),
+	).Scan(&challengeID, &user.Id, &user.Username, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidChallenge
+		}
+		return nil, fmt.Errorf("查询登录验证失败: %w", err)
+	}
+
+	loginReq := &types.LoginRequest{
+		Username:  user.Username,
+		Ip:        req.Ip,
+		UserAgent: req.UserAgent,
+	}
+	if err = l.checkGuard(loginReq, user.Id); err != nil {
+		return nil, err
+	}
+	settled := false
+	defer func() {
+		if !settled {
+			l.release(loginReq)
+		}
+	}()
+
+	// Taking an attempt before checking the code also keeps concurrent
+	// requests within the limit.
+	res, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ? AND used_at IS NULL AND attempts < ?`,
+		challengeID,
+		maxChallengeAttempts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("更新登录验证失败: %w", err)
+	}
+	if err = requireAffected(res, errInvalidChallenge); err != nil {
+		return nil, err
+	}
+
+	ok, err := verifySecondFactor(l.ctx, l.svcCtx, user.Id, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		settled = true
+		return nil, l.fail(loginReq, user.Id, errInvalidTwoFactorCode)
+	}
+
+	res, err = l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`UPDATE login_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL`,
+		time.Now(),
+		challengeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("更新登录验证失败: %w", err)
+	}
+	if err = requireAffected(res, errInvalidChallenge); err != nil {
+		return nil, err
+	}
+
+	settled = true
+	return l.succeed(loginReq, user)
+}
+
+// TwoFactorLogic manages the caller's two-step verification: enrolling an
+// authenticator app, turning it off, and their recovery codes.
+type TwoFactorLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewTwoFactorLogic(ctx context.Context, svcCtx *svc.ServiceContext) *TwoFactorLogic {
+	return &TwoFactorLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// Setup generates a new secret for the authenticator app. It takes effect
+// only once Enable has seen a code from it; calling Setup again replaces a
+// secret that was never confirmed.
+func (l *TwoFactorLogic) Setup(req *types.TwoFactorSetupRequest) (*types.TwoFactorSetupResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	username, enabled, err := l.loadStatus(req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, fmt.Errorf("两步验证已开启")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := l.svcCtx.TOTPCipher.Seal(secret, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := l.svcCtx.DB.ExecContext(
+		l.ctx,
+		`UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled_at IS NULL`,
+		sealed,
+		req.UserId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("保存两步验证密钥失败: %w", err)
+	}
+	if err = requireAffected(res, fmt.Errorf("两步验证已开启")); err != nil {
+		return nil, err
+	}
+
+	return &types.TwoFactorSetupResponse{
+		Secret:     secret,
+		OtpauthUri: totp.URI(l.svcCtx.Config.TwoFactor.Issuer, username, secret),
+	}, nil
+}
+
+// Enable turns two-step verification on once a code proves the app holds
+// the secret from Setup, and returns the first set of recovery codes. They
+// are shown only this once. It asks for the password too, so a stolen
+// session cannot enroll an app of its own and lock the owner out.
+func (l *TwoFactorLogic) Enable(req *types.EnableTwoFactorRequest) (*types.RecoveryCodesResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+	if err := checkPassword(l.ctx, l.svcCtx, req.UserId, req.Password); err != nil {
+		return nil, err
+	}
+
+	var (
+		secret  sql.NullString
+		enabled bool
+	)
+	err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = ?`,
+		req.UserId,
+	).Scan(&secret, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("缺少用户信息")
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+	if enabled {
+		return nil, fmt.Errorf("两步验证已开启")
+	}
+	if !secret.Valid {
+		return nil, fmt.Errorf("请先生成两步验证密钥")
+	}
+
+	ok, err := verifyTOTP(l.ctx, l.svcCtx, req.UserId, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err = l.inTx(func(tx *sql.Tx) error {
+		// Matching the secret makes sure a Setup in between, which the code
+		// above was not checked against, is not enabled by mistake.
+		res, err := tx.ExecContext(
+			l.ctx,
+			`UPDATE users SET totp_enabled_at = ? WHERE id = ? AND totp_enabled_at IS NULL AND totp_secret = ?`,
+			time.Now(),
+			req.UserId,
+			secret.String,
+		)
+		if err != nil {
+			return fmt.Errorf("开启两步验证失败: %w", err)
+		}
+		if err = requireAffected(res, fmt.Errorf("两步验证密钥已变更，请重新设置")); err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(l.ctx, tx, req.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable turns two-step verification off. It asks for the password as well
+// as a code, so a stolen session alone cannot weaken the account.
+func (l *TwoFactorLogic) Disable(req *types.DisableTwoFactorRequest) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	if err := checkPassword(l.ctx, l.svcCtx, req.UserId, req.Password); err != nil {
+		return err
+	}
+
+	if err := l.checkCode(req.UserId, req.Code); err != nil {
+		return err
+	}
+
+	return l.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(
+			l.ctx,
+			`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?`,
+			req.UserId,
+		); err != nil {
+			return fmt.Errorf("关闭两步验证失败: %w", err)
+		}
+		if _, err := tx.ExecContext(
+			l.ctx,
+			`DELETE FROM recovery_codes WHERE user_id = ?`,
+			req.UserId,
+		); err != nil {
+			return fmt.Errorf("删除恢复码失败: %w", err)
+		}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not, with a
+// new set.
+func (l *TwoFactorLogic) RegenerateRecoveryCodes(req *types.RegenerateRecoveryCodesRequest) (*types.RecoveryCodesResponse, error) {
+	if req.UserId <= 0 {
+		return nil, fmt.Errorf("缺少用户信息")
+	}
+
+	if err := l.checkCode(req.UserId, req.Code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := l.inTx(func(tx *sql.Tx) error {
+		var err error
+		codes, err = replaceRecoveryCodes(l.ctx, tx, req.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// checkCode verifies a code of a user with two-step verification on. Wrong
+// codes count against the account in the login guard, so a stolen session
+// cannot guess its way to the recovery codes.
+func (l *TwoFactorLogic) checkCode(userID int64, code string) error {
+	username, enabled, err := l.loadStatus(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("两步验证未开启")
+	}
+
+	return guardAccount(l.ctx, l.svcCtx, username, func() (bool, error) {
+		return verifySecondFactor(l.ctx, l.svcCtx, userID, code)
+	}, errInvalidTwoFactorCode)
+}
+
+func (l *TwoFactorLogic) loadStatus(userID int64) (string, bool, error) {
+	var (
+		username string
+		enabled  bool
+	)
+	err := l.svcCtx.DB.QueryRowContext(
+		l.ctx,
+		`SELECT username, totp_enabled_at IS NOT NULL FROM users WHERE id = ?`,
+		userID,
+	).Scan(&username, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, fmt.Errorf("缺少用户信息")
+		}
+		return "", false, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	return username, enabled, nil
+}
+
+func (l *TwoFactorLogic) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := l.svcCtx.DB.BeginTx(l.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	committed = true
+
+	return nil
+}
+
+// verifySecondFactor accepts a six digit code from the authenticator app or
+// an unused recovery code, which is used up.
+func verifySecondFactor(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if isTOTPCode(code) {
+		return verifyTOTP(ctx, svcCtx, userID, code)
+	}
+	return useRecoveryCode(ctx, svcCtx.DB, userID, code)
+}
+
+// verifyTOTP checks code against the user's secret. Each time step is
+// accepted once, so a code seen over a shoulder or in a log cannot be
+// replayed while it is still valid.
+func verifyTOTP(ctx context.Context, svcCtx *svc.ServiceContext, userID int64, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if !isTOTPCode(code) {
+		return false, nil
+	}
+
+	var (
+		secret   sql.NullString
+		lastStep sql.NullInt64
+	)
+	err := svcCtx.DB.QueryRowContext(
+		ctx,
+		`SELECT totp_secret, totp_last_step FROM users WHERE id = ?`,
+		userID,
+	).Scan(&secret, &lastStep)
+	if err != nil {
+		return false, fmt.Errorf("查询两步验证密钥失败: %w", err)
+	}
+	if !secret.Valid {
+		return false, nil
+	}
+	plain, err := svcCtx.TOTPCipher.Open(secret.String, userID)
+	if err != nil {
+		return false, fmt.Errorf("解密两步验证密钥失败: %w", err)
+	}
+
+	step, ok := totp.Validate(plain, code, time.Now(), svcCtx.Config.TwoFactor.Skew)
+	if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+		return false, nil
+	}
+
+	res, err := svcCtx.DB.ExecContext(
+		ctx,
+		`UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)`,
+		step,
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("更新两步验证状态失败: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func useRecoveryCode(ctx context.Context, db *sql.DB, userID int64, code string) (bool, error) {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeLength {
+		return false, nil
+	}
+
+	res, err := db.ExecContext(
+		ctx,
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now(),
+		userID,
+		session.HashToken(code),
+	)
+	if err != nil {
+		return false, fmt.Errorf("使用恢复码失败: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// replaceRecoveryCodes drops the user's recovery codes and stores the hashes
+// of a new set, returning the codes themselves.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("删除恢复码失败: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	placeholders := make([]string, 0, recoveryCodeCount)
+	args := make([]interface{}, 0, recoveryCodeCount*2)
+	for len(codes) < recoveryCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		placeholders = append(placeholders, "(?, ?)")
+		args = append(args, userID, session.HashToken(normalizeRecoveryCode(code)))
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO recovery_codes (user_id, code_hash) VALUES `+strings.Join(placeholders, ", "),
+		args...,
+	); err != nil {
+		return nil, fmt.Errorf("保存恢复码失败: %w", err)
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "k7m2p-xq9ab", split in half to
+// be easier to copy by hand.
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate recovery code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+	}
+
+	half := recoveryCodeLength / 2
+	return string(buf[:half]) + "-" + string(buf[half:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/keyset"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/loginguard"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/totp"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+const (
+	twoFactorUserID      = int64(7)
+	twoFactorChallengeID = int64(3)
+	twoFactorToken       = "challenge-token"
+)
+
+var (
+	lookupChallenge  = regexp.QuoteMeta("FROM login_challenges c") + ".*" + regexp.QuoteMeta("WHERE c.token_hash = ? AND c.used_at IS NULL AND c.expires_at > ?")
+	takeAttempt      = regexp.QuoteMeta("UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ? AND used_at IS NULL AND attempts < ?")
+	useChallenge     = regexp.QuoteMeta("UPDATE login_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL")
+	loadTOTPSecret   = regexp.QuoteMeta("SELECT totp_secret, totp_last_step FROM users WHERE id = ?")
+	storeTOTPStep    = regexp.QuoteMeta("UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)")
+	insertLoginAudit = regexp.QuoteMeta("INSERT INTO login_audit (user_id, username, ip, user_agent, outcome) VALUES (?, ?, ?, ?, ?)")
+)
+
+type twoFactorTest struct {
+	mock   sqlmock.Sqlmock
+	svcCtx *svc.ServiceContext
+	store  *loginguard.MemoryStore
+	secret string
+	sealed string
+}
+
+func newTwoFactorTest(t *testing.T) *twoFactorTest {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	cipher, err := totp.NewCipher("c2FtcGxlLXRvdHAta2V5LWNoYW5nZS1tZS0zMmJ5dGU=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyset.New(nil, "test-secret", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := cipher.Seal(secret, twoFactorUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := loginguard.NewMemoryStore(time.Hour)
+	svcCtx := &svc.ServiceContext{
+		DB:            db,
+		Keys:          keys,
+		Sessions:      session.NewStore(db),
+		AccessExpire:  time.Minute,
+		RefreshExpire: time.Hour,
+		TOTPCipher:    cipher,
+		LoginGuard: loginguard.New(store, loginguard.Policy{
+			FreeAttempts: 20,
+			LockAfter:    20,
+			LockFor:      time.Hour,
+		}, loginguard.Policy{}),
+	}
+	svcCtx.Config.TwoFactor.Skew = 1
+
+	return &twoFactorTest{mock: mock, svcCtx: svcCtx, store: store, secret: secret, sealed: sealed}
+}
+
+func (tt *twoFactorTest) expectChallenge() {
+	tt.mock.ExpectQuery(lookupChallenge).
+		WithArgs(session.HashToken(twoFactorToken), nowArg{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "id", "username", "role"}).
+			AddRow(twoFactorChallengeID, twoFactorUserID, "alice", "user"))
+}
+
+func (tt *twoFactorTest) expectAttempt(affected int64) {
+	tt.mock.ExpectExec(takeAttempt).
+		WithArgs(twoFactorChallengeID, maxChallengeAttempts).
+		WillReturnResult(sqlmock.NewResult(0, affected))
+}
+
+func (tt *twoFactorTest) expectSecret() {
+	tt.mock.ExpectQuery(loadTOTPSecret).
+		WithArgs(twoFactorUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step"}).AddRow(tt.sealed, nil))
+}
+
+func (tt *twoFactorTest) expectAudit(outcome string) {
+	tt.mock.ExpectExec(insertLoginAudit).
+		WithArgs(twoFactorUserID, "alice", "203.0.113.5", "", outcome).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// code is the current code of the test secret.
+func (tt *twoFactorTest) code(t *testing.T) string {
+	t.Helper()
+	code, err := totp.Code(tt.secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wrongCode is a six digit code no step within the skew accepts.
+func (tt *twoFactorTest) wrongCode(t *testing.T) string {
+	t.Helper()
+	step := totp.Step(time.Now())
+	valid := make(map[string]bool)
+	for delta := int64(-2); delta <= 2; delta++ {
+		code, err := totp.Code(tt.secret, step+delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid[code] = true
+	}
+	for i := 0; ; i++ {
+		if code := fmt.Sprintf("%06d", i); !valid[code] {
+			return code
+		}
+	}
+}
+
+func (tt *twoFactorTest) login(code string) (*types.AuthResponse, error) {
+	return NewLoginLogic(context.Background(), tt.svcCtx).LoginTwoFactor(&types.LoginTwoFactorRequest{
+		ChallengeToken: twoFactorToken,
+		Code:           code,
+		Ip:             "203.0.113.5",
+	})
+}
+
+func TestLoginTwoFactorCapsAttempts(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	wrong := tt.wrongCode(t)
+
+	for i := 0; i < maxChallengeAttempts; i++ {
+		tt.expectChallenge()
+		tt.expectAttempt(1)
+		tt.expectSecret()
+		tt.expectAudit(loginFailure)
+
+		if _, err := tt.login(wrong); !errors.Is(err, errInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: err = %v", i+1, err)
+		}
+	}
+
+	// With the attempts used up the challenge is dead: even the right code
+	// is not checked, and the password has to be entered again.
+	tt.expectChallenge()
+	tt.expectAttempt(0)
+	if _, err := tt.login(tt.code(t)); !errors.Is(err, errInvalidChallenge) {
+		t.Fatalf("err = %v, want %v", err, errInvalidChallenge)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each wrong code counted against the account like a wrong password,
+	// and no attempt was left in flight.
+	attempts, err := tt.store.Get(context.Background(), "user:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != maxChallengeAttempts || attempts.Pending != 0 {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+}
+
+func TestLoginTwoFactorChallengeIsSingleUse(t *testing.T) {
+	tt := newTwoFactorTest(t)
+
+	tt.expectChallenge()
+	tt.expectAttempt(1)
+	tt.expectSecret()
+	tt.mock.ExpectExec(storeTOTPStep).
+		WithArgs(sqlmock.AnyArg(), twoFactorUserID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.mock.ExpectExec(useChallenge).
+		WithArgs(nowArg{}, twoFactorChallengeID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.expectAudit(loginSuccess)
+	tt.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO auth_sessions (id, user_id, refresh_hash, expires_at) VALUES (?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), twoFactorUserID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	res, err := tt.login(tt.code(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Token == "" || res.RefreshToken == "" || res.User.Id != twoFactorUserID {
+		t.Fatalf("response = %+v", res)
+	}
+
+	// A used challenge no longer matches, so the token cannot be exchanged
+	// again, with this code or any other.
+	tt.mock.ExpectQuery(lookupChallenge).
+		WithArgs(session.HashToken(twoFactorToken), nowArg{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "id", "username", "role"}))
+	if _, err := tt.login(tt.code(t)); !errors.Is(err, errInvalidChallenge) {
+		t.Fatalf("err = %v, want %v", err, errInvalidChallenge)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginTwoFactorLosesRaceForChallenge(t *testing.T) {
+	tt := newTwoFactorTest(t)
+
+	// Another request with a recovery code used the challenge between the
+	// lookup and this one's right code: no second session is opened.
+	tt.expectChallenge()
+	tt.expectAttempt(1)
+	tt.expectSecret()
+	tt.mock.ExpectExec(storeTOTPStep).
+		WithArgs(sqlmock.AnyArg(), twoFactorUserID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.mock.ExpectExec(useChallenge).
+		WithArgs(nowArg{}, twoFactorChallengeID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := tt.login(tt.code(t)); !errors.Is(err, errInvalidChallenge) {
+		t.Fatalf("err = %v, want %v", err, errInvalidChallenge)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyTOTPRefusesReplay(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	step := totp.Step(time.Now())
+	code := tt.code(t)
+
+	// The code's step was already accepted once; it is refused without
+	// touching the stored step.
+	tt.mock.ExpectQuery(loadTOTPSecret).
+		WithArgs(twoFactorUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step"}).AddRow(tt.sealed, step+1))
+
+	ok, err := verifyTOTP(context.Background(), tt.svcCtx, twoFactorUserID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("replayed code accepted")
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnableTwoFactorRequiresPassword(t *testing.T) {
+	tt := newTwoFactorTest(t)
+	expectPassword(t, tt.mock)
+
+	_, err := NewTwoFactorLogic(context.Background(), tt.svcCtx).Enable(&types.EnableTwoFactorRequest{
+		Password: "wrong password",
+		Code:     tt.code(t),
+		UserId:   twoFactorUserID,
+	})
+	if err == nil || err.Error() != "密码错误" {
+		t.Fatalf("err = %v", err)
+	}
+	// The code is not even looked at, and nothing is enabled.
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
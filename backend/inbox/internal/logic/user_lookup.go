@@ -0,0 +1,160 @@
+package logic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+)
+
+// ReceiverNotFoundError reports receivers that have no account, so clients
+// can point at the exact ids or usernames that were mistyped.
+type ReceiverNotFoundError struct {
+	UserIds   []int64
+	Usernames []string
+}
+
+func (e *ReceiverNotFoundError) Error() string {
+	missing := make([]string, 0, len(e.UserIds)+len(e.Usernames))
+	for _, id := range e.UserIds {
+		missing = append(missing, strconv.FormatInt(id, 10))
+	}
+	missing = append(missing, e.Usernames...)
+	return "receiver not found: " + strings.Join(missing, ", ")
+}
+
+// resolveReceiverUsernames turns receiverUsername and receiverUsernames into
+// ids on the request, so the rest of sending only deals with ids.
+func resolveReceiverUsernames(ctx context.Context, db *sql.DB, req *types.SendMessageRequest) error {
+	if req.ReceiverUsername == "" && len(req.ReceiverUsernames) == 0 {
+		return nil
+	}
+	if req.ReceiverUsername != "" && req.ReceiverId > 0 {
+		return errors.New("receiverId and receiverUsername cannot be combined")
+	}
+
+	names := req.ReceiverUsernames
+	if req.ReceiverUsername != "" {
+		names = append([]string{req.ReceiverUsername}, names...)
+	}
+	ids, err := userIDsByName(ctx, db, names)
+	if err != nil {
+		return err
+	}
+
+	if req.ReceiverUsername != "" {
+		req.ReceiverId = ids[0]
+		ids = ids[1:]
+	}
+	req.ReceiverIds = append(req.ReceiverIds, ids...)
+
+	return nil
+}
+
+// userIDsByName looks up the ids of the named users, in the order given.
+// Surrounding spaces are ignored and, like the users table, case is too.
+func userIDsByName(ctx context.Context, db *sql.DB, names []string) ([]int64, error) {
+	if len(names) > maxRecipients {
+		return nil, fmt.Errorf("at most %d usernames per message", maxRecipients)
+	}
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.New("username must not be empty")
+		}
+		args[i] = name
+	}
+
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT id, username FROM users WHERE username IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("look up usernames: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]int64, len(args))
+	for rows.Next() {
+		var (
+			id       int64
+			username string
+		)
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, fmt.Errorf("scan username: %w", err)
+		}
+		found[strings.ToLower(username)] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate usernames: %w", err)
+	}
+
+	ids := make([]int64, len(args))
+	var missing []string
+	for i, arg := range args {
+		name := arg.(string)
+		id, ok := found[strings.ToLower(name)]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		ids[i] = id
+	}
+	if len(missing) > 0 {
+		return nil, &ReceiverNotFoundError{Usernames: missing}
+	}
+
+	return ids, nil
+}
+
+// ensureUsersExist fails with a ReceiverNotFoundError naming every id that
+// has no row in users.
+func ensureUsersExist(ctx context.Context, db *sql.DB, ids []int64) error {
+	ids = uniqueSortedIDs(ids)
+
+	existing := make(map[int64]bool, len(ids))
+	for start := 0; start < len(ids); start += maxBatchIds {
+		end := start + maxBatchIds
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		placeholders, args := inList(ids[start:end])
+		rows, err := db.QueryContext(ctx, `SELECT id FROM users WHERE id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return fmt.Errorf("check receivers: %w", err)
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan receiver: %w", err)
+			}
+			existing[id] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterate receivers: %w", err)
+		}
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if !existing[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return &ReceiverNotFoundError{UserIds: missing}
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/svc"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsMaxCommandSize = 4096
+)
+
+// wsCommand is a frame sent by the client:
+//
+//	{"type":"ack","id":"<event id>"}          confirm events up to id
+//	{"type":"read","ref":"1","uid":"..."}     mark an inbox item read
+//	{"type":"ping","ref":"2"}
+type wsCommand struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	Id   string `json:"id,omitempty"`
+	Uid  string `json:"uid,omitempty"`
+}
+
+// wsReply answers a command; Ref echoes the command's ref. Events are sent
+// as eventhub.Event frames, the same shape as on the SSE stream.
+type wsReply struct {
+	Type  string      `json:"type"`
+	Ref   string      `json:"ref,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+type WebSocketLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+func NewWebSocketLogic(ctx context.Context, svcCtx *svc.ServiceContext) *WebSocketLogic {
+	return &WebSocketLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// Serve runs an upgraded connection until either side closes it. Events are
+// pushed as they arrive; commands are answered in order. A connection without
+// lastEventId resumes after the last event the user acked.
+func (l *WebSocketLogic) Serve(req *types.WebSocketRequest, conn *websocket.Conn) error {
+	if req.UserId <= 0 {
+		return fmt.Errorf("缺少用户信息")
+	}
+
+	ctx, cancel := context.WithCancel(l.ctx)
+	defer cancel()
+
+	out := make(chan interface{}, 16)
+	send := func(frame interface{}) bool {
+		select {
+		case out <- frame:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	lastEventID := req.LastEventId
+	if lastEventID == "" {
+		lastEventID = l.svcCtx.Events.Acked(req.UserId)
+	}
+
+	threading.GoSafe(func() {
+		defer cancel()
+		err := streamInbox(ctx, l.svcCtx, req.UserId, lastEventID, func(evt *eventhub.Event) bool {
+			return send(evt)
+		})
+		if err != nil {
+			l.Errorf("stream events to user %d: %v", req.UserId, err)
+		}
+	})
+
+	threading.GoSafe(func() {
+		defer cancel()
+		l.readCommands(ctx, req.UserId, conn, send)
+	})
+
+	heartbeat := time.NewTicker(l.svcCtx.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case frame := <-out:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(frame); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return nil
+			}
+		case <-ctx.Done():
+			_ = conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(wsWriteWait),
+			)
+			return nil
+		}
+	}
+}
+
+// readCommands reads until the connection fails. A client that answers
+// neither pings nor sends anything for two heartbeats is considered gone.
+func (l *WebSocketLogic) readCommands(ctx context.Context, userID int64, conn *websocket.Conn, send func(interface{}) bool) {
+	idle := 2 * l.svcCtx.Heartbeat
+	conn.SetReadLimit(wsMaxCommandSize)
+	_ = conn.SetReadDeadline(time.Now().Add(idle))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(idle))
+	})
+
+	for {
+		var cmd wsCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(idle))
+
+		if !send(l.handleCommand(ctx, userID, &cmd)) {
+			return
+		}
+	}
+}
+
+func (l *WebSocketLogic) handleCommand(ctx context.Context, userID int64, cmd *wsCommand) *wsReply {
+	reply := &wsReply{Type: "reply", Ref: cmd.Ref}
+
+	switch cmd.Type {
+	case "ack":
+		if cmd.Id == "" {
+			reply.Error = "id is required"
+			break
+		}
+		l.svcCtx.Events.Ack(userID, cmd.Id)
+	case "read":
+		msg, err := NewMarkMessageReadLogic(ctx, l.svcCtx).MarkInboxItemRead(&types.MarkInboxItemReadRequest{
+			Uid:    cmd.Uid,
+			UserId: userID,
+		})
+		if err != nil {
+			reply.Error = err.Error()
+			break
+		}
+		reply.Data = msg
+	case "ping":
+		reply.Type = "pong"
+	default:
+		reply.Error = fmt.Sprintf("unsupported command: %q", cmd.Type)
+	}
+
+	return reply
+}
@@ -1,53 +1,134 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/rest/httpx"
 
 	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/keyset"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
 )
 
+// RevocationChecker reports whether an access token or the session it
+// belongs to has been revoked.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, sessionID, tokenID string) (bool, error)
+}
+
+// TicketRedeemer uses up the one-time tickets event streams present in
+// place of an access token.
+type TicketRedeemer interface {
+	RedeemTicket(ctx context.Context, ticket string) (*session.Ticket, error)
+}
+
 type AuthMiddleware struct {
-	secret []byte
+	keys       *keyset.KeySet
+	parser     *jwt.Parser
+	revocation RevocationChecker
+	tickets    TicketRedeemer
 }
 
-func NewAuthMiddleware(secret string) *AuthMiddleware {
+func NewAuthMiddleware(keys *keyset.KeySet, revocation RevocationChecker, tickets TicketRedeemer) *AuthMiddleware {
 	return &AuthMiddleware{
-		secret: []byte(secret),
+		keys:       keys,
+		parser:     jwt.NewParser(jwt.WithValidMethods(keys.Algorithms())),
+		revocation: revocation,
+		tickets:    tickets,
 	}
 }
 
 func (m *AuthMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := extractToken(r)
-		if tokenStr == "" {
-			writeUnauthorized(r, w, "缺少身份凭证")
-			return
+		var (
+			userID    int64
+			role      string
+			tokenInfo authctx.Token
+			ok        bool
+		)
+
+		if ticket := extractTicket(r); ticket != "" {
+			t, err := m.tickets.RedeemTicket(r.Context(), ticket)
+			if errors.Is(err, session.ErrInvalidTicket) {
+				writeUnauthorized(r, w, "连接凭证无效或已使用")
+				return
+			}
+			if err != nil {
+				logx.WithContext(r.Context()).Errorf("redeem stream ticket: %v", err)
+				writeUnavailable(r, w)
+				return
+			}
+
+			userID, role = t.UserID, t.Role
+			tokenInfo = authctx.Token{ID: t.TokenID, SessionID: t.SessionID, ExpiresAt: t.TokenExpiresAt}
+		} else {
+			tokenStr := extractToken(r)
+			if tokenStr == "" {
+				writeUnauthorized(r, w, "缺少身份凭证")
+				return
+			}
+
+			claims := jwt.MapClaims{}
+			token, err := m.parser.ParseWithClaims(tokenStr, claims, m.keys.Keyfunc)
+			if err != nil || !token.Valid {
+				writeUnauthorized(r, w, "身份凭证无效")
+				return
+			}
+
+			userID, ok = authctx.UserIDFromClaims(claims)
+			if !ok {
+				writeUnauthorized(r, w, "身份信息缺失")
+				return
+			}
+
+			tokenInfo, ok = authctx.TokenFromClaims(claims)
+			if !ok {
+				writeUnauthorized(r, w, "身份凭证已失效")
+				return
+			}
+			role = authctx.RoleFromClaims(claims)
 		}
 
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return m.secret, nil
-		})
-		if err != nil || !token.Valid {
-			writeUnauthorized(r, w, "身份凭证无效")
+		// A ticket is only as good as the token it was issued against, so
+		// logging out before the stream opens still stops it.
+		revoked, err := m.revocation.IsRevoked(r.Context(), tokenInfo.SessionID, tokenInfo.ID)
+		if err != nil {
+			logx.WithContext(r.Context()).Errorf("check token revocation: %v", err)
+			writeUnavailable(r, w)
 			return
 		}
-
-		userID, ok := authctx.UserIDFromClaims(claims)
-		if !ok {
-			writeUnauthorized(r, w, "身份信息缺失")
+		if revoked {
+			writeUnauthorized(r, w, "身份凭证已失效")
 			return
 		}
 
 		ctx := authctx.WithUserID(r.Context(), userID)
+		ctx = authctx.WithRole(ctx, role)
+		ctx = authctx.WithToken(ctx, tokenInfo)
 		next(w, r.WithContext(ctx))
 	}
 }
 
+// extractTicket returns the stream ticket of an event stream request that
+// has no Authorization header. EventSource and browser WebSockets cannot set
+// headers; they get a ticket from /api/v1/events/ticket instead of putting
+// the access token in the URL.
+func extractTicket(r *http.Request) string {
+	if r.Header.Get("Authorization") != "" {
+		return ""
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("ticket")
+	}
+	return ""
+}
+
 func extractToken(r *http.Request) string {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -71,3 +152,15 @@ func writeUnauthorized(r *http.Request, w http.ResponseWriter, message string) {
 		"message": message,
 	})
 }
+
+func writeUnavailable(r *http.Request, w http.ResponseWriter) {
+	httpx.WriteJsonCtx(r.Context(), w, http.StatusServiceUnavailable, map[string]string{
+		"message": "暂时无法验证身份凭证",
+	})
+}
+
+func writeForbidden(r *http.Request, w http.ResponseWriter, message string) {
+	httpx.WriteJsonCtx(r.Context(), w, http.StatusForbidden, map[string]string{
+		"message": message,
+	})
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/keyset"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+)
+
+type fakeSessions struct {
+	tickets map[string]*session.Ticket
+	revoked map[string]bool
+}
+
+func (f *fakeSessions) IsRevoked(_ context.Context, sessionID, _ string) (bool, error) {
+	return f.revoked[sessionID], nil
+}
+
+func (f *fakeSessions) RedeemTicket(_ context.Context, ticket string) (*session.Ticket, error) {
+	t, ok := f.tickets[ticket]
+	if !ok {
+		return nil, session.ErrInvalidTicket
+	}
+	delete(f.tickets, ticket)
+	return t, nil
+}
+
+func TestStreamAuthentication(t *testing.T) {
+	keys, err := keyset.New(nil, "secret", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := keys.Sign(jwt.MapClaims{
+		"userId": 7,
+		"jti":    "jti-1",
+		"sid":    "s1",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := &fakeSessions{
+		tickets: map[string]*session.Ticket{
+			"good":    {UserID: 7, Role: authctx.RoleAdmin, SessionID: "s1", TokenID: "jti-1"},
+			"revoked": {UserID: 8, SessionID: "s2", TokenID: "jti-2"},
+			"plain":   {UserID: 9, SessionID: "s3", TokenID: "jti-3"},
+		},
+		revoked: map[string]bool{"s2": true},
+	}
+	m := NewAuthMiddleware(keys, sessions, sessions)
+
+	var gotUser int64
+	var gotRole string
+	handler := m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = authctx.UserIDFromCtx(r.Context())
+		gotRole = authctx.RoleFromCtx(r.Context())
+	})
+
+	tests := []struct {
+		name     string
+		url      string
+		header   map[string]string
+		want     int
+		wantUser int64
+		wantRole string
+	}{
+		{
+			name:     "ticket opens an event stream",
+			url:      "/api/v1/events?ticket=good",
+			header:   map[string]string{"Accept": "text/event-stream"},
+			want:     http.StatusOK,
+			wantUser: 7,
+			wantRole: authctx.RoleAdmin,
+		},
+		{
+			name:   "ticket is single use",
+			url:    "/api/v1/events?ticket=good",
+			header: map[string]string{"Accept": "text/event-stream"},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "ticket of a revoked session",
+			url:    "/api/v1/ws?ticket=revoked",
+			header: map[string]string{"Upgrade": "websocket"},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "access token in the url is refused",
+			url:    "/api/v1/events?access_token=" + accessToken,
+			header: map[string]string{"Accept": "text/event-stream"},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "ticket only counts on stream requests",
+			url:  "/api/v1/messages?ticket=plain",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:     "authorization header still works on streams",
+			url:      "/api/v1/events",
+			header:   map[string]string{"Accept": "text/event-stream", "Authorization": "Bearer " + accessToken},
+			want:     http.StatusOK,
+			wantUser: 7,
+			wantRole: authctx.RoleUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser, gotRole = 0, ""
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.want, w.Body.String())
+			}
+			if gotUser != tt.wantUser || gotRole != tt.wantRole {
+				t.Fatalf("authenticated as %d/%q, want %d/%q", gotUser, gotRole, tt.wantUser, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+)
+
+// RoleMiddleware only lets callers holding one of the configured roles
+// through. It must run after AuthMiddleware.
+type RoleMiddleware struct {
+	roles map[string]struct{}
+}
+
+func NewRoleMiddleware(roles ...string) *RoleMiddleware {
+	allowed := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		allowed[role] = struct{}{}
+	}
+
+	return &RoleMiddleware{
+		roles: allowed,
+	}
+}
+
+func (m *RoleMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := m.roles[authctx.RoleFromCtx(r.Context())]; !ok {
+			writeForbidden(r, w, "权限不足")
+			return
+		}
+
+		next(w, r)
+	}
+}
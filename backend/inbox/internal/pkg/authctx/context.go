@@ -2,13 +2,24 @@ package authctx
 
 import (
 	"context"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "msgdemo:userId"
+const (
+	userIDKey contextKey = "msgdemo:userId"
+	roleKey   contextKey = "msgdemo:role"
+	tokenKey  contextKey = "msgdemo:token"
+)
+
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleService = "service"
+)
 
 func WithUserID(ctx context.Context, userID int64) context.Context {
 	return context.WithValue(ctx, userIDKey, userID)
@@ -44,3 +55,72 @@ func UserIDFromClaims(claims jwt.MapClaims) (int64, bool) {
 		return 0, false
 	}
 }
+
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// RoleFromCtx returns the caller's role, falling back to RoleUser when the
+// request carries no role information.
+func RoleFromCtx(ctx context.Context) string {
+	if role, ok := ctx.Value(roleKey).(string); ok && role != "" {
+		return role
+	}
+	return RoleUser
+}
+
+// RoleFromClaims reads the "role" claim. Tokens issued before roles existed
+// carry no claim and are treated as plain users.
+func RoleFromClaims(claims jwt.MapClaims) string {
+	role, _ := claims["role"].(string)
+	if !ValidRole(role) {
+		return RoleUser
+	}
+	return role
+}
+
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleAdmin, RoleService:
+		return true
+	default:
+		return false
+	}
+}
+
+func IsAdmin(ctx context.Context) bool {
+	return RoleFromCtx(ctx) == RoleAdmin
+}
+
+// Token identifies the access token a request was authenticated with.
+type Token struct {
+	ID        string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+func WithToken(ctx context.Context, token Token) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+func TokenFromCtx(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(tokenKey).(Token)
+	return token, ok
+}
+
+// TokenFromClaims reads the "jti", "sid" and "exp" claims. Tokens without a
+// session are not accepted.
+func TokenFromClaims(claims jwt.MapClaims) (Token, bool) {
+	id, _ := claims["jti"].(string)
+	sessionID, _ := claims["sid"].(string)
+	exp, _ := claims["exp"].(float64)
+	if id == "" || sessionID == "" {
+		return Token{}, false
+	}
+
+	return Token{
+		ID:        id,
+		SessionID: sessionID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, true
+}
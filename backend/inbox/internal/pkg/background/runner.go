@@ -0,0 +1,47 @@
+package background
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Runner runs work that outlives the request that started it, under a
+// context that is cancelled when the server shuts down. It is added to the
+// service group, so Stop cancels the context and waits for the work to
+// return before the process exits.
+type Runner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRunner() *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in the background. Work handed over after shutdown has begun
+// is dropped, so fn must be safe to skip: anything it leaves undone has to
+// be picked up again from persisted state.
+func (r *Runner) Go(fn func(ctx context.Context)) {
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	r.wg.Add(1)
+	threading.GoSafe(func() {
+		defer r.wg.Done()
+		fn(r.ctx)
+	})
+}
+
+func (r *Runner) Start() {
+	<-r.ctx.Done()
+}
+
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
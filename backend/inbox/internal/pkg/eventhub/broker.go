@@ -0,0 +1,125 @@
+package eventhub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// Envelope is the form in which an event travels between replicas. Ids are
+// not relayed; every hub numbers the events it delivers itself.
+type Envelope struct {
+	Origin string          `json:"o"`
+	UserID int64           `json:"u"`
+	Type   string          `json:"t"`
+	Data   json.RawMessage `json:"d"`
+}
+
+// Broker relays envelopes between the hubs of all replicas. Every hub
+// subscribed to a broker receives every envelope, its own included.
+type Broker interface {
+	Publish(ctx context.Context, env Envelope) error
+	// Subscribe calls handler for each envelope until stop is called.
+	Subscribe(handler func(Envelope)) (stop func(), err error)
+}
+
+// MemoryBroker connects hubs living in the same process. It is the broker
+// for a single replica.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]func(Envelope)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make(map[int]func(Envelope))}
+}
+
+func (b *MemoryBroker) Publish(_ context.Context, env Envelope) error {
+	b.mu.RLock()
+	handlers := make([]func(Envelope), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(env)
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(Envelope)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
+
+// RedisBroker relays envelopes over a Redis pub/sub channel. Pub/sub is
+// fire-and-forget: replicas that are disconnected miss envelopes, and their
+// clients recover through the unread snapshot sent on reconnect.
+type RedisBroker struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+func NewRedisBroker(client redis.UniversalClient, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, env Envelope) error {
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("marshal envelope: %w", err)
+	}
+
+	if err := b.client.Publish(ctx, b.channel, raw).Err(); err != nil {
+		return fmt.Errorf("publish to %s: %w", b.channel, err)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Subscribe(handler func(Envelope)) (func(), error) {
+	ctx := context.Background()
+	pubsub := b.client.Subscribe(ctx, b.channel)
+
+	// Wait for the subscription to be confirmed so a misconfigured Redis
+	// fails at startup rather than silently.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("subscribe to %s: %w", b.channel, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range pubsub.Channel() {
+			var env Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				logx.Errorf("decode envelope from %s: %v", b.channel, err)
+				continue
+			}
+			handler(env)
+		}
+	}()
+
+	return func() {
+		_ = pubsub.Close()
+		<-done
+	}, nil
+}
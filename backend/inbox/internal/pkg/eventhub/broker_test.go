@@ -0,0 +1,157 @@
+package eventhub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisBroker(t *testing.T, addr string) *RedisBroker {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisBroker(client, "inbox:events:test")
+}
+
+// testBrokers returns two brokers that stand for the same relay as seen by
+// two replicas.
+func testBrokers(t *testing.T) map[string]func(t *testing.T) (Broker, Broker) {
+	return map[string]func(t *testing.T) (Broker, Broker){
+		"memory": func(t *testing.T) (Broker, Broker) {
+			b := NewMemoryBroker()
+			return b, b
+		},
+		"redis": func(t *testing.T) (Broker, Broker) {
+			srv := miniredis.RunT(t)
+			return newRedisBroker(t, srv.Addr()), newRedisBroker(t, srv.Addr())
+		},
+	}
+}
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case evt, ok := <-sub.Events():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func expectNone(t *testing.T, sub *Subscription) {
+	t.Helper()
+	select {
+	case evt := <-sub.Events():
+		t.Fatalf("unexpected event %+v", evt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHubsRelayThroughBroker(t *testing.T) {
+	for name, brokers := range testBrokers(t) {
+		t.Run(name, func(t *testing.T) {
+			brokerA, brokerB := brokers(t)
+			hubA, err := New(Config{}, brokerA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hubA.Close()
+			hubB, err := New(Config{}, brokerB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hubB.Close()
+
+			localSub, _, _ := hubA.Subscribe(7, "")
+			defer localSub.Close()
+			remoteSub, _, _ := hubB.Subscribe(7, "")
+			defer remoteSub.Close()
+			otherSub, _, _ := hubB.Subscribe(8, "")
+			defer otherSub.Close()
+
+			if err := hubA.Publish(context.Background(), 7, EventMessageCreated, map[string]int64{"id": 42}); err != nil {
+				t.Fatal(err)
+			}
+
+			local := receive(t, localSub)
+			remote := receive(t, remoteSub)
+			for _, evt := range []Event{local, remote} {
+				if evt.Type != EventMessageCreated || evt.UserID != 7 || string(evt.Data) != `{"id":42}` {
+					t.Fatalf("event = %+v", evt)
+				}
+			}
+			// Each hub numbers what it delivers in its own sequence.
+			if local.ID == remote.ID {
+				t.Fatalf("both hubs used id %s", local.ID)
+			}
+			// The publisher delivered locally and must not get its own
+			// envelope a second time.
+			expectNone(t, localSub)
+			expectNone(t, otherSub)
+
+			if err := hubB.PublishAll(context.Background(), EventUnreadChanged, nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, sub := range []*Subscription{localSub, remoteSub, otherSub} {
+				if evt := receive(t, sub); evt.Type != EventUnreadChanged {
+					t.Fatalf("event = %+v", evt)
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerStop(t *testing.T) {
+	for name, brokers := range testBrokers(t) {
+		t.Run(name, func(t *testing.T) {
+			publisher, subscriber := brokers(t)
+
+			got := make(chan Envelope, 4)
+			stop, err := subscriber.Subscribe(func(env Envelope) { got <- env })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := Envelope{Origin: "a", UserID: 7, Type: EventMessageRead, Data: json.RawMessage(`{}`)}
+			if err := publisher.Publish(context.Background(), env); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case received := <-got:
+				if received.Origin != "a" || received.UserID != 7 || received.Type != EventMessageRead {
+					t.Fatalf("envelope = %+v", received)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no envelope received")
+			}
+
+			stop()
+			if err := publisher.Publish(context.Background(), env); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case received := <-got:
+				t.Fatalf("envelope %+v after stop", received)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestRedisBrokerFailsWithoutRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	addr := srv.Addr()
+	srv.Close()
+
+	if _, err := newRedisBroker(t, addr).Subscribe(func(Envelope) {}); err == nil {
+		t.Fatal("Subscribe succeeded without a Redis server")
+	}
+}
@@ -0,0 +1,313 @@
+package eventhub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageRead     = "message.read"
+	EventMessagesRead    = "messages.read"
+	EventMessageUnread   = "message.unread"
+	EventMessageDeleted  = "message.deleted"
+	EventMessageRestored = "message.restored"
+	EventMessageUpdated  = "message.updated"
+	EventRecipientsRead  = "message.recipients_read"
+	EventUnreadChanged   = "unread.changed"
+)
+
+// Event is one inbox change addressed to a single user. Ids increase within
+// a hub and are prefixed with the hub's epoch so that a Last-Event-ID from a
+// previous process, or from another replica, is recognised as foreign
+// instead of being compared.
+type Event struct {
+	ID     string          `json:"id,omitempty"`
+	Type   string          `json:"type"`
+	UserID int64           `json:"userId"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type Config struct {
+	// BufferSize is the per-subscriber channel size. A subscriber that
+	// falls this far behind is disconnected and must resume from history.
+	BufferSize int
+	// HistorySize is the number of recent events kept per user for
+	// Last-Event-ID replay.
+	HistorySize int
+	// Retention is how long a user's history is kept after their last
+	// subscriber left.
+	Retention time.Duration
+}
+
+// Hub is a pub/sub of inbox events keyed by user. Events are delivered to
+// the subscribers connected to this process and, when a Broker is set,
+// relayed to the hubs of the other replicas. Only users who are connected,
+// or were within Retention, have state; events for anyone else are dropped
+// locally since nobody here could receive or replay them.
+type Hub struct {
+	cfg    Config
+	epoch  string
+	broker Broker
+	stop   func()
+
+	mu    sync.Mutex
+	seq   uint64
+	users map[int64]*userState
+}
+
+type userState struct {
+	subs     map[*Subscription]struct{}
+	history  []Event
+	acked    string
+	lastSeen time.Time
+}
+
+type Subscription struct {
+	hub    *Hub
+	userID int64
+	ch     chan Event
+	once   sync.Once
+}
+
+// New creates a hub and, when broker is not nil, starts relaying through
+// it.
+func New(cfg Config, broker Broker) (*Hub, error) {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 64
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = 100
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 10 * time.Minute
+	}
+
+	h := &Hub{
+		cfg:    cfg,
+		epoch:  newEpoch(),
+		broker: broker,
+		users:  make(map[int64]*userState),
+	}
+
+	if broker != nil {
+		stop, err := broker.Subscribe(h.receive)
+		if err != nil {
+			return nil, fmt.Errorf("subscribe to event broker: %w", err)
+		}
+		h.stop = stop
+	}
+
+	return h, nil
+}
+
+// newEpoch names a hub. The random part keeps hubs created within the same
+// clock tick, such as two sharing a MemoryBroker, from taking each other's
+// envelopes for their own.
+func newEpoch() string {
+	var buf [4]byte
+	_, _ = rand.Read(buf[:])
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + hex.EncodeToString(buf[:])
+}
+
+// Close stops relaying; local delivery keeps working.
+func (h *Hub) Close() {
+	if h.stop != nil {
+		h.stop()
+	}
+}
+
+// NewEvent builds an event that is not part of any hub's sequence, such as a
+// snapshot sent on connect. It has no id.
+func NewEvent(userID int64, eventType string, data interface{}) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s event: %w", eventType, err)
+	}
+
+	return &Event{Type: eventType, UserID: userID, Data: raw}, nil
+}
+
+// Publish delivers an event to the user's subscribers on every replica.
+// Local delivery always happens; the returned error reports a failed relay.
+func (h *Hub) Publish(ctx context.Context, userID int64, eventType string, data interface{}) error {
+	evt, err := NewEvent(userID, eventType, data)
+	if err != nil {
+		return err
+	}
+
+	h.deliver(evt.UserID, evt.Type, evt.Data)
+	return h.relay(ctx, evt)
+}
+
+// PublishAll delivers an event to every connected user on every replica.
+func (h *Hub) PublishAll(ctx context.Context, eventType string, data interface{}) error {
+	return h.Publish(ctx, 0, eventType, data)
+}
+
+func (h *Hub) relay(ctx context.Context, evt *Event) error {
+	if h.broker == nil {
+		return nil
+	}
+
+	return h.broker.Publish(ctx, Envelope{
+		Origin: h.epoch,
+		UserID: evt.UserID,
+		Type:   evt.Type,
+		Data:   evt.Data,
+	})
+}
+
+// receive handles an envelope from the broker. Our own envelopes were
+// delivered when they were published.
+func (h *Hub) receive(env Envelope) {
+	if env.Origin == h.epoch {
+		return
+	}
+
+	h.deliver(env.UserID, env.Type, env.Data)
+}
+
+// deliver assigns the event an id from this hub's sequence, records it for
+// replay and hands it to the local subscribers. User 0 addresses everyone
+// connected.
+func (h *Hub) deliver(userID int64, eventType string, data json.RawMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if userID != 0 {
+		if state, ok := h.users[userID]; ok {
+			h.deliverLocked(userID, state, eventType, data)
+		}
+		return
+	}
+
+	for id, state := range h.users {
+		if len(state.subs) > 0 {
+			h.deliverLocked(id, state, eventType, data)
+		}
+	}
+}
+
+func (h *Hub) deliverLocked(userID int64, state *userState, eventType string, data json.RawMessage) {
+	h.seq++
+	evt := Event{
+		ID:     h.epoch + "-" + strconv.FormatUint(h.seq, 10),
+		Type:   eventType,
+		UserID: userID,
+		Data:   data,
+	}
+
+	state.history = append(state.history, evt)
+	if over := len(state.history) - h.cfg.HistorySize; over > 0 {
+		state.history = append(state.history[:0:0], state.history[over:]...)
+	}
+
+	for sub := range state.subs {
+		select {
+		case sub.ch <- evt:
+		default:
+			// Too slow: cut it loose; the client reconnects with
+			// Last-Event-ID and catches up from history.
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// Ack records the last event the user confirmed receiving, for clients that
+// resume without tracking Last-Event-ID themselves.
+func (h *Hub) Ack(userID int64, eventID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.users[userID]; ok {
+		state.acked = eventID
+	}
+}
+
+// Acked returns the last event id recorded by Ack.
+func (h *Hub) Acked(userID int64) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.users[userID]; ok {
+		return state.acked
+	}
+	return ""
+}
+
+// Subscribe registers a subscriber for the user. When lastEventID names an
+// event still in the user's history, the events after it are returned for
+// replay; replayed reports whether that was possible.
+func (h *Hub) Subscribe(userID int64, lastEventID string) (sub *Subscription, missed []Event, replayed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pruneLocked(time.Now())
+
+	state, ok := h.users[userID]
+	if !ok {
+		state = &userState{subs: make(map[*Subscription]struct{})}
+		h.users[userID] = state
+	}
+
+	sub = &Subscription{
+		hub:    h,
+		userID: userID,
+		ch:     make(chan Event, h.cfg.BufferSize),
+	}
+	state.subs[sub] = struct{}{}
+	state.lastSeen = time.Now()
+
+	if lastEventID == "" || !strings.HasPrefix(lastEventID, h.epoch+"-") {
+		return sub, nil, false
+	}
+
+	for i, evt := range state.history {
+		if evt.ID == lastEventID {
+			return sub, append([]Event(nil), state.history[i+1:]...), true
+		}
+	}
+
+	return sub, nil, false
+}
+
+// Events is closed when the subscription ends, either through Close or
+// because the subscriber fell too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.removeLocked(s)
+}
+
+func (h *Hub) removeLocked(sub *Subscription) {
+	sub.once.Do(func() {
+		if state, ok := h.users[sub.userID]; ok {
+			delete(state.subs, sub)
+			state.lastSeen = time.Now()
+		}
+		close(sub.ch)
+	})
+}
+
+// pruneLocked forgets users that have been disconnected for longer than
+// the retention window.
+func (h *Hub) pruneLocked(now time.Time) {
+	for id, state := range h.users {
+		if len(state.subs) == 0 && now.Sub(state.lastSeen) > h.cfg.Retention {
+			delete(h.users, id)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package keyset
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	// LegacyKeyID is the kid given to Auth.AccessSecret and assumed for
+	// tokens that carry no kid header.
+	LegacyKeyID = "default"
+)
+
+// KeyConfig describes one signing or verification key.
+type KeyConfig struct {
+	Kid       string `json:"Kid" yaml:"Kid"`
+	Algorithm string `json:"Algorithm,default=HS256,options=HS256|RS256|EdDSA" yaml:"Algorithm"`
+	// Secret is the shared secret for HS256 keys.
+	Secret string `json:"Secret,optional" yaml:"Secret"`
+	// PrivateKey and PublicKey are PEM blocks or paths to PEM files. A key
+	// with only a public half can verify but never sign.
+	PrivateKey string `json:"PrivateKey,optional" yaml:"PrivateKey"`
+	PublicKey  string `json:"PublicKey,optional" yaml:"PublicKey"`
+	// RetiredAt (RFC3339) stops the key from signing; it keeps verifying
+	// for the grace window after that.
+	RetiredAt string `json:"RetiredAt,optional" yaml:"RetiredAt"`
+}
+
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	retiredAt time.Time
+}
+
+func (k *key) retired(now time.Time) bool {
+	return !k.retiredAt.IsZero() && !now.Before(k.retiredAt)
+}
+
+// KeySet signs access tokens with the current key and verifies tokens signed
+// by any active key or by a retired key still inside its grace window.
+type KeySet struct {
+	keys    map[string]*key
+	order   []string
+	signing string
+	grace   time.Duration
+	now     func() time.Time
+}
+
+// New builds a key set. legacySecret, when set, is registered as an HS256
+// key named LegacyKeyID. signingKid selects the signing key; when empty the
+// first configured key that can sign and is not retired is used.
+func New(keys []KeyConfig, legacySecret, signingKid string, grace time.Duration) (*KeySet, error) {
+	ks := &KeySet{
+		keys:  make(map[string]*key),
+		grace: grace,
+		now:   time.Now,
+	}
+
+	if legacySecret != "" {
+		keys = append([]KeyConfig{{
+			Kid:       LegacyKeyID,
+			Algorithm: AlgHS256,
+			Secret:    legacySecret,
+		}}, keys...)
+	}
+
+	for _, c := range keys {
+		k, err := loadKey(c)
+		if err != nil {
+			return nil, fmt.Errorf("load key %q: %w", c.Kid, err)
+		}
+		if _, dup := ks.keys[k.id]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", k.id)
+		}
+		ks.keys[k.id] = k
+		ks.order = append(ks.order, k.id)
+	}
+
+	now := ks.now()
+	if signingKid == "" {
+		signingKid = ks.defaultSigner(now)
+	}
+
+	signing, ok := ks.keys[signingKid]
+	if !ok || signing.signKey == nil {
+		return nil, fmt.Errorf("no usable signing key %q", signingKid)
+	}
+	if signing.retired(now) {
+		return nil, fmt.Errorf("signing key %q is retired", signingKid)
+	}
+	ks.signing = signingKid
+
+	return ks, nil
+}
+
+// defaultSigner picks the first configured key that can sign, falling back
+// to the legacy secret only when no other key qualifies.
+func (ks *KeySet) defaultSigner(now time.Time) string {
+	fallback := ""
+	for _, id := range ks.order {
+		k := ks.keys[id]
+		if k.signKey == nil || k.retired(now) {
+			continue
+		}
+		if id != LegacyKeyID {
+			return id
+		}
+		fallback = id
+	}
+	return fallback
+}
+
+// Sign signs the claims with the current signing key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k := ks.keys[ks.signing]
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.signKey)
+}
+
+// Keyfunc resolves the verification key for a parsed token. The algorithm
+// in the header must match the key's algorithm.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyKeyID
+	}
+
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("algorithm %s does not match key %q", token.Method.Alg(), kid)
+	}
+	if k.retired(ks.now().Add(-ks.grace)) {
+		return nil, fmt.Errorf("key %q is retired", kid)
+	}
+
+	return k.verifyKey, nil
+}
+
+// Algorithms lists the algorithms of all configured keys, for restricting
+// the JWT parser.
+func (ks *KeySet) Algorithms() []string {
+	seen := make(map[string]struct{})
+	var algs []string
+	for _, id := range ks.order {
+		alg := ks.keys[id].method.Alg()
+		if _, ok := seen[alg]; !ok {
+			seen[alg] = struct{}{}
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// PublicKeys returns the asymmetric keys that currently verify tokens.
+// Shared HS256 secrets are never published.
+func (ks *KeySet) PublicKeys() []JWK {
+	cutoff := ks.now().Add(-ks.grace)
+
+	jwks := make([]JWK, 0, len(ks.order))
+	for _, id := range ks.order {
+		k := ks.keys[id]
+		if k.retired(cutoff) {
+			continue
+		}
+
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: k.id,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: k.id,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return jwks
+}
+
+func loadKey(c KeyConfig) (*key, error) {
+	if c.Kid == "" {
+		return nil, errors.New("kid is required")
+	}
+
+	k := &key{id: c.Kid}
+	if c.RetiredAt != "" {
+		retiredAt, err := time.Parse(time.RFC3339, c.RetiredAt)
+		if err != nil {
+			return nil, fmt.Errorf("parse RetiredAt: %w", err)
+		}
+		k.retiredAt = retiredAt
+	}
+
+	switch c.Algorithm {
+	case AlgHS256, "":
+		if c.Secret == "" {
+			return nil, errors.New("HS256 key requires Secret")
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(c.Secret)
+		k.verifyKey = []byte(c.Secret)
+	case AlgRS256:
+		k.method = jwt.SigningMethodRS256
+		if err := loadPair(c, k, rsaParsers); err != nil {
+			return nil, err
+		}
+	case AlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+		if err := loadPair(c, k, edParsers); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+
+	return k, nil
+}
+
+type pemParsers struct {
+	private func([]byte) (crypto.Signer, error)
+	public  func([]byte) (crypto.PublicKey, error)
+}
+
+var rsaParsers = pemParsers{
+	private: func(b []byte) (crypto.Signer, error) { return jwt.ParseRSAPrivateKeyFromPEM(b) },
+	public:  func(b []byte) (crypto.PublicKey, error) { return jwt.ParseRSAPublicKeyFromPEM(b) },
+}
+
+var edParsers = pemParsers{
+	private: func(b []byte) (crypto.Signer, error) {
+		priv, err := jwt.ParseEdPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("not an Ed25519 private key")
+		}
+		return signer, nil
+	},
+	public: jwt.ParseEdPublicKeyFromPEM,
+}
+
+func loadPair(c KeyConfig, k *key, parsers pemParsers) error {
+	if c.PrivateKey != "" {
+		pem, err := readPEM(c.PrivateKey)
+		if err != nil {
+			return err
+		}
+		signer, err := parsers.private(pem)
+		if err != nil {
+			return fmt.Errorf("parse private key: %w", err)
+		}
+		k.signKey = signer
+		k.verifyKey = signer.Public()
+		return nil
+	}
+
+	if c.PublicKey != "" {
+		pem, err := readPEM(c.PublicKey)
+		if err != nil {
+			return err
+		}
+		pub, err := parsers.public(pem)
+		if err != nil {
+			return fmt.Errorf("parse public key: %w", err)
+		}
+		k.verifyKey = pub
+		return nil
+	}
+
+	return errors.New("PrivateKey or PublicKey is required")
+}
+
+// readPEM accepts either an inline PEM block or a path to a PEM file.
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,218 @@
+package keyset
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func rsaPEM(t *testing.T) string {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}))
+}
+
+func edPEM(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestNewSelectsSigningKey(t *testing.T) {
+	retired := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	rsaKey, edKey := rsaPEM(t), edPEM(t)
+
+	tests := []struct {
+		name    string
+		keys    []KeyConfig
+		legacy  string
+		kid     string
+		want    string
+		wantAlg string
+		wantErr bool
+	}{
+		{
+			name:    "legacy secret alone",
+			legacy:  "s3cret",
+			want:    LegacyKeyID,
+			wantAlg: AlgHS256,
+		},
+		{
+			name:    "configured key preferred over legacy secret",
+			legacy:  "s3cret",
+			keys:    []KeyConfig{{Kid: "rsa-1", Algorithm: AlgRS256, PrivateKey: rsaKey}},
+			want:    "rsa-1",
+			wantAlg: AlgRS256,
+		},
+		{
+			name: "retired key skipped",
+			keys: []KeyConfig{
+				{Kid: "old", Algorithm: AlgHS256, Secret: "a", RetiredAt: retired},
+				{Kid: "ed-1", Algorithm: AlgEdDSA, PrivateKey: edKey},
+			},
+			want:    "ed-1",
+			wantAlg: AlgEdDSA,
+		},
+		{
+			name: "explicit signing kid",
+			keys: []KeyConfig{
+				{Kid: "a", Algorithm: AlgHS256, Secret: "a"},
+				{Kid: "b", Algorithm: AlgEdDSA, PrivateKey: edKey},
+			},
+			kid:     "b",
+			want:    "b",
+			wantAlg: AlgEdDSA,
+		},
+		{
+			name:    "explicit kid that is retired",
+			keys:    []KeyConfig{{Kid: "old", Algorithm: AlgHS256, Secret: "a", RetiredAt: retired}},
+			kid:     "old",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate kid",
+			keys:    []KeyConfig{{Kid: "a", Secret: "a"}, {Kid: "a", Secret: "b"}},
+			wantErr: true,
+		},
+		{
+			name:    "no key can sign",
+			keys:    []KeyConfig{{Kid: "a", Secret: "a", RetiredAt: retired}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := New(tt.keys, tt.legacy, tt.kid, time.Hour)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("New succeeded, signing with %q", ks.signing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signed, err := ks.Sign(jwt.RegisteredClaims{Subject: "7"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := jwt.Parse(signed, ks.Keyfunc, jwt.WithValidMethods(ks.Algorithms()))
+			if err != nil {
+				t.Fatalf("verify own token: %v", err)
+			}
+			if kid := token.Header["kid"]; kid != tt.want {
+				t.Fatalf("kid = %v, want %s", kid, tt.want)
+			}
+			if alg := token.Method.Alg(); alg != tt.wantAlg {
+				t.Fatalf("alg = %s, want %s", alg, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	rsaKey := rsaPEM(t)
+	retiredAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ks, err := New([]KeyConfig{
+		{Kid: "rsa-1", Algorithm: AlgRS256, PrivateKey: rsaKey},
+		{Kid: "old", Algorithm: AlgHS256, Secret: "old-secret", RetiredAt: retiredAt.Format(time.RFC3339)},
+	}, "legacy-secret", "rsa-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs256 := func(kid, secret string) *jwt.Token {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, err := new(jwt.Parser).ParseUnverified(signed, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name    string
+		token   *jwt.Token
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "missing kid falls back to legacy key", token: hs256("", "legacy-secret"), now: retiredAt},
+		{name: "unknown kid", token: hs256("nope", "x"), now: retiredAt, wantErr: true},
+		{name: "algorithm must match key", token: hs256("rsa-1", "x"), now: retiredAt, wantErr: true},
+		{name: "retired key inside grace", token: hs256("old", "old-secret"), now: retiredAt.Add(59 * time.Minute)},
+		{name: "retired key at end of grace", token: hs256("old", "old-secret"), now: retiredAt.Add(time.Hour), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks.now = func() time.Time { return tt.now }
+
+			_, err := ks.Keyfunc(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicKeysSkipSecretsAndExpiredKeys(t *testing.T) {
+	retiredAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ks, err := New([]KeyConfig{
+		{Kid: "ed-1", Algorithm: AlgEdDSA, PrivateKey: edPEM(t)},
+		{Kid: "rsa-old", Algorithm: AlgRS256, PrivateKey: rsaPEM(t), RetiredAt: retiredAt.Format(time.RFC3339)},
+	}, "legacy-secret", "ed-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{name: "retired key published during grace", now: retiredAt.Add(30 * time.Minute), want: []string{"ed-1", "rsa-old"}},
+		{name: "retired key dropped after grace", now: retiredAt.Add(2 * time.Hour), want: []string{"ed-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks.now = func() time.Time { return tt.now }
+
+			jwks := ks.PublicKeys()
+			if len(jwks) != len(tt.want) {
+				t.Fatalf("published %d keys, want %v", len(jwks), tt.want)
+			}
+			for i, jwk := range jwks {
+				if jwk.Kid != tt.want[i] {
+					t.Fatalf("key %d = %s, want %s", i, jwk.Kid, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	maxUsernameLength = 64
+	// reservationTTL is how long an attempt reserved by Check counts as in
+	// flight. One never settled, because its request died, stops counting
+	// after it.
+	reservationTTL = time.Minute
+)
+
+// Attempts is the failure record kept for one key. Records are forgotten
+// once no failure has been added for the store's window.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+	// Pending counts attempts that passed Check and have not been settled
+	// by Fail, Succeed or Release yet.
+	Pending int
+}
+
+// Store keeps failure records by key. Implementations must make Reserve and
+// Fail atomic, since replicas may record attempts for the same key at once.
+type Store interface {
+	// Get returns the record of key; an unknown key has no failures.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// Reserve adds an attempt in flight and returns the record including it.
+	Reserve(ctx context.Context, key string, at time.Time) (Attempts, error)
+	// Release settles an attempt in flight without a failure.
+	Release(ctx context.Context, key string) error
+	// Fail settles an attempt in flight as a failure at the given time and
+	// returns the new record.
+	Fail(ctx context.Context, key string, at time.Time) (Attempts, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy turns a failure count into a wait. The first FreeAttempts failures
+// cost nothing; every further one doubles the wait from BaseDelay up to
+// MaxDelay, and LockAfter failures lock the key for LockFor.
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+}
+
+// blockedUntil is when the next attempt is allowed, and whether the key is
+// locked rather than merely backing off.
+func (p Policy) blockedUntil(a Attempts) (time.Time, bool) {
+	if p.LockAfter > 0 && a.Failures >= p.LockAfter {
+		return a.LastFailure.Add(p.LockFor), true
+	}
+
+	over := a.Failures - p.FreeAttempts
+	if over <= 0 || p.BaseDelay <= 0 {
+		return time.Time{}, false
+	}
+
+	// Doubling stops at MaxDelay, before it could overflow.
+	delay := p.BaseDelay
+	for i := 1; i < over && delay < p.MaxDelay; i++ {
+		if delay > p.MaxDelay/2 {
+			delay = p.MaxDelay
+			break
+		}
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return a.LastFailure.Add(delay), false
+}
+
+// counted is the record Check judges a reserved attempt by: the other
+// attempts in flight count as if they had just failed, so concurrent
+// attempts cannot all pass a check meant to let them through one by one.
+func (a Attempts) counted(now time.Time) Attempts {
+	others := a.Pending - 1
+	if others <= 0 {
+		return a
+	}
+	return Attempts{Failures: a.Failures + others, LastFailure: now}
+}
+
+// Verdict is the outcome of a check. A zero RetryAfter lets the attempt go
+// ahead.
+type Verdict struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+// Guard throttles password attempts per account and per client address, so
+// neither guessing one account's password nor spraying many accounts from
+// one address gets far.
+type Guard struct {
+	store   Store
+	account Policy
+	address Policy
+}
+
+func New(store Store, account, address Policy) *Guard {
+	return &Guard{store: store, account: account, address: address}
+}
+
+// Check reports whether an attempt at username from ip may proceed now. An
+// attempt allowed to proceed is reserved against the account and address
+// until Fail, Succeed or Release settles it.
+func (g *Guard) Check(ctx context.Context, username, ip string) (Verdict, error) {
+	now := time.Now()
+	keys := g.keys(username, ip)
+
+	var verdict Verdict
+	for i, k := range keys {
+		attempts, err := g.store.Reserve(ctx, k.key, now)
+		if err != nil {
+			g.release(ctx, keys[:i])
+			return Verdict{}, err
+		}
+
+		until, locked := k.policy.blockedUntil(attempts.counted(now))
+		if wait := until.Sub(now); wait > verdict.RetryAfter {
+			verdict = Verdict{RetryAfter: wait, Locked: locked}
+		}
+	}
+
+	if verdict.RetryAfter > 0 {
+		g.release(ctx, keys)
+	}
+	return verdict, nil
+}
+
+// Fail records a failed attempt against both the account and the address;
+// one failing to save does not keep the other from counting.
+func (g *Guard) Fail(ctx context.Context, username, ip string) error {
+	now := time.Now()
+
+	var errs []error
+	for _, k := range g.keys(username, ip) {
+		if _, err := g.store.Fail(ctx, k.key, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Succeed clears the account's failures. The address keeps its record, or
+// an attacker holding one valid account could keep resetting it; only the
+// attempt is settled there.
+func (g *Guard) Succeed(ctx context.Context, username, ip string) error {
+	err := g.store.Reset(ctx, accountKey(username))
+	if ip != "" {
+		err = errors.Join(err, g.store.Release(ctx, addressKey(ip)))
+	}
+	return err
+}
+
+// Release settles an attempt that neither failed nor succeeded, such as one
+// that stopped on an internal error or moved on to a second step.
+func (g *Guard) Release(ctx context.Context, username, ip string) error {
+	return g.release(ctx, g.keys(username, ip))
+}
+
+func (g *Guard) release(ctx context.Context, keys []policyKey) error {
+	var errs []error
+	for _, k := range keys {
+		if err := g.store.Release(ctx, k.key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Unlock clears the failures of an account and, if given, an address.
+func (g *Guard) Unlock(ctx context.Context, username, ip string) error {
+	if username != "" {
+		if err := g.store.Reset(ctx, accountKey(username)); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := g.store.Reset(ctx, addressKey(ip)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type policyKey struct {
+	key    string
+	policy Policy
+}
+
+func (g *Guard) keys(username, ip string) []policyKey {
+	keys := []policyKey{{key: accountKey(username), policy: g.account}}
+	if ip != "" {
+		keys = append(keys, policyKey{key: addressKey(ip), policy: g.address})
+	}
+	return keys
+}
+
+// Usernames compare case-insensitively in the users table, so they do here.
+// No username is longer than maxUsernameLength, so anything past it is cut
+// to keep keys bounded.
+func accountKey(username string) string {
+	name := []rune(strings.ToLower(strings.TrimSpace(username)))
+	if len(name) > maxUsernameLength {
+		name = name[:maxUsernameLength]
+	}
+	return "user:" + string(name)
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,180 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyBlockedUntil(t *testing.T) {
+	last := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockAfter:    10,
+		LockFor:      15 * time.Minute,
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		failures   int
+		wantDelay  time.Duration
+		wantLocked bool
+	}{
+		{name: "no failures", policy: policy, failures: 0},
+		{name: "last free failure", policy: policy, failures: 3},
+		{name: "first paid failure", policy: policy, failures: 4, wantDelay: time.Second},
+		{name: "doubles", policy: policy, failures: 6, wantDelay: 4 * time.Second},
+		{name: "one below the lock", policy: policy, failures: 9, wantDelay: 32 * time.Second},
+		{name: "locks at LockAfter", policy: policy, failures: 10, wantDelay: 15 * time.Minute, wantLocked: true},
+		{name: "stays locked", policy: policy, failures: 500, wantDelay: 15 * time.Minute, wantLocked: true},
+		{
+			name:      "capped at MaxDelay",
+			policy:    Policy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: 5 * time.Minute},
+			failures:  10,
+			wantDelay: 5 * time.Minute,
+		},
+		{
+			name:      "just below the cap",
+			policy:    Policy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: 5 * time.Minute},
+			failures:  9,
+			wantDelay: 256 * time.Second,
+		},
+		{
+			// Shifting an hour left 62 times would overflow into a negative
+			// wait; the cap must hold instead.
+			name:      "no overflow",
+			policy:    Policy{FreeAttempts: 0, BaseDelay: time.Hour, MaxDelay: 1000 * time.Hour},
+			failures:  63,
+			wantDelay: 1000 * time.Hour,
+		},
+		{
+			name:      "base above the cap",
+			policy:    Policy{FreeAttempts: 0, BaseDelay: time.Hour, MaxDelay: time.Minute},
+			failures:  1,
+			wantDelay: time.Minute,
+		},
+		{name: "no backoff without a base delay", policy: Policy{FreeAttempts: 0, MaxDelay: time.Minute}, failures: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, locked := tt.policy.blockedUntil(Attempts{Failures: tt.failures, LastFailure: last})
+			if tt.wantDelay == 0 {
+				if !until.IsZero() && until.After(last) {
+					t.Fatalf("blocked until %v", until)
+				}
+			} else if got := until.Sub(last); got != tt.wantDelay {
+				t.Fatalf("delay = %v, want %v", got, tt.wantDelay)
+			}
+			if locked != tt.wantLocked {
+				t.Fatalf("locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestGuardReservesConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	guard := New(NewMemoryStore(time.Hour), Policy{LockAfter: 3, LockFor: time.Minute}, Policy{})
+
+	// Ten attempts arrive before any of them has failed. Only as many as
+	// the lock allows may go ahead.
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verdict, err := guard.Check(ctx, "alice", "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if verdict.RetryAfter == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("%d attempts allowed, want 3", allowed)
+	}
+
+	// Settling them as failures locks the account for good.
+	for i := 0; i < 3; i++ {
+		if err := guard.Fail(ctx, "alice", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verdict, err := guard.Check(ctx, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verdict.Locked {
+		t.Fatalf("verdict = %+v, want locked", verdict)
+	}
+}
+
+func TestGuardSettlesReservations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	guard := New(store, Policy{LockAfter: 2, LockFor: time.Minute}, Policy{LockAfter: 2, LockFor: time.Minute})
+
+	pending := func(key string) int {
+		t.Helper()
+		attempts, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attempts.Pending
+	}
+
+	if _, err := guard.Check(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if pending("user:bob") != 1 || pending("ip:10.0.0.1") != 1 {
+		t.Fatal("check did not reserve the attempt")
+	}
+	if err := guard.Release(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if pending("user:bob") != 0 || pending("ip:10.0.0.1") != 0 {
+		t.Fatal("release did not settle the attempt")
+	}
+
+	if _, err := guard.Check(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Succeed(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if pending("user:bob") != 0 || pending("ip:10.0.0.1") != 0 {
+		t.Fatal("success did not settle the attempt")
+	}
+
+	// A refused attempt holds no reservation.
+	for i := 0; i < 2; i++ {
+		if _, err := store.Fail(ctx, "user:bob", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verdict, err := guard.Check(ctx, "bob", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.RetryAfter == 0 {
+		t.Fatal("locked account was let through")
+	}
+	if pending("user:bob") != 0 || pending("ip:10.0.0.1") != 0 {
+		t.Fatal("refused attempt kept its reservation")
+	}
+}
@@ -0,0 +1,114 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps failure records in the process. Records are not shared
+// between replicas and are lost on restart, so it suits a single replica.
+type MemoryStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	records map[string]memoryRecord
+	// sweepAt is when expired records are next dropped.
+	sweepAt time.Time
+}
+
+type memoryRecord struct {
+	Attempts
+	// pendingUntil is when the attempts in flight stop counting.
+	pendingUntil time.Time
+}
+
+func NewMemoryStore(window time.Duration) *MemoryStore {
+	return &MemoryStore{window: window, records: make(map[string]memoryRecord)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current(key, time.Now()).Attempts, nil
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, key string, at time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(at)
+
+	record := s.current(key, at)
+	record.Pending++
+	record.pendingUntil = at.Add(reservationTTL)
+	s.records[key] = record
+
+	return record.Attempts, nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.current(key, time.Now())
+	if record.Pending > 0 {
+		record.Pending--
+		s.records[key] = record
+	}
+	return nil
+}
+
+func (s *MemoryStore) Fail(_ context.Context, key string, at time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(at)
+
+	record := s.current(key, at)
+	record.Failures++
+	record.LastFailure = at
+	if record.Pending > 0 {
+		record.Pending--
+	}
+	s.records[key] = record
+
+	return record.Attempts, nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// current is the record of key as of now, without expired failures or
+// lapsed reservations.
+func (s *MemoryStore) current(key string, now time.Time) memoryRecord {
+	record := s.records[key]
+	if s.expired(record.Attempts, now) {
+		record.Failures, record.LastFailure = 0, time.Time{}
+	}
+	if !now.Before(record.pendingUntil) {
+		record.Pending, record.pendingUntil = 0, time.Time{}
+	}
+	return record
+}
+
+func (s *MemoryStore) expired(attempts Attempts, now time.Time) bool {
+	return !attempts.LastFailure.After(now.Add(-s.window))
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.sweepAt) {
+		return
+	}
+	for key, record := range s.records {
+		if s.expired(record.Attempts, now) && !now.Before(record.pendingUntil) {
+			delete(s.records, key)
+		}
+	}
+	s.sweepAt = now.Add(time.Minute)
+}
@@ -0,0 +1,123 @@
+package loginguard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MySQLStore keeps failure records in the login_failures table, shared by
+// every replica using the database.
+type MySQLStore struct {
+	db     *sql.DB
+	window time.Duration
+}
+
+func NewMySQLStore(db *sql.DB, window time.Duration) *MySQLStore {
+	return &MySQLStore{db: db, window: window}
+}
+
+func (s *MySQLStore) Get(ctx context.Context, key string) (Attempts, error) {
+	now := time.Now()
+
+	var attempts Attempts
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT failures, last_failure_at, IF(pending_expires_at > ?, pending, 0)
+FROM login_failures WHERE key_name = ? AND expires_at > ?`,
+		now,
+		key,
+		now,
+	).Scan(&attempts.Failures, &attempts.LastFailure, &attempts.Pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, nil
+	}
+	if err != nil {
+		return Attempts{}, fmt.Errorf("load login failures: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// Reserve counts the attempt in a single upsert. A new row only lives as
+// long as the reservation; failures that have expired, and reservations
+// that have lapsed, start over.
+func (s *MySQLStore) Reserve(ctx context.Context, key string, at time.Time) (Attempts, error) {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO login_failures (key_name, failures, last_failure_at, expires_at, pending, pending_expires_at)
+VALUES (?, 0, ?, ?, 1, ?)
+ON DUPLICATE KEY UPDATE
+	failures = IF(expires_at > VALUES(last_failure_at), failures, 0),
+	pending = IF(pending_expires_at > VALUES(last_failure_at), pending + 1, 1),
+	pending_expires_at = VALUES(pending_expires_at),
+	expires_at = GREATEST(expires_at, VALUES(expires_at))`,
+		key,
+		at,
+		at.Add(reservationTTL),
+		at.Add(reservationTTL),
+	); err != nil {
+		return Attempts{}, fmt.Errorf("reserve login attempt: %w", err)
+	}
+
+	var attempts Attempts
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT failures, last_failure_at, pending FROM login_failures WHERE key_name = ?`,
+		key,
+	).Scan(&attempts.Failures, &attempts.LastFailure, &attempts.Pending); err != nil {
+		return Attempts{}, fmt.Errorf("load login failures: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (s *MySQLStore) Release(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE login_failures SET pending = IF(pending > 0, pending - 1, 0) WHERE key_name = ?`,
+		key,
+	); err != nil {
+		return fmt.Errorf("release login attempt: %w", err)
+	}
+	return nil
+}
+
+// Fail counts the failure and settles the attempt in a single upsert,
+// starting over when the old record has expired. The assignments run in
+// order, so failures still sees the old expiry.
+func (s *MySQLStore) Fail(ctx context.Context, key string, at time.Time) (Attempts, error) {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO login_failures (key_name, failures, last_failure_at, expires_at) VALUES (?, 1, ?, ?)
+ON DUPLICATE KEY UPDATE
+	failures = IF(expires_at > VALUES(last_failure_at), failures + 1, 1),
+	last_failure_at = VALUES(last_failure_at),
+	expires_at = VALUES(expires_at),
+	pending = IF(pending > 0, pending - 1, 0)`,
+		key,
+		at,
+		at.Add(s.window),
+	); err != nil {
+		return Attempts{}, fmt.Errorf("record login failure: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM login_failures WHERE expires_at < ? LIMIT 100`,
+		at,
+	); err != nil {
+		return Attempts{}, fmt.Errorf("prune login failures: %w", err)
+	}
+
+	return s.Get(ctx, key)
+}
+
+func (s *MySQLStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_failures WHERE key_name = ?`, key); err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
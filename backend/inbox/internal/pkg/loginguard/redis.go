@@ -0,0 +1,126 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// releaseScript takes one attempt off a pending counter, deleting it at zero
+// so a stray release cannot leave it negative.
+const releaseScript = `local n = redis.call('DECR', KEYS[1])
+if n <= 0 then redis.call('DEL', KEYS[1]) end
+return n`
+
+// RedisStore keeps each failure record in a hash that expires with the
+// window, and the attempts in flight in a counter beside it that expires
+// with the last reservation. Both are shared by every replica using the
+// same Redis.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+	window time.Duration
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string, window time.Duration) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix, window: window}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (Attempts, error) {
+	var (
+		fields  *redis.MapStringStringCmd
+		pending *redis.StringCmd
+	)
+	if _, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, s.prefix+key)
+		pending = pipe.Get(ctx, s.pendingKey(key))
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return Attempts{}, fmt.Errorf("load login failures: %w", err)
+	}
+
+	attempts := parseAttempts(fields.Val())
+	attempts.Pending, _ = strconv.Atoi(pending.Val())
+	return attempts, nil
+}
+
+// Reserve counts the attempt and reads the failures in one transaction.
+func (s *RedisStore) Reserve(ctx context.Context, key string, _ time.Time) (Attempts, error) {
+	var (
+		pending *redis.IntCmd
+		fields  *redis.MapStringStringCmd
+	)
+	if _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pending = pipe.Incr(ctx, s.pendingKey(key))
+		pipe.PExpire(ctx, s.pendingKey(key), reservationTTL)
+		fields = pipe.HGetAll(ctx, s.prefix+key)
+		return nil
+	}); err != nil {
+		return Attempts{}, fmt.Errorf("reserve login attempt: %w", err)
+	}
+
+	attempts := parseAttempts(fields.Val())
+	attempts.Pending = int(pending.Val())
+	return attempts, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	if err := s.client.Eval(ctx, releaseScript, []string{s.pendingKey(key)}).Err(); err != nil {
+		return fmt.Errorf("release login attempt: %w", err)
+	}
+	return nil
+}
+
+// Fail increments the counter, moves the expiry and settles the attempt in
+// one transaction; an expired hash is gone, so counting starts over by
+// itself.
+func (s *RedisStore) Fail(ctx context.Context, key string, at time.Time) (Attempts, error) {
+	redisKey := s.prefix + key
+
+	var (
+		failures *redis.IntCmd
+		pending  *redis.Cmd
+	)
+	if _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.HIncrBy(ctx, redisKey, "failures", 1)
+		pipe.HSet(ctx, redisKey, "last", at.UnixMilli())
+		pipe.PExpire(ctx, redisKey, s.window)
+		pending = pipe.Eval(ctx, releaseScript, []string{s.pendingKey(key)})
+		return nil
+	}); err != nil {
+		return Attempts{}, fmt.Errorf("record login failure: %w", err)
+	}
+
+	left, _ := pending.Int()
+	if left < 0 {
+		left = 0
+	}
+	return Attempts{Failures: int(failures.Val()), LastFailure: at, Pending: left}, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.prefix+key, s.pendingKey(key)).Err(); err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
+
+// pendingKey names the counter of attempts in flight. The hash tag puts it
+// in the record's cluster slot, so both can change in one transaction.
+func (s *RedisStore) pendingKey(key string) string {
+	return "{" + s.prefix + key + "}:pending"
+}
+
+func parseAttempts(fields map[string]string) Attempts {
+	failures, _ := strconv.Atoi(fields["failures"])
+	last, _ := strconv.ParseInt(fields["last"], 10, 64)
+	if failures == 0 {
+		return Attempts{}
+	}
+
+	return Attempts{Failures: failures, LastFailure: time.UnixMilli(last)}
+}
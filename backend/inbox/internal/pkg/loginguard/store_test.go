@@ -0,0 +1,251 @@
+package loginguard
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testStores returns the stores that keep their records in the process or
+// in Redis, each with a way to let time pass.
+func testStores(t *testing.T, window time.Duration) map[string]func(t *testing.T) (Store, func(time.Duration)) {
+	return map[string]func(t *testing.T) (Store, func(time.Duration)){
+		"memory": func(t *testing.T) (Store, func(time.Duration)) {
+			// The memory store judges expiry by the times it is given, so
+			// time passes by failing and reserving in the past.
+			return NewMemoryStore(window), nil
+		},
+		"redis": func(t *testing.T) (Store, func(time.Duration)) {
+			srv := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+			return NewRedisStore(client, "login:test:", window), srv.FastForward
+		},
+	}
+}
+
+func TestStoreCountsAndSettles(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range testStores(t, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore(t)
+			now := time.Now()
+
+			for want := 1; want <= 2; want++ {
+				attempts, err := store.Reserve(ctx, "user:alice", now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if attempts.Pending != want {
+					t.Fatalf("pending = %d, want %d", attempts.Pending, want)
+				}
+			}
+
+			attempts, err := store.Fail(ctx, "user:alice", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != 1 || attempts.Pending != 1 {
+				t.Fatalf("after fail: %+v", attempts)
+			}
+			if !attempts.LastFailure.Equal(now.Truncate(time.Millisecond)) && !attempts.LastFailure.Equal(now) {
+				t.Fatalf("last failure = %v, want %v", attempts.LastFailure, now)
+			}
+
+			// Releasing more than was reserved leaves no negative count for
+			// later reservations to hide behind.
+			for i := 0; i < 3; i++ {
+				if err := store.Release(ctx, "user:alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if attempts, err = store.Get(ctx, "user:alice"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != 1 || attempts.Pending != 0 {
+				t.Fatalf("after release: %+v", attempts)
+			}
+			if attempts, err = store.Reserve(ctx, "user:alice", now); err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Pending != 1 {
+				t.Fatalf("pending after over-release = %d, want 1", attempts.Pending)
+			}
+
+			if err := store.Reset(ctx, "user:alice"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts, err = store.Get(ctx, "user:alice"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != (Attempts{}) {
+				t.Fatalf("after reset: %+v", attempts)
+			}
+
+			if attempts, err = store.Get(ctx, "user:nobody"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != (Attempts{}) {
+				t.Fatalf("unknown key: %+v", attempts)
+			}
+		})
+	}
+}
+
+func TestStoreForgets(t *testing.T) {
+	ctx := context.Background()
+	const window = time.Hour
+	for name, newStore := range testStores(t, window) {
+		t.Run(name, func(t *testing.T) {
+			store, fastForward := newStore(t)
+			at := time.Now()
+			if fastForward == nil {
+				at = at.Add(-window - time.Second)
+			}
+
+			if _, err := store.Reserve(ctx, "user:alice", at); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Fail(ctx, "user:alice", at); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Reserve(ctx, "user:alice", at); err != nil {
+				t.Fatal(err)
+			}
+			if fastForward != nil {
+				fastForward(window + time.Second)
+			}
+
+			// The failure is past the window and the reservation was never
+			// settled; neither counts any more.
+			attempts, err := store.Get(ctx, "user:alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != 0 || attempts.Pending != 0 {
+				t.Fatalf("expired record: %+v", attempts)
+			}
+
+			attempts, err = store.Fail(ctx, "user:alice", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != 1 {
+				t.Fatalf("failures after expiry = %d, want 1", attempts.Failures)
+			}
+		})
+	}
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := NewRedisStore(client, "login:", time.Hour)
+
+	if _, err := store.Reserve(ctx, "user:alice", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := srv.TTL("{login:user:alice}:pending"); ttl != reservationTTL {
+		t.Fatalf("reservation ttl = %v, want %v", ttl, reservationTTL)
+	}
+
+	if _, err := store.Fail(ctx, "user:alice", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := srv.TTL("login:user:alice"); ttl != time.Hour {
+		t.Fatalf("record ttl = %v, want the window", ttl)
+	}
+	if srv.Exists("{login:user:alice}:pending") {
+		t.Fatal("settled reservation counter left behind")
+	}
+}
+
+// timeNear matches a time within a second of want.
+type timeNear struct{ want time.Time }
+
+func (m timeNear) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Sub(m.want).Abs() < time.Second
+}
+
+func TestMySQLStoreFailUpsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const window = time.Hour
+	store := NewMySQLStore(db, window)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// The failure count only carries on while the old record is unexpired;
+	// failures is assigned before expires_at moves, and the expiry is set
+	// one window past this failure.
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO login_failures (key_name, failures, last_failure_at, expires_at) VALUES (?, 1, ?, ?)")+
+		".*"+regexp.QuoteMeta("failures = IF(expires_at > VALUES(last_failure_at), failures + 1, 1)")+
+		".*"+regexp.QuoteMeta("expires_at = VALUES(expires_at)")+
+		".*"+regexp.QuoteMeta("pending = IF(pending > 0, pending - 1, 0)")).
+		WithArgs("user:alice", at, at.Add(window)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_failures WHERE expires_at < ? LIMIT 100")).
+		WithArgs(at).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM login_failures WHERE key_name = ? AND expires_at > ?")).
+		WithArgs(timeNear{time.Now()}, "user:alice", timeNear{time.Now()}).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failure_at", "pending"}).AddRow(3, at, 0))
+
+	attempts, err := store.Fail(context.Background(), "user:alice", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 3 || !attempts.LastFailure.Equal(at) {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMySQLStoreReserveUpsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewMySQLStore(db, time.Hour)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Expired failures and lapsed reservations start over; a new row lives
+	// only as long as its reservation, and an older record keeps its
+	// longer expiry.
+	mock.ExpectExec(regexp.QuoteMeta("VALUES (?, 0, ?, ?, 1, ?)")+
+		".*"+regexp.QuoteMeta("failures = IF(expires_at > VALUES(last_failure_at), failures, 0)")+
+		".*"+regexp.QuoteMeta("pending = IF(pending_expires_at > VALUES(last_failure_at), pending + 1, 1)")+
+		".*"+regexp.QuoteMeta("expires_at = GREATEST(expires_at, VALUES(expires_at))")).
+		WithArgs("user:alice", at, at.Add(reservationTTL), at.Add(reservationTTL)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT failures, last_failure_at, pending FROM login_failures WHERE key_name = ?")).
+		WithArgs("user:alice").
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failure_at", "pending"}).AddRow(2, at, 3))
+
+	attempts, err := store.Reserve(context.Background(), "user:alice", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 2 || attempts.Pending != 3 {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
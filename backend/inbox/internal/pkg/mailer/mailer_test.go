@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail", "outbox.eml")
+	m := NewFileMailer("noreply@example.com", path)
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to, Subject: "reset", Body: "link for " + to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("mode = %o, want 600", perm)
+	}
+
+	content := string(raw)
+	if n := strings.Count(content, "From: noreply@example.com\r\n"); n != 2 {
+		t.Fatalf("%d mails in file, want 2", n)
+	}
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if !strings.Contains(content, "To: "+to+"\r\n") {
+			t.Fatalf("no mail to %s", to)
+		}
+		if !strings.Contains(content, base64.StdEncoding.EncodeToString([]byte("link for "+to))) {
+			t.Fatalf("no body for %s", to)
+		}
+	}
+}
+
+func TestRedactTokens(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{
+			body: "open https://example.com/verify-email?token=abc%2Bdef\n\nthanks",
+			want: "open https://example.com/verify-email?token=REDACTED\n\nthanks",
+		},
+		{
+			body: "https://example.com/reset-password?lang=zh&token=abc&x=1",
+			want: "https://example.com/reset-password?lang=zh&token=REDACTED&x=1",
+		},
+		{
+			body: "no links, no token=here",
+			want: "no links, no token=here",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := redactTokens(tt.body); got != tt.want {
+			t.Errorf("redactTokens(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
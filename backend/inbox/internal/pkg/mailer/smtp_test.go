@@ -0,0 +1,181 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an in-process SMTP server that accepts everything and records
+// the commands and the message it was given. It never offers STARTTLS.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	defer close(s.done)
+
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			_ = tp.PrintfLine("250-localhost")
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_ = tp.PrintfLine("235 accepted")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+// wait returns the commands and the message once the client hung up.
+func (s *fakeSMTP) wait(t *testing.T) ([]string, string) {
+	t.Helper()
+
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp session did not end")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands, s.data
+}
+
+func (s *fakeSMTP) config() SMTPConfig {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "noreply@example.com"}
+}
+
+func TestSMTPMailerRefusesPlainAuthWithoutSTARTTLS(t *testing.T) {
+	server := startFakeSMTP(t)
+	cfg := server.config()
+	cfg.Username, cfg.Password = "user", "secret"
+
+	err := NewSMTPMailer(cfg).Send(context.Background(), Message{To: "alice@example.com", Subject: "hi", Body: "hello"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v", err)
+	}
+
+	commands, data := server.wait(t)
+	for _, cmd := range commands {
+		if strings.HasPrefix(strings.ToUpper(cmd), "AUTH") || strings.Contains(cmd, base64.StdEncoding.EncodeToString([]byte("secret"))) {
+			t.Fatalf("credentials were sent: %q", cmd)
+		}
+	}
+	if data != "" {
+		t.Fatalf("message was sent: %q", data)
+	}
+}
+
+func TestSMTPMailerFramesMessage(t *testing.T) {
+	server := startFakeSMTP(t)
+
+	// Long enough to be wrapped, with a line starting with a dot, which
+	// must survive the SMTP dot-stuffing.
+	body := ".hidden\n" + strings.Repeat("验证链接 https://example.com/verify-email?token=abc ", 5)
+	err := NewSMTPMailer(server.config()).Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "验证你的邮箱",
+		Body:    body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands, data := server.wait(t)
+	wantCommands := []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<alice@example.com>", "DATA", "QUIT"}
+	if got := commands[1:]; strings.Join(got, "|") != strings.Join(wantCommands, "|") {
+		t.Fatalf("commands = %q, want EHLO then %q", commands, wantCommands)
+	}
+
+	header, encoded, found := strings.Cut(data, "\n\n")
+	if !found {
+		t.Fatalf("no blank line between header and body: %q", data)
+	}
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(header + "\n\n"))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"From":                      "noreply@example.com",
+		"To":                        "alice@example.com",
+		"Subject":                   "=?UTF-8?b?6aqM6K+B5L2g55qE6YKu566x?=",
+		"Content-Type":              "text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding": "base64",
+	} {
+		if got := msg.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(encoded, "\n"), "\n")
+	for _, line := range lines {
+		if len(line) > 76 {
+			t.Fatalf("body line of %d characters", len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body {
+		t.Fatalf("body = %q, want %q", decoded, body)
+	}
+}
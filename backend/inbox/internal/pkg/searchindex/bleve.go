@@ -0,0 +1,390 @@
+package searchindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// fuzzyMinRunes is the shortest term matched with one typo allowed.
+	// Shorter terms, and CJK text, whose bigrams would match far too much,
+	// are matched exactly.
+	fuzzyMinRunes = 4
+	titleBoost    = 2
+)
+
+// bleveDocument is the shape stored in the index. Numbers are float64
+// because that is how Bleve indexes them.
+type bleveDocument struct {
+	Kind     string    `json:"kind"`
+	Title    string    `json:"title"`
+	Content  string    `json:"content"`
+	Sender   float64   `json:"sender"`
+	Parties  []float64 `json:"parties"`
+	Audience string    `json:"audience"`
+	Priority string    `json:"priority"`
+	Created  string    `json:"created"`
+}
+
+// BleveIndex is an embedded on-disk SearchIndex. Title and content use
+// Bleve's CJK analyzer, which splits Chinese into bigrams and keeps other
+// scripts as words. The index belongs to one process: every replica that
+// serves search needs its own, fed by its own writes.
+type BleveIndex struct {
+	index bleve.Index
+}
+
+// ErrIndexInUse is returned by RebuildBleve while another process, such as
+// a running server, has the index open.
+var ErrIndexInUse = errors.New("search index is in use; stop the server first")
+
+// OpenBleve opens the index at path, creating it if it does not exist. It
+// refuses an index that is being rebuilt, which is about to be replaced.
+func OpenBleve(path string) (*BleveIndex, error) {
+	if _, err := os.Stat(lockPath(path)); err == nil {
+		return nil, fmt.Errorf("search index %s is being rebuilt; remove %s if no rebuild is running",
+			path, lockPath(path))
+	}
+
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, newIndexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open search index %s: %w", path, err)
+	}
+
+	return &BleveIndex{index: index}, nil
+}
+
+// RebuildBleve builds a new index beside path, has build fill it, and only
+// then swaps it in for the index at path, so a failed or interrupted rebuild
+// leaves the current index as it was. A lock file keeps two rebuilds, or a
+// rebuild and a starting server, apart.
+func RebuildBleve(path string, build func(*BleveIndex) error) error {
+	lock, err := os.OpenFile(lockPath(path), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("search index %s is already being rebuilt; remove %s if no rebuild is running",
+			path, lockPath(path))
+	}
+	if err != nil {
+		return fmt.Errorf("lock search index %s: %w", path, err)
+	}
+	lock.Close()
+	defer os.Remove(lockPath(path))
+
+	if err := checkNotInUse(path); err != nil {
+		return err
+	}
+
+	building := path + ".rebuild"
+	if err := os.RemoveAll(building); err != nil {
+		return fmt.Errorf("remove unfinished search index %s: %w", building, err)
+	}
+	index, err := bleve.New(building, newIndexMapping())
+	if err != nil {
+		return fmt.Errorf("create search index %s: %w", building, err)
+	}
+
+	b := &BleveIndex{index: index}
+	err = build(b)
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.RemoveAll(building)
+		return err
+	}
+
+	return swapDir(building, path)
+}
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// checkNotInUse opens the index at path with a short wait for its lock. An
+// index that cannot be opened for any other reason is left to be replaced.
+func checkNotInUse(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	index, err := bleve.OpenUsing(path, map[string]interface{}{"bolt_timeout": "1s"})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("%w: %s", ErrIndexInUse, path)
+	}
+	if err == nil {
+		return index.Close()
+	}
+
+	return nil
+}
+
+// swapDir replaces path with next. The old directory is moved aside first,
+// because a rename cannot replace a non-empty directory, and put back if
+// next cannot take its place.
+func swapDir(next, path string) error {
+	old := path + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("remove old search index %s: %w", old, err)
+	}
+
+	hadOld := true
+	if err := os.Rename(path, old); errors.Is(err, fs.ErrNotExist) {
+		hadOld = false
+	} else if err != nil {
+		return fmt.Errorf("move search index %s aside: %w", path, err)
+	}
+
+	if err := os.Rename(next, path); err != nil {
+		if hadOld {
+			_ = os.Rename(old, path)
+		}
+		return fmt.Errorf("move search index %s into place: %w", next, err)
+	}
+
+	if hadOld {
+		if err := os.RemoveAll(old); err != nil {
+			return fmt.Errorf("remove old search index %s: %w", old, err)
+		}
+	}
+
+	return nil
+}
+
+func newIndexMapping() mapping.IndexMapping {
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = cjk.AnalyzerName
+	text.Store = false
+	text.IncludeTermVectors = true
+
+	keywordField := bleve.NewKeywordFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.Store = false
+
+	number := bleve.NewNumericFieldMapping()
+	number.Store = false
+
+	created := bleve.NewDateTimeFieldMapping()
+	created.Store = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("kind", keywordField)
+	doc.AddFieldMappingsAt("title", text)
+	doc.AddFieldMappingsAt("content", text)
+	doc.AddFieldMappingsAt("sender", number)
+	doc.AddFieldMappingsAt("parties", number)
+	doc.AddFieldMappingsAt("audience", keywordField)
+	doc.AddFieldMappingsAt("priority", keywordField)
+	doc.AddFieldMappingsAt("created", created)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = cjk.AnalyzerName
+
+	return m
+}
+
+func (b *BleveIndex) Index(_ context.Context, docs ...Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	batch := b.index.NewBatch()
+	for _, doc := range docs {
+		parties := make([]float64, len(doc.Parties))
+		for i, party := range doc.Parties {
+			parties[i] = float64(party)
+		}
+
+		if err := batch.Index(DocumentID(doc.Kind, doc.ID), bleveDocument{
+			Kind:     doc.Kind,
+			Title:    doc.Title,
+			Content:  doc.Content,
+			Sender:   float64(doc.SenderID),
+			Parties:  parties,
+			Audience: doc.Audience,
+			Priority: doc.Priority,
+			Created:  doc.CreatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return fmt.Errorf("index %s: %w", DocumentID(doc.Kind, doc.ID), err)
+		}
+	}
+
+	if err := b.index.Batch(batch); err != nil {
+		return fmt.Errorf("write search index batch: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BleveIndex) Delete(_ context.Context, kind string, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	batch := b.index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(DocumentID(kind, id))
+	}
+
+	if err := b.index.Batch(batch); err != nil {
+		return fmt.Errorf("delete from search index: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BleveIndex) Search(ctx context.Context, q Query) (*Result, error) {
+	req := bleve.NewSearchRequestOptions(buildQuery(q), q.Size, q.From, false)
+	req.IncludeLocations = true
+	req.SortBy([]string{"-_score", "-created"})
+
+	res, err := b.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("search index: %w", err)
+	}
+
+	result := &Result{Total: res.Total}
+	for _, match := range res.Hits {
+		kind, id, ok := parseDocumentID(match.ID)
+		if !ok {
+			continue
+		}
+		result.Hits = append(result.Hits, Hit{
+			Kind:    kind,
+			ID:      id,
+			Score:   match.Score,
+			Matches: matchRanges(match.Locations),
+		})
+	}
+
+	return result, nil
+}
+
+func (b *BleveIndex) Close() error {
+	return b.index.Close()
+}
+
+// buildQuery requires every whitespace separated term in the title or the
+// content, and applies the filters. Visibility is part of the query rather
+// than checked afterwards, so ranking and paging only see the user's items.
+func buildQuery(q Query) query.Query {
+	conjuncts := []query.Query{visibilityQuery(q)}
+
+	for _, term := range strings.Fields(q.Text) {
+		title := textQuery("title", term)
+		title.SetBoost(titleBoost)
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(title, textQuery("content", term)))
+	}
+
+	if q.SenderID > 0 {
+		conjuncts = append(conjuncts, numberQuery("sender", q.SenderID))
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		inclusive, exclusive := true, false
+		created := bleve.NewDateRangeInclusiveQuery(q.Since, q.Until, &inclusive, &exclusive)
+		created.SetField("created")
+		conjuncts = append(conjuncts, created)
+	}
+	if q.Priority != "" {
+		priority := bleve.NewTermQuery(q.Priority)
+		priority.SetField("priority")
+		conjuncts = append(conjuncts, priority)
+	}
+
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+func visibilityQuery(q Query) query.Query {
+	kinds := q.Kinds
+	if len(kinds) == 0 {
+		kinds = []string{KindPersonal, KindNotification}
+	}
+
+	var disjuncts []query.Query
+	for _, kind := range kinds {
+		kindQuery := bleve.NewTermQuery(kind)
+		kindQuery.SetField("kind")
+
+		if kind == KindPersonal {
+			disjuncts = append(disjuncts, bleve.NewConjunctionQuery(kindQuery, numberQuery("parties", q.UserID)))
+			continue
+		}
+
+		global := bleve.NewTermQuery(AudienceGlobal)
+		global.SetField("audience")
+		disjuncts = append(disjuncts, bleve.NewConjunctionQuery(kindQuery,
+			bleve.NewDisjunctionQuery(global, numberQuery("parties", q.UserID))))
+	}
+
+	return bleve.NewDisjunctionQuery(disjuncts...)
+}
+
+func textQuery(field, term string) *query.MatchQuery {
+	match := bleve.NewMatchQuery(term)
+	match.SetField(field)
+	match.SetOperator(query.MatchQueryOperatorAnd)
+	if fuzzy(term) {
+		match.SetFuzziness(1)
+	}
+	return match
+}
+
+func numberQuery(field string, value int64) query.Query {
+	v, inclusive := float64(value), true
+	q := bleve.NewNumericRangeInclusiveQuery(&v, &v, &inclusive, &inclusive)
+	q.SetField(field)
+	return q
+}
+
+func fuzzy(term string) bool {
+	runes := 0
+	for _, r := range term {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			return false
+		}
+		runes++
+	}
+	return runes >= fuzzyMinRunes
+}
+
+// matchRanges flattens Bleve's term locations in the text fields into sorted
+// byte ranges.
+func matchRanges(locations search.FieldTermLocationMap) map[string][][2]int {
+	matches := make(map[string][][2]int, 2)
+	for _, field := range []string{"title", "content"} {
+		terms := locations[field]
+		if len(terms) == 0 {
+			continue
+		}
+
+		var ranges [][2]int
+		for _, locs := range terms {
+			for _, loc := range locs {
+				ranges = append(ranges, [2]int{int(loc.Start), int(loc.End)})
+			}
+		}
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+		matches[field] = ranges
+	}
+
+	return matches
+}
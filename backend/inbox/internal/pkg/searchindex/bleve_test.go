@@ -0,0 +1,178 @@
+package searchindex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBleveSearchFiltersByVisibility(t *testing.T) {
+	index, err := OpenBleve(filepath.Join(t.TempDir(), "search.bleve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []Document{
+		{Kind: KindPersonal, ID: 1, Title: "budget", Content: "numbers", SenderID: 1, Parties: []int64{1, 2}},
+		{Kind: KindPersonal, ID: 2, Title: "budget", Content: "private", SenderID: 3, Parties: []int64{3, 4}},
+		{Kind: KindNotification, ID: 1, Title: "budget", Content: "for two", Parties: []int64{2}, Audience: "users"},
+		{Kind: KindNotification, ID: 2, Title: "budget", Content: "for four", Parties: []int64{4}, Audience: "all"},
+		{Kind: KindNotification, ID: 3, Title: "budget", Content: "for everyone", Audience: AudienceGlobal},
+	}
+	for i := range docs {
+		docs[i].CreatedAt = created.Add(time.Duration(i) * time.Minute)
+	}
+	if err := index.Index(context.Background(), docs...); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+		total uint64
+	}{
+		{
+			name:  "only the user's items",
+			query: Query{Text: "budget", UserID: 2, Size: 10},
+			want:  []string{"notification:1", "notification:3", "personal:1"},
+			total: 3,
+		},
+		{
+			name:  "another user",
+			query: Query{Text: "budget", UserID: 4, Size: 10},
+			want:  []string{"notification:2", "notification:3", "personal:2"},
+			total: 3,
+		},
+		{
+			name:  "kinds",
+			query: Query{Text: "budget", UserID: 2, Kinds: []string{KindNotification}, Size: 10},
+			want:  []string{"notification:1", "notification:3"},
+			total: 2,
+		},
+		{
+			name:  "pages are cut after filtering",
+			query: Query{Text: "budget", UserID: 2, From: 2, Size: 2},
+			total: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := index.Search(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Total != tt.total {
+				t.Fatalf("total = %d, want %d", res.Total, tt.total)
+			}
+
+			var got []string
+			for _, hit := range res.Hits {
+				got = append(got, DocumentID(hit.Kind, hit.ID))
+			}
+			if tt.want == nil {
+				if len(got) != int(tt.total)-tt.query.From {
+					t.Fatalf("hits = %v", got)
+				}
+				return
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("hits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebuildBleve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.bleve")
+	doc := func(id int64) Document {
+		return Document{Kind: KindPersonal, ID: id, Title: "hello", Parties: []int64{1}, CreatedAt: time.Now()}
+	}
+	search := func(t *testing.T) uint64 {
+		t.Helper()
+		index, err := OpenBleve(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer index.Close()
+		res, err := index.Search(context.Background(), Query{Text: "hello", UserID: 1, Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Total
+	}
+
+	if err := RebuildBleve(path, func(index *BleveIndex) error {
+		return index.Index(context.Background(), doc(1), doc(2))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(t); got != 2 {
+		t.Fatalf("after rebuild total = %d, want 2", got)
+	}
+
+	t.Run("failed build keeps the current index", func(t *testing.T) {
+		boom := errors.New("boom")
+		err := RebuildBleve(path, func(index *BleveIndex) error {
+			if err := index.Index(context.Background(), doc(3)); err != nil {
+				return err
+			}
+			return boom
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("err = %v", err)
+		}
+		if got := search(t); got != 2 {
+			t.Fatalf("total = %d, want 2", got)
+		}
+		if _, err := os.Stat(path + ".rebuild"); !os.IsNotExist(err) {
+			t.Fatalf("unfinished index left behind: %v", err)
+		}
+	})
+
+	t.Run("open index is refused", func(t *testing.T) {
+		index, err := OpenBleve(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer index.Close()
+
+		err = RebuildBleve(path, func(*BleveIndex) error {
+			t.Fatal("build ran while the index was open")
+			return nil
+		})
+		if !errors.Is(err, ErrIndexInUse) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("lock keeps rebuilds and servers apart", func(t *testing.T) {
+		err := RebuildBleve(path, func(*BleveIndex) error {
+			if err := RebuildBleve(path, func(*BleveIndex) error { return nil }); err == nil {
+				t.Error("second rebuild was not refused")
+			}
+			if _, err := OpenBleve(path); err == nil {
+				t.Error("index opened during a rebuild")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Fatalf("lock left behind: %v", err)
+		}
+		if got := search(t); got != 0 {
+			t.Fatalf("total = %d, want the empty rebuild", got)
+		}
+	})
+}
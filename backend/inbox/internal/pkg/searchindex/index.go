@@ -0,0 +1,94 @@
+package searchindex
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KindPersonal     = "personal"
+	KindNotification = "notification"
+	// AudienceGlobal marks a notification every user sees without a receipt.
+	AudienceGlobal = "global"
+)
+
+// Document is one searchable item. A personal message is indexed once and
+// lists the parties that can still see it; a system notification is indexed
+// once no matter how many receipts it has, and lists the users holding one
+// unless its audience is global, which every user sees.
+type Document struct {
+	Kind      string
+	ID        int64
+	Title     string
+	Content   string
+	SenderID  int64
+	Parties   []int64
+	Audience  string
+	Priority  string
+	CreatedAt time.Time
+}
+
+// Query is a search for the documents containing every term of Text. Zero
+// values leave a filter unset.
+type Query struct {
+	Text string
+	// UserID restricts the results to what the user may see: personal
+	// messages they are a party to, notifications they hold a receipt for
+	// and global notifications.
+	UserID   int64
+	Kinds    []string
+	SenderID int64
+	Since    time.Time
+	Until    time.Time
+	Priority string
+	From     int
+	Size     int
+}
+
+type Hit struct {
+	Kind  string
+	ID    int64
+	Score float64
+	// Matches holds the byte ranges of the matched text by field, title or
+	// content, so callers can highlight what the engine matched, fuzzy
+	// matches included.
+	Matches map[string][][2]int
+}
+
+type Result struct {
+	// Total counts every hit, not only those from From to From+Size.
+	Total uint64
+	Hits  []Hit
+}
+
+// SearchIndex is a full-text index of inbox content kept beside MySQL. It
+// is fed from the write path, which treats it as best effort, and can be
+// rebuilt from the tables at any time.
+type SearchIndex interface {
+	// Index adds or replaces documents.
+	Index(ctx context.Context, docs ...Document) error
+	Delete(ctx context.Context, kind string, ids ...int64) error
+	Search(ctx context.Context, q Query) (*Result, error)
+	Close() error
+}
+
+// DocumentID is the key of a document within an index.
+func DocumentID(kind string, id int64) string {
+	return kind + ":" + strconv.FormatInt(id, 10)
+}
+
+func parseDocumentID(docID string) (string, int64, bool) {
+	kind, rawID, found := strings.Cut(docID, ":")
+	if !found {
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return kind, id, true
+}
@@ -0,0 +1,229 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown,
+// expired, revoked or has already been rotated.
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+
+type Session struct {
+	ID     string
+	UserID int64
+}
+
+// Store persists login sessions and their rotating refresh tokens. Only the
+// SHA-256 of a refresh token is stored. Each session remembers the token it
+// replaced so that replaying a rotated token revokes the whole session.
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create opens a new session for the user and returns it together with the
+// first refresh token.
+func (s *Store) Create(ctx context.Context, userID int64, ttl time.Duration) (*Session, string, error) {
+	sessionID, err := RandomID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	refreshToken, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO auth_sessions (id, user_id, refresh_hash, expires_at) VALUES (?, ?, ?, ?)`,
+		sessionID,
+		userID,
+		HashToken(refreshToken),
+		time.Now().Add(ttl),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("insert session: %w", err)
+	}
+
+	return &Session{ID: sessionID, UserID: userID}, refreshToken, nil
+}
+
+// Rotate exchanges a refresh token for a new one on the same session.
+func (s *Store) Rotate(ctx context.Context, refreshToken string, ttl time.Duration) (*Session, string, error) {
+	hash := HashToken(refreshToken)
+
+	var (
+		sess      Session
+		expiresAt time.Time
+		revokedAt sql.NullTime
+	)
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT id, user_id, expires_at, revoked_at FROM auth_sessions WHERE refresh_hash = ?`,
+		hash,
+	).Scan(&sess.ID, &sess.UserID, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", s.handleReuse(ctx, hash)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("query session: %w", err)
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	next, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE auth_sessions SET refresh_hash = ?, previous_hash = ?, expires_at = ?
+WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL`,
+		HashToken(next),
+		hash,
+		time.Now().Add(ttl),
+		sess.ID,
+		hash,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("rotate refresh token: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, "", fmt.Errorf("rotate rows affected: %w", err)
+	}
+
+	// A concurrent refresh won the race with the same token.
+	if affected == 0 {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	return &sess, next, nil
+}
+
+// handleReuse revokes a session whose already-rotated refresh token has been
+// presented again, since either the client or an attacker holds a stale copy.
+func (s *Store) handleReuse(ctx context.Context, hash string) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE auth_sessions SET revoked_at = ? WHERE previous_hash = ? AND revoked_at IS NULL`,
+		time.Now(),
+		hash,
+	); err != nil {
+		return fmt.Errorf("revoke reused session: %w", err)
+	}
+
+	return ErrInvalidRefreshToken
+}
+
+func (s *Store) Revoke(ctx context.Context, sessionID string) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE auth_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`,
+		time.Now(),
+		sessionID,
+	); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes every open session of the user.
+func (s *Store) RevokeUser(ctx context.Context, userID int64) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE auth_sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		time.Now(),
+		userID,
+	); err != nil {
+		return fmt.Errorf("revoke user sessions: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken denylists a single access token until it would have expired
+// anyway. Expired entries are pruned on the way.
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`,
+		tokenID,
+		expiresAt,
+	); err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM revoked_tokens WHERE expires_at < ? LIMIT 100`,
+		time.Now(),
+	); err != nil {
+		return fmt.Errorf("prune revoked tokens: %w", err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the access token or its session has been
+// revoked. Unknown sessions count as revoked.
+func (s *Store) IsRevoked(ctx context.Context, sessionID, tokenID string) (bool, error) {
+	var active, denied bool
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT
+	EXISTS(SELECT 1 FROM auth_sessions WHERE id = ? AND revoked_at IS NULL),
+	EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)`,
+		sessionID,
+		tokenID,
+	).Scan(&active, &denied)
+	if err != nil {
+		return false, fmt.Errorf("check revocation: %w", err)
+	}
+
+	return !active || denied, nil
+}
+
+// RandomID returns a 128-bit random hex identifier, used for session ids and
+// token ids.
+func RandomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// RandomToken returns a 256-bit random URL-safe secret, used for refresh
+// tokens and the one-time tokens sent by mail.
+func RandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken is the form in which secret tokens are stored, so a leaked table
+// does not hand out working tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRotate(t *testing.T) {
+	const token = "refresh-token"
+	hash := HashToken(token)
+	selectSession := regexp.QuoteMeta(`SELECT id, user_id, expires_at, revoked_at FROM auth_sessions WHERE refresh_hash = ?`)
+	rotate := regexp.QuoteMeta(`UPDATE auth_sessions SET refresh_hash = ?, previous_hash = ?, expires_at = ?`)
+	columns := []string{"id", "user_id", "expires_at", "revoked_at"}
+
+	tests := []struct {
+		name    string
+		expect  func(mock sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "current token rotates",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectSession).WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("s1", 7, time.Now().Add(time.Hour), nil))
+				mock.ExpectExec(rotate).
+					WithArgs(sqlmock.AnyArg(), hash, sqlmock.AnyArg(), "s1", hash).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "rotated token revokes the session",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectSession).WithArgs(hash).WillReturnError(sql.ErrNoRows)
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE auth_sessions SET revoked_at = ? WHERE previous_hash = ? AND revoked_at IS NULL`)).
+					WithArgs(sqlmock.AnyArg(), hash).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "revoked session",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectSession).WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("s1", 7, time.Now().Add(time.Hour), time.Now()))
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "expired session",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectSession).WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("s1", 7, time.Now().Add(-time.Second), nil))
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "concurrent rotation wins",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectSession).WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("s1", 7, time.Now().Add(time.Hour), nil))
+				mock.ExpectExec(rotate).
+					WithArgs(sqlmock.AnyArg(), hash, sqlmock.AnyArg(), "s1", hash).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.expect(mock)
+
+			sess, next, err := NewStore(db).Rotate(context.Background(), token, time.Hour)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if sess.ID != "s1" || sess.UserID != 7 {
+					t.Fatalf("session = %+v", sess)
+				}
+				if next == "" || next == token {
+					t.Fatalf("refresh token was not replaced: %q", next)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestIsRevoked(t *testing.T) {
+	tests := []struct {
+		name   string
+		active bool
+		denied bool
+		want   bool
+	}{
+		{name: "active session", active: true, want: false},
+		{name: "revoked session", active: false, want: true},
+		{name: "denylisted token", active: true, denied: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(`EXISTS`).WithArgs("s1", "jti").
+				WillReturnRows(sqlmock.NewRows([]string{"active", "denied"}).AddRow(tt.active, tt.denied))
+
+			got, err := NewStore(db).IsRevoked(context.Background(), "s1", "jti")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("IsRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedeemTicket(t *testing.T) {
+	const ticket = "stream-ticket"
+	hash := HashToken(ticket)
+	redeem := regexp.QuoteMeta(`UPDATE stream_tickets SET used_at = ? WHERE ticket_hash = ? AND used_at IS NULL AND expires_at > ?`)
+
+	tests := []struct {
+		name    string
+		expect  func(mock sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "unused ticket",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(redeem).WithArgs(sqlmock.AnyArg(), hash, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`SELECT user_id, role, session_id, token_id, token_expires_at FROM stream_tickets`).
+					WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "role", "session_id", "token_id", "token_expires_at"}).
+						AddRow(7, "user", "s1", "jti", time.Now().Add(time.Hour)))
+			},
+		},
+		{
+			name: "used, expired or unknown ticket",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(redeem).WithArgs(sqlmock.AnyArg(), hash, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantErr: ErrInvalidTicket,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.expect(mock)
+
+			got, err := NewStore(db).RedeemTicket(context.Background(), ticket)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (got.UserID != 7 || got.SessionID != "s1") {
+				t.Fatalf("ticket = %+v", got)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
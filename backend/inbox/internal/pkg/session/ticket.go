@@ -0,0 +1,96 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTicket is returned when a stream ticket is unknown, expired or
+// has already been used.
+var ErrInvalidTicket = errors.New("stream ticket is invalid")
+
+// Ticket stands in for an access token when opening an event stream, since
+// EventSource and browser WebSockets cannot send an Authorization header and
+// a token in the URL would end up in access logs. It carries what the
+// access token it was issued against authenticated.
+type Ticket struct {
+	UserID         int64
+	Role           string
+	SessionID      string
+	TokenID        string
+	TokenExpiresAt time.Time
+}
+
+// IssueTicket stores a one-time ticket for t and returns it. Only its hash
+// is kept, as with refresh tokens. Expired tickets are pruned on the way.
+func (s *Store) IssueTicket(ctx context.Context, t Ticket, ttl time.Duration) (string, error) {
+	ticket, err := RandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if _, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO stream_tickets (ticket_hash, user_id, role, session_id, token_id, token_expires_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		HashToken(ticket),
+		t.UserID,
+		t.Role,
+		t.SessionID,
+		t.TokenID,
+		t.TokenExpiresAt,
+		now.Add(ttl),
+	); err != nil {
+		return "", fmt.Errorf("insert stream ticket: %w", err)
+	}
+
+	if _, err = s.db.ExecContext(
+		ctx,
+		`DELETE FROM stream_tickets WHERE expires_at < ? LIMIT 100`,
+		now,
+	); err != nil {
+		return "", fmt.Errorf("prune stream tickets: %w", err)
+	}
+
+	return ticket, nil
+}
+
+// RedeemTicket uses up a ticket. Marking it used is a single conditional
+// update, so of two concurrent redemptions only one succeeds.
+func (s *Store) RedeemTicket(ctx context.Context, ticket string) (*Ticket, error) {
+	hash := HashToken(ticket)
+	now := time.Now()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE stream_tickets SET used_at = ? WHERE ticket_hash = ? AND used_at IS NULL AND expires_at > ?`,
+		now,
+		hash,
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("redeem stream ticket: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("redeem rows affected: %w", err)
+	}
+	if affected == 0 {
+		return nil, ErrInvalidTicket
+	}
+
+	var t Ticket
+	if err = s.db.QueryRowContext(
+		ctx,
+		`SELECT user_id, role, session_id, token_id, token_expires_at FROM stream_tickets WHERE ticket_hash = ?`,
+		hash,
+	).Scan(&t.UserID, &t.Role, &t.SessionID, &t.TokenID, &t.TokenExpiresAt); err != nil {
+		return nil, fmt.Errorf("load stream ticket: %w", err)
+	}
+
+	return &t, nil
+}
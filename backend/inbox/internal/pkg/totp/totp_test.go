@@ -0,0 +1,163 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890"
+// in ASCII.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// Appendix B lists eight digit codes; ours are their last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},          // 94287082
+		{unix: 1111111109, want: "081804"},  // 07081804
+		{unix: 1111111111, want: "050471"},  // 14050471
+		{unix: 1234567890, want: "005924"},  // 89005924
+		{unix: 2000000000, want: "279037"},  // 69279037
+		{unix: 20000000000, want: "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		got, err := Code(rfcSecret, Step(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		// Apps show secrets in either case.
+		if lower, _ := Code(strings.ToLower(rfcSecret), Step(at)); lower != tt.want {
+			t.Errorf("lower-case secret at %d = %s, want %s", tt.unix, lower, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name  string
+		delta int64
+		skew  int
+		ok    bool
+	}{
+		{name: "current step", delta: 0, skew: 0, ok: true},
+		{name: "late without skew", delta: -1, skew: 0, ok: false},
+		{name: "one step late", delta: -1, skew: 1, ok: true},
+		{name: "one step early", delta: 1, skew: 1, ok: true},
+		{name: "two steps late", delta: -2, skew: 1, ok: false},
+		{name: "two steps early", delta: 2, skew: 1, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, step+tt.delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			matched, ok := Validate(rfcSecret, code, now, tt.skew)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			// The step of the code is returned, not the current one, so
+			// callers can refuse it once a later code has been accepted.
+			if ok && matched != step+tt.delta {
+				t.Fatalf("matched step %d, want %d", matched, step+tt.delta)
+			}
+		})
+	}
+}
+
+func TestValidateReturnsStepForReplayChecks(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Step(now))
+
+	first, ok := Validate(rfcSecret, code, now, 1)
+	if !ok {
+		t.Fatal("code rejected")
+	}
+	// Seen again within the same step, the code matches the same step,
+	// which a caller that stored the first one refuses as a replay.
+	again, ok := Validate(rfcSecret, code, now.Add(10*time.Second), 1)
+	if !ok || again != first {
+		t.Fatalf("replayed code matched step %d (ok %v), want %d", again, ok, first)
+	}
+	// A fresh code from the next step is later than the stored one.
+	next, _ := Code(rfcSecret, first+1)
+	if step, ok := Validate(rfcSecret, next, now.Add(Period), 1); !ok || step <= first {
+		t.Fatalf("next code matched step %d (ok %v), want after %d", step, ok, first)
+	}
+}
+
+func TestValidateRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "287082", now, 1); ok {
+		t.Error("code accepted for a malformed secret")
+	}
+}
+
+func TestCipher(t *testing.T) {
+	const key = "c2FtcGxlLXRvdHAta2V5LWNoYW5nZS1tZS0zMmJ5dGU="
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := c.Seal(rfcSecret, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, rfcSecret) {
+		t.Fatalf("sealed %q contains the secret", sealed)
+	}
+	if again, _ := c.Seal(rfcSecret, 7); again == sealed {
+		t.Fatal("sealing twice gave the same ciphertext")
+	}
+
+	opened, err := c.Open(sealed, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != rfcSecret {
+		t.Fatalf("opened %q, want %q", opened, rfcSecret)
+	}
+
+	if _, err := c.Open(sealed, 8); err == nil {
+		t.Fatal("secret opened for another owner")
+	}
+	other, err := NewCipher("b3RoZXItdG90cC1rZXktb2YtdGhpcnR5LXR3by1ieXQ=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed, 7); err == nil {
+		t.Fatal("secret opened with another key")
+	}
+	for _, bad := range []string{rfcSecret, "v1:", "v1:!!!"} {
+		if _, err := c.Open(bad, 7); err == nil {
+			t.Errorf("Open(%q) succeeded", bad)
+		}
+	}
+
+	for _, bad := range []string{"", "c2hvcnQ=", "not base64"} {
+		if _, err := NewCipher(bad); err == nil {
+			t.Errorf("NewCipher(%q) succeeded", bad)
+		}
+	}
+}
@@ -5,18 +5,47 @@ package svc
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/pineapple/msg-demo/backend/inbox/internal/config"
 	"github.com/pineapple/msg-demo/backend/inbox/internal/middleware"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/authctx"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/background"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/eventhub"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/keyset"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/loginguard"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/mailer"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/searchindex"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/session"
+	"github.com/pineapple/msg-demo/backend/inbox/internal/pkg/totp"
+	"github.com/redis/go-redis/v9"
 )
 
 type ServiceContext struct {
 	Config         config.Config
 	DB             *sql.DB
 	AuthMiddleware *middleware.AuthMiddleware
-	AccessSecret   []byte
+	SendPolicy     *middleware.SendPolicyMiddleware
+	AdminOnly      *middleware.RoleMiddleware
+	Sessions       *session.Store
+	Keys           *keyset.KeySet
 	AccessExpire   time.Duration
+	RefreshExpire  time.Duration
+	BroadcastBatch int
+	Events         *eventhub.Hub
+	Heartbeat      time.Duration
+	TicketExpire   time.Duration
+	// Search is nil when searching with MySQL FULLTEXT.
+	Search     searchindex.SearchIndex
+	Mailer     mailer.Mailer
+	LoginGuard *loginguard.Guard
+	// TOTPCipher seals the authenticator secrets stored in users.
+	TOTPCipher *totp.Cipher
+	// Background runs work that outlives a request, such as large broadcast
+	// fan-outs, and is cancelled on shutdown.
+	Background *background.Runner
 }
 
 func NewServiceContext(c config.Config) *ServiceContext {
@@ -27,11 +56,144 @@ func NewServiceContext(c config.Config) *ServiceContext {
 		panic(err)
 	}
 
+	broadcastBatch := c.Broadcast.BatchSize
+	if broadcastBatch <= 0 {
+		broadcastBatch = 500
+	}
+
+	keys, err := keyset.New(
+		c.Auth.Keys,
+		c.Auth.AccessSecret,
+		c.Auth.SigningKey,
+		time.Duration(c.Auth.RetiredKeyGrace)*time.Second,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	sessions := session.NewStore(sqlDB)
+
+	heartbeat := time.Duration(c.Events.Heartbeat) * time.Second
+	if heartbeat <= 0 {
+		heartbeat = 25 * time.Second
+	}
+
+	ticketExpire := time.Duration(c.Events.TicketExpire) * time.Second
+	if ticketExpire <= 0 {
+		ticketExpire = 30 * time.Second
+	}
+
+	events, err := eventhub.New(eventhub.Config{
+		BufferSize:  c.Events.BufferSize,
+		HistorySize: c.Events.HistorySize,
+		Retention:   time.Duration(c.Events.Retention) * time.Second,
+	}, newEventBroker(c))
+	if err != nil {
+		panic(err)
+	}
+
+	totpCipher, err := totp.NewCipher(c.TwoFactor.SecretKey)
+	if err != nil {
+		panic(fmt.Errorf("TwoFactor.SecretKey: %w", err))
+	}
+
+	var search searchindex.SearchIndex
+	if c.Search.Engine == "bleve" {
+		// Each replica would only index its own writes, so search results
+		// would depend on which replica answered.
+		if c.Events.Broker == "redis" {
+			panic(errors.New("Search.Engine bleve keeps a per-process index and cannot be used with Events.Broker redis"))
+		}
+		if search, err = searchindex.OpenBleve(c.Search.Path); err != nil {
+			panic(err)
+		}
+	}
+
 	return &ServiceContext{
 		Config:         c,
 		DB:             sqlDB,
-		AuthMiddleware: middleware.NewAuthMiddleware(c.Auth.AccessSecret),
-		AccessSecret:   []byte(c.Auth.AccessSecret),
+		AuthMiddleware: middleware.NewAuthMiddleware(keys, sessions, sessions),
+		SendPolicy:     middleware.NewSendPolicyMiddleware(),
+		AdminOnly:      middleware.NewRoleMiddleware(authctx.RoleAdmin),
+		Keys:           keys,
+		Sessions:       sessions,
 		AccessExpire:   time.Duration(c.Auth.AccessExpire) * time.Second,
+		RefreshExpire:  time.Duration(c.Auth.RefreshExpire) * time.Second,
+		BroadcastBatch: broadcastBatch,
+		Events:         events,
+		Heartbeat:      heartbeat,
+		TicketExpire:   ticketExpire,
+		Search:         search,
+		Mailer:         newMailer(c),
+		LoginGuard:     newLoginGuard(c, sqlDB),
+		TOTPCipher:     totpCipher,
+		Background:     background.NewRunner(),
+	}
+}
+
+func newEventBroker(c config.Config) eventhub.Broker {
+	if c.Events.Broker != "redis" {
+		return eventhub.NewMemoryBroker()
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     c.Events.Redis.Addr,
+		Password: c.Events.Redis.Password,
+		DB:       c.Events.Redis.DB,
+	})
+	return eventhub.NewRedisBroker(client, c.Events.Redis.Channel)
+}
+
+func newMailer(c config.Config) mailer.Mailer {
+	switch c.Mail.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     c.Mail.SMTP.Host,
+			Port:     c.Mail.SMTP.Port,
+			Username: c.Mail.SMTP.Username,
+			Password: c.Mail.SMTP.Password,
+			From:     c.Mail.From,
+		})
+	case "file":
+		return mailer.NewFileMailer(c.Mail.From, c.Mail.Path)
+	default:
+		return mailer.NewLogMailer(c.Mail.From)
+	}
+}
+
+func newLoginGuard(c config.Config, db *sql.DB) *loginguard.Guard {
+	window := time.Duration(c.Login.Window) * time.Second
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	lockFor := time.Duration(c.Login.LockFor) * time.Second
+
+	var store loginguard.Store
+	switch c.Login.Store {
+	case "mysql":
+		store = loginguard.NewMySQLStore(db, window)
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     c.Login.Redis.Addr,
+			Password: c.Login.Redis.Password,
+			DB:       c.Login.Redis.DB,
+		})
+		store = loginguard.NewRedisStore(client, c.Login.Redis.Prefix, window)
+	default:
+		store = loginguard.NewMemoryStore(window)
 	}
+
+	// An address only gets locked out, without backoff, so users behind a
+	// shared NAT are not slowed down by each other's typos.
+	return loginguard.New(store, loginguard.Policy{
+		FreeAttempts: c.Login.FreeAttempts,
+		BaseDelay:    time.Duration(c.Login.BaseDelay) * time.Second,
+		MaxDelay:     time.Duration(c.Login.MaxDelay) * time.Second,
+		LockAfter:    c.Login.LockAfter,
+		LockFor:      lockFor,
+	}, loginguard.Policy{
+		FreeAttempts: c.Login.IPLockAfter,
+		LockAfter:    c.Login.IPLockAfter,
+		LockFor:      lockFor,
+	})
 }
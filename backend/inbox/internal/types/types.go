@@ -4,46 +4,125 @@
 package types
 
 type ListMessagesRequest struct {
-	Page    int64  `form:"page,default=1"`
-	Size    int64  `form:"size,default=20"`
-	Status  string `form:"status,options=all|unread|sent,default=all"`
-	Channel string `form:"channel,options=personal|system,default=personal"`
-	UserId  int64  `json:"-"`
+	Page      int64  `form:"page,default=1"`
+	Size      int64  `form:"size,default=20"`
+	Status    string `form:"status,options=all|unread|sent|trash|archived|starred,default=all"`
+	Channel   string `form:"channel,options=personal|system|all,default=personal"`
+	Cursor    string `form:"cursor,optional"`
+	SkipTotal bool   `form:"skipTotal,optional"`
+	Label     string `form:"label,optional"`
+	GroupId   int64  `form:"groupId,optional"`
+	UserId    int64  `json:"-"`
 }
 
 type ListMessagesResponse struct {
-	Items []Message `json:"items"`
-	Total int64     `json:"total"`
-	Page  int64     `json:"page"`
-	Size  int64     `json:"size"`
+	Items      []Message `json:"items"`
+	Total      int64     `json:"total"`
+	Page       int64     `json:"page"`
+	Size       int64     `json:"size"`
+	NextCursor string    `json:"nextCursor,omitempty"`
 }
 
 type MarkReadRequest struct {
-	Id      int64  `path:"id"`
-	Channel string `json:"channel,options=personal|system,default=personal"`
-	UserId  int64  `json:"-"`
+	Id             int64  `path:"id"`
+	Channel        string `json:"channel,options=personal|system,default=personal"`
+	NotificationId int64  `json:"notificationId,optional"`
+	UserId         int64  `json:"-"`
 }
 
 type Message struct {
-	Id         int64  `json:"id"`
-	SenderId   int64  `json:"senderId"`
-	ReceiverId int64  `json:"receiverId"`
-	Title      string `json:"title"`
-	Content    string `json:"content"`
-	IsRead     bool   `json:"isRead"`
-	ReadAt     string `json:"readAt,optional"`
-	CreatedAt  string `json:"createdAt"`
-	Channel    string `json:"channel"`
-	Priority   string `json:"priority,optional"`
+	Id             int64              `json:"id"`
+	Uid            string             `json:"uid"`
+	SenderId       int64              `json:"senderId"`
+	ReceiverId     int64              `json:"receiverId"`
+	Title          string             `json:"title"`
+	Content        string             `json:"content"`
+	IsRead         bool               `json:"isRead"`
+	ReadAt         string             `json:"readAt,optional"`
+	CreatedAt      string             `json:"createdAt"`
+	Channel        string             `json:"channel"`
+	Priority       string             `json:"priority,optional"`
+	ThreadId       int64              `json:"threadId,optional"`
+	ReplyToId      int64              `json:"replyToId,optional"`
+	NotificationId int64              `json:"notificationId,optional"`
+	GroupId        int64              `json:"groupId,optional"`
+	Starred        bool               `json:"starred"`
+	Archived       bool               `json:"archived"`
+	Labels         []string           `json:"labels,omitempty"`
+	Recipients     []MessageRecipient `json:"recipients,omitempty"`
+	Delivery       *DeliveryStatus    `json:"delivery,omitempty"`
+	Sender         *UserSummary       `json:"sender,omitempty"`
+	Receiver       *UserSummary       `json:"receiver,omitempty"`
+}
+
+type UserSummary struct {
+	Id          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	AvatarUrl   string `json:"avatarUrl,optional"`
+}
+
+type MessageRecipient struct {
+	UserId  int64        `json:"userId"`
+	Kind    string       `json:"kind"`
+	GroupId int64        `json:"groupId,optional"`
+	IsRead  bool         `json:"isRead"`
+	ReadAt  string       `json:"readAt,optional"`
+	User    *UserSummary `json:"user,omitempty"`
 }
 
 type SendMessageRequest struct {
-	Channel    string `json:"channel,options=personal|system,default=personal"`
-	SenderId   int64  `json:"senderId,optional"`
-	ReceiverId int64  `json:"receiverId,required"`
-	Title      string `json:"title,optional"`
-	Content    string `json:"content,required"`
-	Priority   string `json:"priority,options=info|warning|critical,default=info"`
+	Channel           string   `json:"channel,options=personal|system,default=personal"`
+	SenderId          int64    `json:"senderId,optional"`
+	ReceiverId        int64    `json:"receiverId,optional"`
+	Title             string   `json:"title,optional"`
+	Content           string   `json:"content,required"`
+	Priority          string   `json:"priority,options=info|warning|critical,default=info"`
+	Audience          string   `json:"audience,options=single|all|users|segment|global,default=single"`
+	ReceiverIds       []int64  `json:"receiverIds,optional"`
+	Segment           string   `json:"segment,optional"`
+	ReplyToId         int64    `json:"replyToId,optional"`
+	CcIds             []int64  `json:"ccIds,optional"`
+	BccIds            []int64  `json:"bccIds,optional"`
+	ReplyAll          bool     `json:"replyAll,optional"`
+	Targets           []string `json:"targets,optional"`
+	ReceiverUsername  string   `json:"receiverUsername,optional"`
+	ReceiverUsernames []string `json:"receiverUsernames,optional"`
+}
+
+type DeliveryStatus struct {
+	NotificationId int64  `json:"notificationId"`
+	Audience       string `json:"audience"`
+	Segment        string `json:"segment,optional"`
+	Status         string `json:"status"`
+	Total          int64  `json:"total"`
+	Delivered      int64  `json:"delivered"`
+	CreatedAt      string `json:"createdAt"`
+	FinishedAt     string `json:"finishedAt,optional"`
+}
+
+type DeliveryStatusRequest struct {
+	Id int64 `path:"id"`
+}
+
+type EventsRequest struct {
+	LastEventId      string `header:"Last-Event-Id,optional"`
+	LastEventIdQuery string `form:"lastEventId,optional"`
+	UserId           int64  `json:"-"`
+}
+
+type StreamTicketRequest struct {
+	UserId int64 `json:"-"`
+}
+
+type StreamTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int64  `json:"expiresIn"`
+}
+
+type WebSocketRequest struct {
+	LastEventId string `form:"lastEventId,optional"`
+	UserId      int64  `json:"-"`
 }
 
 type UnreadCountRequest struct {
@@ -56,22 +135,378 @@ type UnreadCountResponse struct {
 	Total    int64 `json:"total"`
 }
 
+type MessageDetailRequest struct {
+	Id             int64  `path:"id"`
+	Channel        string `form:"channel,options=personal|system,default=personal"`
+	NotificationId int64  `form:"notificationId,optional"`
+	MarkRead       bool   `form:"markRead,optional"`
+	Trash          bool   `form:"trash,optional"`
+	UserId         int64  `json:"-"`
+}
+
+type InboxItemRequest struct {
+	Uid      string `path:"uid"`
+	MarkRead bool   `form:"markRead,optional"`
+	Trash    bool   `form:"trash,optional"`
+	UserId   int64  `json:"-"`
+}
+
+type TrashMessageRequest struct {
+	Id     int64 `path:"id"`
+	UserId int64 `json:"-"`
+}
+
+type InboxItemFlagRequest struct {
+	Uid    string `path:"uid"`
+	UserId int64  `json:"-"`
+}
+
+type Label struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	Color     string `json:"color,optional"`
+	Count     int64  `json:"count"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type ListLabelsRequest struct {
+	UserId int64 `json:"-"`
+}
+
+type ListLabelsResponse struct {
+	Items []Label `json:"items"`
+}
+
+type CreateLabelRequest struct {
+	Name   string `json:"name,required"`
+	Color  string `json:"color,optional"`
+	UserId int64  `json:"-"`
+}
+
+type UpdateLabelRequest struct {
+	Id     int64  `path:"id"`
+	Name   string `json:"name,optional"`
+	Color  string `json:"color,optional"`
+	UserId int64  `json:"-"`
+}
+
+type DeleteLabelRequest struct {
+	Id     int64 `path:"id"`
+	UserId int64 `json:"-"`
+}
+
+type InboxItemLabelRequest struct {
+	Uid     string `path:"uid"`
+	LabelId int64  `path:"labelId"`
+	UserId  int64  `json:"-"`
+}
+
+type Group struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,optional"`
+	MemberCount int64  `json:"memberCount"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+type ListGroupsResponse struct {
+	Items []Group `json:"items"`
+}
+
+type CreateGroupRequest struct {
+	Name        string `json:"name,required"`
+	Description string `json:"description,optional"`
+	UserId      int64  `json:"-"`
+}
+
+type UpdateGroupRequest struct {
+	Id          int64  `path:"id"`
+	Name        string `json:"name,optional"`
+	Description string `json:"description,optional"`
+}
+
+type GroupRequest struct {
+	Id int64 `path:"id"`
+}
+
+type GroupMember struct {
+	UserId   int64  `json:"userId"`
+	Username string `json:"username"`
+	JoinedAt string `json:"joinedAt"`
+}
+
+type GroupMembersResponse struct {
+	Items []GroupMember `json:"items"`
+}
+
+type AddGroupMembersRequest struct {
+	Id      int64   `path:"id"`
+	UserIds []int64 `json:"userIds"`
+}
+
+type RemoveGroupMemberRequest struct {
+	Id       int64 `path:"id"`
+	MemberId int64 `path:"userId"`
+}
+
+type SearchMessagesRequest struct {
+	Q        string `form:"q"`
+	Channel  string `form:"channel,options=personal|system|all,default=all"`
+	SenderId int64  `form:"senderId,optional"`
+	Since    string `form:"since,optional"`
+	Until    string `form:"until,optional"`
+	Priority string `form:"priority,optional,options=info|warning|critical"`
+	Page     int64  `form:"page,default=1"`
+	Size     int64  `form:"size,default=20"`
+	UserId   int64  `json:"-"`
+}
+
+type SearchHit struct {
+	Message Message `json:"message"`
+	Score   float64 `json:"score"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchMessagesResponse struct {
+	Items []SearchHit `json:"items"`
+	Total int64       `json:"total"`
+	Page  int64       `json:"page"`
+	Size  int64       `json:"size"`
+}
+
+type MarkReadBatchRequest struct {
+	PersonalIds     []int64 `json:"personalIds,optional"`
+	SystemIds       []int64 `json:"systemIds,optional"`
+	NotificationIds []int64 `json:"notificationIds,optional"`
+	UserId          int64   `json:"-"`
+}
+
+type MarkAllReadRequest struct {
+	Channel  string `json:"channel,options=personal|system|all,default=all"`
+	Before   string `json:"before,optional"`
+	Priority string `json:"priority,optional,options=info|warning|critical"`
+	UserId   int64  `json:"-"`
+}
+
 type RegisterRequest struct {
 	Username string `json:"username,required"`
 	Password string `json:"password,required"`
+	Email    string `json:"email,optional"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username,required"`
-	Password string `json:"password,required"`
+	Username  string `json:"username,required"`
+	Password  string `json:"password,required"`
+	Ip        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type User struct {
 	Id       int64  `json:"id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token             string `json:"token"`
+	RefreshToken      string `json:"refreshToken"`
+	ExpiresIn         int64  `json:"expiresIn"`
+	User              User   `json:"user"`
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty"`
+	ChallengeToken    string `json:"challengeToken,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken,required"`
+}
+
+type LogoutRequest struct {
+	All    bool  `json:"all,optional"`
+	UserId int64 `json:"-"`
+}
+
+type UserProfile struct {
+	Id               int64  `json:"id"`
+	Username         string `json:"username"`
+	Role             string `json:"role"`
+	DisplayName      string `json:"displayName"`
+	AvatarUrl        string `json:"avatarUrl"`
+	Locale           string `json:"locale"`
+	Timezone         string `json:"timezone"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"emailVerified"`
+	TwoFactorEnabled bool   `json:"twoFactorEnabled"`
+	CreatedAt        string `json:"createdAt"`
+}
+
+type ProfileRequest struct {
+	UserId int64 `json:"-"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"displayName,optional"`
+	AvatarUrl   *string `json:"avatarUrl,optional"`
+	Locale      *string `json:"locale,optional"`
+	Timezone    *string `json:"timezone,optional"`
+	UserId      int64   `json:"-"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword,required"`
+	NewPassword string `json:"newPassword,required"`
+	UserId      int64  `json:"-"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email,required"`
+	CurrentPassword string `json:"currentPassword,required"`
+	UserId          int64  `json:"-"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token,required"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email,required"`
+}
+
+type ConfirmPasswordResetRequest struct {
+	Token       string `json:"token,required"`
+	NewPassword string `json:"newPassword,required"`
+}
+
+type SearchUsersRequest struct {
+	Q      string `form:"q,optional"`
+	Limit  int    `form:"limit,default=20"`
+	UserId int64  `json:"-"`
+}
+
+type SearchUsersResponse struct {
+	Items []UserSummary `json:"items"`
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSResponse struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type ReplyMessageRequest struct {
+	Id       int64  `path:"id"`
+	Title    string `json:"title,optional"`
+	Content  string `json:"content,required"`
+	ReplyAll bool   `json:"replyAll,optional"`
+	UserId   int64  `json:"-"`
+}
+
+type Conversation struct {
+	ThreadId     int64   `json:"threadId"`
+	PeerId       int64   `json:"peerId"`
+	LastMessage  Message `json:"lastMessage"`
+	UnreadCount  int64   `json:"unreadCount"`
+	MessageCount int64   `json:"messageCount"`
+}
+
+type ListConversationsRequest struct {
+	Page   int64 `form:"page,default=1"`
+	Size   int64 `form:"size,default=20"`
+	UserId int64 `json:"-"`
+}
+
+type ListConversationsResponse struct {
+	Items []Conversation `json:"items"`
+	Total int64          `json:"total"`
+	Page  int64          `json:"page"`
+	Size  int64          `json:"size"`
+}
+
+type ThreadMessagesRequest struct {
+	ThreadId int64 `path:"threadId"`
+	Page     int64 `form:"page,default=1"`
+	Size     int64 `form:"size,default=20"`
+	UserId   int64 `json:"-"`
+}
+
+type MarkInboxItemReadRequest struct {
+	Uid    string `path:"uid"`
+	UserId int64  `json:"-"`
+}
+
+type LoginAuditRequest struct {
+	Username string `form:"username,optional"`
+	UserId   int64  `form:"userId,optional"`
+	Ip       string `form:"ip,optional"`
+	Outcome  string `form:"outcome,optional,options=success|failure|throttled|locked|challenged"`
+	Page     int64  `form:"page,default=1"`
+	Size     int64  `form:"size,default=20"`
+}
+
+type LoginAuditEntry struct {
+	Id        int64  `json:"id"`
+	UserId    int64  `json:"userId,omitempty"`
+	Username  string `json:"username"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Outcome   string `json:"outcome"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type LoginAuditResponse struct {
+	Items []LoginAuditEntry `json:"items"`
+	Total int64             `json:"total"`
+	Page  int64             `json:"page"`
+	Size  int64             `json:"size"`
+}
+
+type UnlockUserRequest struct {
+	Id int64  `path:"id"`
+	Ip string `json:"ip,optional"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challengeToken,required"`
+	Code           string `json:"code,required"`
+	Ip             string `json:"-"`
+	UserAgent      string `json:"-"`
+}
+
+type TwoFactorSetupRequest struct {
+	UserId int64 `json:"-"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauthUri"`
+}
+
+type EnableTwoFactorRequest struct {
+	Password string `json:"password,required"`
+	Code     string `json:"code,required"`
+	UserId   int64  `json:"-"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password,required"`
+	Code     string `json:"code,required"`
+	UserId   int64  `json:"-"`
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	Code   string `json:"code,required"`
+	UserId int64  `json:"-"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
 }
@@ -30,6 +30,7 @@ export interface ListMessagesResponse {
 export interface User {
   id: number
   username: string
+  role: 'user' | 'admin' | 'service'
 }
 
 export interface AuthRequest {
@@ -39,6 +40,8 @@ export interface AuthRequest {
 
 export interface AuthResponse {
   token: string
+  refreshToken: string
+  expiresIn: number
   user: User
 }
 
@@ -55,6 +58,38 @@ export const setAuthToken = (token: string | null) => {
   }
 }
 
+type RefreshHandler = () => Promise<string | null>
+
+let refreshHandler: RefreshHandler | null = null
+let pendingRefresh: Promise<string | null> | null = null
+
+// 注册 401 时换发 access token 的回调，返回新 token 或 null（需重新登录）
+export const setRefreshHandler = (handler: RefreshHandler | null) => {
+  refreshHandler = handler
+}
+
+http.interceptors.response.use(undefined, async (error) => {
+  const config = error.config
+  if (error.response?.status !== 401 || !config || config._retried || !refreshHandler) {
+    return Promise.reject(error)
+  }
+  if (config.url?.startsWith('/auth/')) {
+    return Promise.reject(error)
+  }
+
+  pendingRefresh ??= refreshHandler().finally(() => {
+    pendingRefresh = null
+  })
+  const token = await pendingRefresh
+  if (!token) {
+    return Promise.reject(error)
+  }
+
+  config._retried = true
+  config.headers.Authorization = `Bearer ${token}`
+  return http.request(config)
+})
+
 export const authApi = {
   async register(payload: AuthRequest): Promise<AuthResponse> {
     const { data } = await http.post<AuthResponse>('/auth/register', payload)
@@ -63,6 +98,13 @@ export const authApi = {
   async login(payload: AuthRequest): Promise<AuthResponse> {
     const { data } = await http.post<AuthResponse>('/auth/login', payload)
     return data
+  },
+  async refresh(refreshToken: string): Promise<AuthResponse> {
+    const { data } = await http.post<AuthResponse>('/auth/refresh', { refreshToken })
+    return data
+  },
+  async logout(all = false): Promise<void> {
+    await http.post('/auth/logout', { all })
   }
 }
 
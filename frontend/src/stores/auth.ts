@@ -1,12 +1,21 @@
 import { defineStore } from 'pinia'
-import { authApi, setAuthToken, type AuthRequest, type AuthResponse, type User } from '@/api/client'
+import {
+  authApi,
+  setAuthToken,
+  setRefreshHandler,
+  type AuthRequest,
+  type AuthResponse,
+  type User
+} from '@/api/client'
 
 const TOKEN_KEY = 'msgdemo_token'
 const USER_KEY = 'msgdemo_user'
+const REFRESH_KEY = 'msgdemo_refresh_token'
 
 interface AuthState {
   user: User | null
   token: string
+  refreshToken: string
   loading: boolean
   error: string | null
 }
@@ -15,6 +24,7 @@ export const useAuthStore = defineStore('auth', {
   state: (): AuthState => ({
     user: null,
     token: '',
+    refreshToken: '',
     loading: false,
     error: null
   }),
@@ -29,7 +39,9 @@ export const useAuthStore = defineStore('auth', {
         try {
           this.user = JSON.parse(userRaw) as User
           this.token = token
+          this.refreshToken = localStorage.getItem(REFRESH_KEY) ?? ''
           setAuthToken(token)
+          setRefreshHandler(() => this.refresh())
         } catch (error) {
           console.warn('无法解析本地用户信息', error)
           this.clearSession()
@@ -42,7 +54,29 @@ export const useAuthStore = defineStore('auth', {
     async login(payload: AuthRequest) {
       return this.authenticate(() => authApi.login(payload))
     },
-    logout() {
+    async refresh(): Promise<string | null> {
+      if (!this.refreshToken) {
+        this.clearSession()
+        return null
+      }
+      try {
+        const result = await authApi.refresh(this.refreshToken)
+        this.saveSession(result)
+        return result.token
+      } catch (error) {
+        console.warn('刷新登录状态失败', error)
+        this.clearSession()
+        return null
+      }
+    },
+    async logout() {
+      if (this.token) {
+        try {
+          await authApi.logout()
+        } catch (error) {
+          console.warn('注销会话失败', error)
+        }
+      }
       this.clearSession()
     },
     async authenticate(fn: () => Promise<AuthResponse>) {
@@ -50,11 +84,8 @@ export const useAuthStore = defineStore('auth', {
       this.error = null
       try {
         const result = await fn()
-        this.user = result.user
-        this.token = result.token
-        setAuthToken(result.token)
-        localStorage.setItem(TOKEN_KEY, result.token)
-        localStorage.setItem(USER_KEY, JSON.stringify(result.user))
+        this.saveSession(result)
+        setRefreshHandler(() => this.refresh())
         return result
       } catch (error) {
         this.error = error instanceof Error ? error.message : '认证失败'
@@ -63,11 +94,23 @@ export const useAuthStore = defineStore('auth', {
         this.loading = false
       }
     },
+    saveSession(result: AuthResponse) {
+      this.user = result.user
+      this.token = result.token
+      this.refreshToken = result.refreshToken
+      setAuthToken(result.token)
+      localStorage.setItem(TOKEN_KEY, result.token)
+      localStorage.setItem(REFRESH_KEY, result.refreshToken)
+      localStorage.setItem(USER_KEY, JSON.stringify(result.user))
+    },
     clearSession() {
       this.user = null
       this.token = ''
+      this.refreshToken = ''
       setAuthToken(null)
+      setRefreshHandler(null)
       localStorage.removeItem(TOKEN_KEY)
+      localStorage.removeItem(REFRESH_KEY)
       localStorage.removeItem(USER_KEY)
     }
   }